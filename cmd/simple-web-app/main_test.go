@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+)
+
+func Test_Shutdown(t *testing.T) {
+	var stopped []string
+	record := func(name string, err error) step {
+		return step{name, func(ctx context.Context) error {
+			stopped = append(stopped, name)
+			return err
+		}}
+	}
+
+	failed := errors.New("still running")
+	err := shutdown(time.Second, []step{
+		record("http server", nil),
+		record("scheduler", failed),
+		record("rabbitmq", nil),
+		record("database", nil),
+	})
+
+	if !errors.Is(err, failed) {
+		t.Errorf("Expected the scheduler's error, got %v", err)
+	}
+	if want := []string{"http server", "scheduler", "rabbitmq", "database"}; !reflect.DeepEqual(stopped, want) {
+		t.Errorf("Expected the steps to stop in order %v, got %v", want, stopped)
+	}
+}
+
+func Test_ShutdownTimeout(t *testing.T) {
+	var closed bool
+	start := time.Now()
+
+	err := shutdown(20*time.Millisecond, []step{
+		{"rabbitmq", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{"database", func(ctx context.Context) error {
+			closed = true
+			return nil
+		}},
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if !closed {
+		t.Error("Expected the database to be closed after a step timed out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the timeout to be shared by the steps, took %s", elapsed)
+	}
+}
+
+func Test_Topology(t *testing.T) {
+	r := rabbitmq.NewRabbitMQ(rabbitmq.Config{Dialer: func(string) (rabbitmq.Connection, error) {
+		return nil, errors.New("no broker")
+	}})
+	defer r.Close()
+	topology(r, nil)
+
+	top := r.Topology()
+	exchanges := map[string]bool{}
+	for _, e := range top.Exchanges {
+		exchanges[e.Name] = true
+	}
+	for _, name := range []string{"events", "orders", "returns", "users", rabbitmq.DeadLetterExchange} {
+		if !exchanges[name] {
+			t.Errorf("Expected exchange %s, got %+v", name, top.Exchanges)
+		}
+	}
+
+	bound := false
+	for _, b := range top.Bindings {
+		bound = bound || b == rabbitmq.BindingSpec{Queue: "entity", Exchange: "events", Key: "orders"}
+	}
+	if !bound {
+		t.Errorf("Expected the entity queue to get the orders, got %+v", top.Bindings)
+	}
+}
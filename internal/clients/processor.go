@@ -0,0 +1,135 @@
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+var (
+	ErrUnauthorized = errors.New("invalid client credentials")
+	ErrNotFound     = errors.New("client not found")
+	ErrInvalidTTL   = errors.New("invalid pending order ttl")
+)
+
+// MaxPendingOrderTTL is the longest ttl a client can have; it is stored as a
+// number of seconds in an INT column.
+const MaxPendingOrderTTL = math.MaxInt32 * time.Second
+
+type Processor interface {
+	Authenticate(context.Context, string, string) (pgtype.UUID, error)
+	CreateClient(context.Context, string) (*Client, error)
+	SetPendingOrderTTL(context.Context, string, *time.Duration) error
+}
+
+type processor struct {
+	queries *db.Queries
+}
+
+func NewProcessor(d db.DBTX) *processor {
+	return &processor{
+		queries: db.New(d),
+	}
+}
+
+// Represents a merchant calling the API. Secret is only set on the client
+// returned by CreateClient; only its hash is stored.
+type Client struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+	Secret   string `json:"secret,omitempty"`
+}
+
+// Authenticate checks the client's secret and returns its id, which is the
+// tenant every order and return of the client is scoped by.
+func (p *processor) Authenticate(ctx context.Context, clientID string, secret string) (pgtype.UUID, error) {
+	var id pgtype.UUID
+	if err := id.Scan(clientID); err != nil {
+		return id, ErrUnauthorized
+	}
+
+	c, err := p.queries.GetClient(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return id, ErrUnauthorized
+		}
+		return id, errors.Wrap(err, "failed to fetch client")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(c.SecretHash)) != 1 {
+		return id, ErrUnauthorized
+	}
+
+	return c.ID, nil
+}
+
+func (p *processor) CreateClient(ctx context.Context, name string) (*Client, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "failed to generate client secret")
+	}
+	secret := hex.EncodeToString(b)
+
+	c, err := p.queries.CreateClient(ctx, &db.CreateClientParams{
+		Name:       name,
+		SecretHash: hashSecret(secret),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create client")
+	}
+
+	return &Client{
+		ClientID: c.ID.String(),
+		Name:     c.Name,
+		Secret:   secret,
+	}, nil
+}
+
+// SetPendingOrderTTL sets how long the client's orders may stay pending before
+// they are cancelled as expired. A nil ttl falls back to the default, 0 never
+// expires them.
+func (p *processor) SetPendingOrderTTL(ctx context.Context, clientID string, ttl *time.Duration) error {
+	var id pgtype.UUID
+	if err := id.Scan(clientID); err != nil {
+		return ErrNotFound
+	}
+
+	var seconds pgtype.Int4
+	if ttl != nil {
+		if *ttl < 0 {
+			return errors.Wrapf(ErrInvalidTTL, "must not be negative, got %s", ttl)
+		}
+		if *ttl > MaxPendingOrderTTL {
+			return errors.Wrapf(ErrInvalidTTL, "must be at most %s, got %s", MaxPendingOrderTTL, ttl)
+		}
+		seconds = pgtype.Int4{Int32: int32(ttl.Seconds()), Valid: true}
+	}
+
+	_, err := p.queries.SetClientPendingOrderTTL(ctx, &db.SetClientPendingOrderTTLParams{
+		ID:                     id,
+		PendingOrderTtlSeconds: seconds,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "failed to set pending order ttl")
+	}
+
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
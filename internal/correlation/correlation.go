@@ -0,0 +1,35 @@
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// Header is the HTTP header a request's correlation id travels in. It's
+// carried on as the correlation id of the messages published on its behalf.
+const Header = "X-Request-Id"
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the correlation id. It is set by
+// the HTTP middleware and by the consumer from the message it handles.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKey{}).(string)
+	return id, ok && id != ""
+}
+
+// NewID returns a random (version 4) UUID
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("correlation: failed to read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,29 @@
+package correlation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func Test_CorrelationContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("Expected no correlation id")
+	}
+
+	id := NewID()
+	got, ok := FromContext(NewContext(context.Background(), id))
+	if !ok || got != id {
+		t.Errorf("Expected %s, got %s", id, got)
+	}
+
+	// ids are UUIDs, so they can be stored as such
+	var uuid pgtype.UUID
+	if err := uuid.Scan(id); err != nil {
+		t.Errorf("Expected a UUID %s", err)
+	}
+	if NewID() == id {
+		t.Error("Expected ids to be unique")
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+)
+
+// Conn is a DBTX that can also start transactions. It is satisfied by both
+// *pgx.Conn and *pgxpool.Pool.
+type Conn interface {
+	DBTX
+	Begin(context.Context) (pgx.Tx, error)
+}
+
+// BeginTenant starts a transaction and sets app.tenant_id for its duration so
+// the row-level security policies only expose the tenant's rows.
+func BeginTenant(ctx context.Context, c Conn, tenantID pgtype.UUID) (pgx.Tx, error) {
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := New(tx).SetTenant(ctx, tenantID.String()); err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// IsTransient reports whether err is a database failure that may go away when
+// the work is tried again: the connection couldn't be made or was lost, the
+// server is out of connections or shutting down, or the transaction lost a
+// serialization conflict or a deadlock.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"53300", // too_many_connections
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		// class 08, connection exceptions
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	// acquiring a connection from the pool or reaching the server timed out
+	if pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return pgconn.SafeToRetry(err)
+}
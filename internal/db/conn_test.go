@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+func Test_IsTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "nil", err: nil},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, transient: true},
+		{name: "deadlock", err: errors.Wrap(&pgconn.PgError{Code: "40P01"}, "failed to create order"), transient: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, transient: true},
+		{name: "too many connections", err: &pgconn.PgError{Code: "53300"}, transient: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, transient: true},
+		{name: "timeout", err: errors.Wrap(context.DeadlineExceeded, "failed to begin transaction"), transient: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "no rows", err: pgx.ErrNoRows},
+		{name: "other", err: io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.transient {
+				t.Errorf("Expected %v to be transient: %t, got %t", tt.err, tt.transient, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS return_items;
+DROP TABLE IF EXISTS returns;
+DROP TYPE return_status;
+
+-- Postgres cannot drop enum values, so recreate order_status without the return states
+UPDATE orders SET status = 'delivered' WHERE status IN ('partially_returned', 'returned');
+ALTER TYPE order_status RENAME TO order_status_old;
+CREATE TYPE order_status AS ENUM ('pending', 'shipped', 'delivered', 'cancelled');
+ALTER TABLE orders
+    ALTER COLUMN status DROP DEFAULT,
+    ALTER COLUMN status TYPE order_status USING status::text::order_status,
+    ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_old;
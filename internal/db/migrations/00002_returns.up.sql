@@ -0,0 +1,30 @@
+-- 1. Extend the order status enum with the return states
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'partially_returned';
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'returned';
+
+-- 2. Create a custom enum type for return status
+CREATE TYPE return_status AS ENUM ('requested', 'approved', 'rejected', 'received', 'inspected');
+
+-- 3. Create a returns table (one RMA per customer request)
+CREATE TABLE returns (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id           UUID NOT NULL REFERENCES orders(id),         -- the order the items are returned against
+    status             return_status NOT NULL DEFAULT 'requested',  -- current status of the return
+    reason             TEXT NOT NULL,                               -- reason given by the customer
+    rejection_reason   TEXT,                                        -- set when the return is rejected
+    refund_amount      NUMERIC(10,2) NOT NULL,                      -- sum of the return items refund amounts
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),          -- timestamp of return creation
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()           -- timestamp of last update
+);
+
+-- 4. Create a return_items table
+CREATE TABLE return_items (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    return_id          UUID NOT NULL REFERENCES returns(id),        -- links back to the parent return
+    order_item_id      UUID NOT NULL REFERENCES order_items(id),    -- the order item being returned
+    quantity           INT NOT NULL,                                -- quantity the customer asked to return
+    accepted_quantity  INT,                                         -- quantity accepted after inspection
+    refund_amount      NUMERIC(10,2) NOT NULL,                      -- refunded quantity * order_items.price
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),          -- timestamp of return item creation
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()           -- timestamp of last update
+);
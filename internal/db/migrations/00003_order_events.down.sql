@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_events;
+DROP FUNCTION IF EXISTS order_events_append_only;
+DROP TYPE order_event_type;
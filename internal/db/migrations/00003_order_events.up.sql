@@ -0,0 +1,50 @@
+-- 1. Create a custom enum type for the kinds of change recorded against an order
+CREATE TYPE order_event_type AS ENUM ('order_created', 'item_added', 'status_changed', 'address_corrected');
+
+-- 2. Create an append-only order_events table. orders, order_items and addresses
+--    are projections of these events and can be rebuilt from them.
+CREATE TABLE order_events (
+    id                 BIGSERIAL PRIMARY KEY,
+    order_id           UUID NOT NULL,                               -- the order the event belongs to (no FK: events outlive projections)
+    version            INT NOT NULL,                                -- position of the event in the order's stream, starting at 1
+    event_type         order_event_type NOT NULL,
+    payload            JSONB NOT NULL,                              -- event body, see internal/orders/events.go
+    occurred_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),          -- timestamp the change happened
+    UNIQUE (order_id, version)
+);
+
+-- 3. Reject any attempt to change or remove recorded events
+CREATE FUNCTION order_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'order_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER order_events_append_only
+    BEFORE UPDATE OR DELETE ON order_events
+    FOR EACH ROW EXECUTE FUNCTION order_events_append_only();
+
+-- 4. Seed the event stream of existing orders from their current state
+INSERT INTO order_events (order_id, version, event_type, payload, occurred_at)
+SELECT o.id, 1, 'order_created', jsonb_build_object(
+    'user_id', o.user_id,
+    'total_amount', o.total_amount,
+    'status', o.status,
+    'shipping_address', (SELECT to_jsonb(a) FROM addresses a WHERE a.id = o.shipping_address_id),
+    'billing_address', (SELECT to_jsonb(a) FROM addresses a WHERE a.id = o.billing_address_id)
+), o.created_at
+FROM orders o;
+
+INSERT INTO order_events (order_id, version, event_type, payload, occurred_at)
+SELECT i.order_id,
+       1 + ROW_NUMBER() OVER (PARTITION BY i.order_id ORDER BY i.created_at, i.id),
+       'item_added',
+       jsonb_build_object(
+           'order_item_id', i.id,
+           'product_id', i.product_id,
+           'quantity', i.quantity,
+           'price', i.price,
+           'total_price', i.total_price
+       ),
+       i.created_at
+FROM order_items i;
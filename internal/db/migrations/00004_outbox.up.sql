@@ -0,0 +1,13 @@
+-- 1. Create an outbox_messages table. Domain events are written here in the same
+--    transaction as the rows they describe and relayed to RabbitMQ afterwards.
+CREATE TABLE outbox_messages (
+    id                 BIGSERIAL PRIMARY KEY,                       -- also the relay order
+    message_type       TEXT NOT NULL,                               -- proto full name used to decode the payload
+    payload            BYTEA NOT NULL,                              -- proto encoded message
+    attempts           INT NOT NULL DEFAULT 0,                      -- number of publish attempts
+    last_error         TEXT,                                        -- error of the last failed publish
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),          -- timestamp the message was enqueued
+    sent_at            TIMESTAMPTZ                                  -- set once the broker accepted the message
+);
+
+CREATE INDEX outbox_messages_unsent_idx ON outbox_messages (id) WHERE sent_at IS NULL;
@@ -0,0 +1,25 @@
+DROP POLICY IF EXISTS tenant_isolation ON order_events;
+DROP POLICY IF EXISTS tenant_isolation ON return_items;
+DROP POLICY IF EXISTS tenant_isolation ON returns;
+DROP POLICY IF EXISTS tenant_isolation ON order_items;
+DROP POLICY IF EXISTS tenant_isolation ON orders;
+DROP POLICY IF EXISTS tenant_isolation ON addresses;
+
+ALTER TABLE order_events DISABLE ROW LEVEL SECURITY;
+ALTER TABLE return_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE returns DISABLE ROW LEVEL SECURITY;
+ALTER TABLE order_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE orders DISABLE ROW LEVEL SECURITY;
+ALTER TABLE addresses DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS orders_tenant_user_idx;
+
+ALTER TABLE outbox_messages DROP COLUMN tenant_id;
+ALTER TABLE order_events DROP COLUMN tenant_id;
+ALTER TABLE return_items DROP COLUMN tenant_id;
+ALTER TABLE returns DROP COLUMN tenant_id;
+ALTER TABLE order_items DROP COLUMN tenant_id;
+ALTER TABLE orders DROP COLUMN tenant_id;
+ALTER TABLE addresses DROP COLUMN tenant_id;
+
+DROP TABLE IF EXISTS clients;
@@ -0,0 +1,77 @@
+-- 1. Create a clients table. Every merchant calling the API is a client and
+--    every order, address and return belongs to exactly one of them.
+CREATE TABLE clients (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),  -- sent as X-Client-Id
+    name               TEXT NOT NULL,
+    secret_hash        TEXT NOT NULL,                               -- hex encoded sha256 of the client secret
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 2. Existing rows are assigned to a default client. Its empty secret hash never
+--    matches, so it can't authenticate until it's given a secret.
+INSERT INTO clients (id, name, secret_hash)
+VALUES ('00000000-0000-0000-0000-000000000000', 'default', '');
+
+-- 3. Add the tenant to every table holding client data
+ALTER TABLE addresses ADD COLUMN tenant_id UUID REFERENCES clients(id);
+ALTER TABLE orders ADD COLUMN tenant_id UUID REFERENCES clients(id);
+ALTER TABLE order_items ADD COLUMN tenant_id UUID REFERENCES clients(id);
+ALTER TABLE returns ADD COLUMN tenant_id UUID REFERENCES clients(id);
+ALTER TABLE return_items ADD COLUMN tenant_id UUID REFERENCES clients(id);
+ALTER TABLE order_events ADD COLUMN tenant_id UUID REFERENCES clients(id);
+ALTER TABLE outbox_messages ADD COLUMN tenant_id UUID REFERENCES clients(id);   -- sent as the tenant_id message header
+
+UPDATE addresses SET tenant_id = '00000000-0000-0000-0000-000000000000';
+UPDATE orders SET tenant_id = '00000000-0000-0000-0000-000000000000';
+UPDATE order_items SET tenant_id = '00000000-0000-0000-0000-000000000000';
+UPDATE returns SET tenant_id = '00000000-0000-0000-0000-000000000000';
+UPDATE return_items SET tenant_id = '00000000-0000-0000-0000-000000000000';
+
+ALTER TABLE order_events DISABLE TRIGGER order_events_append_only;
+UPDATE order_events SET tenant_id = '00000000-0000-0000-0000-000000000000';
+ALTER TABLE order_events ENABLE TRIGGER order_events_append_only;
+
+ALTER TABLE addresses ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE order_items ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE returns ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE return_items ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE order_events ALTER COLUMN tenant_id SET NOT NULL;
+
+CREATE INDEX orders_tenant_user_idx ON orders (tenant_id, user_id);
+
+-- 4. Row-level security as a backstop to the tenant_id filter of every query.
+--    The application sets app.tenant_id at the start of each transaction; rows
+--    of other tenants are invisible and can't be written. Superusers and roles
+--    with BYPASSRLS are not subject to these policies, so the application
+--    should connect as an ordinary role.
+ALTER TABLE addresses ENABLE ROW LEVEL SECURITY;
+ALTER TABLE addresses FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON addresses
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE orders ENABLE ROW LEVEL SECURITY;
+ALTER TABLE orders FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON orders
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE order_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE order_items FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON order_items
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE returns ENABLE ROW LEVEL SECURITY;
+ALTER TABLE returns FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON returns
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE return_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE return_items FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON return_items
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE order_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE order_events FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON order_events
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
@@ -0,0 +1,13 @@
+ALTER TABLE orders
+    DROP COLUMN fraud_reasons,
+    DROP COLUMN fraud_score;
+
+-- Postgres cannot drop enum values, so recreate order_status without on_hold
+UPDATE orders SET status = 'pending' WHERE status = 'on_hold';
+ALTER TYPE order_status RENAME TO order_status_old;
+CREATE TYPE order_status AS ENUM ('pending', 'shipped', 'delivered', 'cancelled', 'partially_returned', 'returned');
+ALTER TABLE orders
+    ALTER COLUMN status DROP DEFAULT,
+    ALTER COLUMN status TYPE order_status USING status::text::order_status,
+    ALTER COLUMN status SET DEFAULT 'pending';
+DROP TYPE order_status_old;
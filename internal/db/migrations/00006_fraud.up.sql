@@ -0,0 +1,7 @@
+-- 1. Orders scoring above the fraud threshold are held instead of pending
+ALTER TYPE order_status ADD VALUE 'on_hold';
+
+-- 2. Store the fraud score and the reasons behind it on the order
+ALTER TABLE orders
+    ADD COLUMN fraud_score INT NOT NULL DEFAULT 0,                   -- sum of the weights of the matched rules
+    ADD COLUMN fraud_reasons TEXT[] NOT NULL DEFAULT '{}';           -- one entry per matched rule
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_reviews;
+DROP TYPE review_status;
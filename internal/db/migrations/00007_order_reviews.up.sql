@@ -0,0 +1,25 @@
+-- 1. Create a custom enum type for the state of a manual review
+CREATE TYPE review_status AS ENUM ('open', 'escalated', 'approved', 'rejected');
+
+-- 2. Create an order_reviews table. A review is opened for every order the
+--    fraud rules put on hold and decides whether it goes ahead.
+CREATE TABLE order_reviews (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id           UUID NOT NULL UNIQUE REFERENCES orders(id),  -- the held order
+    tenant_id          UUID NOT NULL REFERENCES clients(id),
+    status             review_status NOT NULL DEFAULT 'open',
+    assignee           TEXT,                                        -- reviewer the review is assigned to
+    decided_by         TEXT,                                        -- reviewer who approved or rejected the order
+    decision_reason    TEXT,
+    escalated_at       TIMESTAMPTZ,                                 -- set when the review timed out
+    decided_at         TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX order_reviews_undecided_idx ON order_reviews (tenant_id, created_at) WHERE status IN ('open', 'escalated');
+
+ALTER TABLE order_reviews ENABLE ROW LEVEL SECURITY;
+ALTER TABLE order_reviews FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON order_reviews
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_pending_updated_idx;
+
+ALTER TABLE clients DROP COLUMN pending_order_ttl_seconds;
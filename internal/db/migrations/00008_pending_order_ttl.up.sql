@@ -0,0 +1,6 @@
+-- 1. How long a client's orders may stay pending before they are cancelled as
+--    expired. NULL falls back to SEM_PENDING_ORDER_TTL, 0 never expires them.
+ALTER TABLE clients ADD COLUMN pending_order_ttl_seconds INT CHECK (pending_order_ttl_seconds >= 0);
+
+-- 2. The expiry job looks for pending orders by when they last changed
+CREATE INDEX orders_pending_updated_idx ON orders (tenant_id, updated_at) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS jobs_runs;
+
+DROP TYPE IF EXISTS job_run_status;
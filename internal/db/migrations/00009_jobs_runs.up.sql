@@ -0,0 +1,17 @@
+-- 1. Create a job_run_status enum
+CREATE TYPE job_run_status AS ENUM ('running', 'succeeded', 'failed');
+
+-- 2. Create a jobs_runs table recording every run of a scheduled job. Runs are
+--    written by whichever replica held the job's advisory lock.
+CREATE TABLE jobs_runs (
+    id                 BIGSERIAL PRIMARY KEY,
+    job_name           TEXT NOT NULL,
+    status             job_run_status NOT NULL DEFAULT 'running',
+    instance           TEXT NOT NULL,                                 -- hostname of the replica that ran the job
+    error              TEXT,                                          -- set when the run failed
+    duration_ms        BIGINT,                                        -- set once the run finished
+    started_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at        TIMESTAMPTZ
+);
+
+CREATE INDEX jobs_runs_job_started_idx ON jobs_runs (job_name, started_at DESC);
@@ -0,0 +1,7 @@
+CREATE OR REPLACE FUNCTION order_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'order_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS erasures;
@@ -0,0 +1,39 @@
+-- 1. Create an erasures table auditing every erasure of a user's personal data.
+--    It records who asked and what was scrubbed, but no personal data itself.
+CREATE TABLE erasures (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id          UUID NOT NULL REFERENCES clients(id),
+    user_id            UUID NOT NULL,                               -- the user whose data was erased
+    requested_by       TEXT NOT NULL,                               -- the operator or system that performed the erasure
+    reason             TEXT,                                        -- e.g. a ticket reference
+    orders_count       INT NOT NULL,                                -- orders whose addresses were anonymized
+    addresses_count    INT NOT NULL,
+    events_count       INT NOT NULL,                                -- order events whose payload was redacted
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX erasures_tenant_user_idx ON erasures (tenant_id, user_id);
+
+ALTER TABLE erasures ENABLE ROW LEVEL SECURITY;
+ALTER TABLE erasures FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON erasures
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
+
+-- 2. order_events stays append-only, except that an erasure may redact the
+--    payload of an event. The erasure sets app.erasure for its transaction.
+CREATE OR REPLACE FUNCTION order_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+       AND current_setting('app.erasure', true) = 'on'
+       AND NEW.id = OLD.id
+       AND NEW.order_id = OLD.order_id
+       AND NEW.version = OLD.version
+       AND NEW.event_type = OLD.event_type
+       AND NEW.occurred_at = OLD.occurred_at
+       AND NEW.tenant_id = OLD.tenant_id THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'order_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,24 @@
+-- 1. Create a custom enum type for the state of a data export
+CREATE TYPE data_export_status AS ENUM ('pending', 'completed', 'failed');
+
+-- 2. Create a data_exports table holding subject access requests and, once
+--    generated, the archive of everything held for the user
+CREATE TABLE data_exports (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id          UUID NOT NULL REFERENCES clients(id),
+    user_id            UUID NOT NULL,                               -- the user the export is for
+    requested_by       TEXT NOT NULL,
+    status             data_export_status NOT NULL DEFAULT 'pending',
+    archive            JSONB,                                       -- set once completed
+    error              TEXT,                                        -- set once failed
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at       TIMESTAMPTZ,
+    expires_at         TIMESTAMPTZ                                  -- the archive is deleted after this
+);
+
+CREATE INDEX data_exports_pending_idx ON data_exports (tenant_id, created_at) WHERE status = 'pending';
+
+ALTER TABLE data_exports ENABLE ROW LEVEL SECURITY;
+ALTER TABLE data_exports FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON data_exports
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid);
@@ -0,0 +1,4 @@
+ALTER TABLE addresses
+    DROP CONSTRAINT addresses_key_check,
+    DROP COLUMN wrapped_key,
+    DROP COLUMN key_id;
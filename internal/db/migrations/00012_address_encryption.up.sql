@@ -0,0 +1,7 @@
+-- 1. line1, line2, city and postal_code of an address may hold ciphertext,
+--    encrypted with a data key of its own. The data key is stored wrapped by
+--    the master key key_id. Rows without a key_id are plaintext.
+ALTER TABLE addresses
+    ADD COLUMN key_id TEXT,                                          -- id of the master key in the keyfile
+    ADD COLUMN wrapped_key BYTEA,                                    -- the row's data key, encrypted with the master key
+    ADD CONSTRAINT addresses_key_check CHECK ((key_id IS NULL) = (wrapped_key IS NULL));
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS mq_messages;
+
+DROP TABLE IF EXISTS mq_bindings;
+
+DROP TABLE IF EXISTS mq_queues;
+
+DROP TABLE IF EXISTS mq_exchanges;
@@ -0,0 +1,46 @@
+-- 1. Create the tables of the Postgres message queue, used instead of RabbitMQ
+--    by deployments that don't run it. They mirror its exchanges, queues and
+--    bindings; messages are claimed with FOR UPDATE SKIP LOCKED.
+CREATE TABLE mq_exchanges (
+    name               TEXT PRIMARY KEY,
+    kind               TEXT NOT NULL                                -- fanout, direct or topic
+);
+
+CREATE TABLE mq_queues (
+    name               TEXT PRIMARY KEY,
+    arguments          JSONB NOT NULL DEFAULT '{}'                  -- x-message-ttl, x-dead-letter-exchange...
+);
+
+CREATE TABLE mq_bindings (
+    queue              TEXT NOT NULL REFERENCES mq_queues(name),
+    exchange           TEXT NOT NULL REFERENCES mq_exchanges(name),
+    key                TEXT NOT NULL,
+    PRIMARY KEY (queue, exchange, key)
+);
+
+CREATE INDEX mq_bindings_exchange_idx ON mq_bindings (exchange);
+
+-- 2. Create the messages table. A message is delivered by locking it for a
+--    lease the consumer's channel renews; acking deletes it, and a message
+--    whose lease ran out is delivered again.
+CREATE TABLE mq_messages (
+    id                 BIGSERIAL PRIMARY KEY,
+    queue              TEXT NOT NULL REFERENCES mq_queues(name),
+    exchange           TEXT NOT NULL,
+    routing_key        TEXT NOT NULL,
+    content_type       TEXT NOT NULL,
+    message_id         TEXT NOT NULL,
+    correlation_id     TEXT NOT NULL,
+    published_at       TIMESTAMPTZ,
+    headers            JSONB NOT NULL DEFAULT '{}',
+    body               BYTEA NOT NULL,
+    redelivered        BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at         TIMESTAMPTZ,                                 -- set when the queue has a ttl
+    locked_by          TEXT,                                        -- the channel it's delivered on
+    locked_until       TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX mq_messages_queue_idx ON mq_messages (queue, id);
+CREATE INDEX mq_messages_locked_by_idx ON mq_messages (locked_by) WHERE locked_by IS NOT NULL;
+CREATE INDEX mq_messages_expires_at_idx ON mq_messages (queue, expires_at) WHERE expires_at IS NOT NULL;
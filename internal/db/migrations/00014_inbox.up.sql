@@ -0,0 +1,12 @@
+-- 1. Create an inbox_messages table. Consumers record the messages they handle
+--    here in the same transaction as their changes, so a redelivered message is
+--    recognised and acknowledged without being handled twice.
+CREATE TABLE inbox_messages (
+    message_id         TEXT NOT NULL,                               -- message id property set by the publisher
+    handler            TEXT NOT NULL,                               -- a message is handled once per handler
+    tenant_id          UUID NOT NULL REFERENCES clients(id),
+    received_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),          -- entries are pruned some time after this
+    PRIMARY KEY (message_id, handler)
+);
+
+CREATE INDEX inbox_messages_received_at_idx ON inbox_messages (received_at);
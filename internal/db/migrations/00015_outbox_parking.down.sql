@@ -0,0 +1,6 @@
+DROP INDEX outbox_messages_unsent_idx;
+CREATE INDEX outbox_messages_unsent_idx ON outbox_messages (id) WHERE sent_at IS NULL;
+
+ALTER TABLE outbox_messages
+    DROP COLUMN failed_at,
+    DROP COLUMN aggregate_id;
@@ -0,0 +1,10 @@
+-- 1. Messages are relayed in order per aggregate (usually the order they
+--    describe) rather than globally, so one failing message only holds back
+--    the messages enqueued after it for the same aggregate.
+ALTER TABLE outbox_messages
+    ADD COLUMN aggregate_id TEXT NOT NULL DEFAULT '',                -- messages of the same aggregate are relayed in id order
+    ADD COLUMN failed_at TIMESTAMPTZ;                                -- set once the relay gave up on the message
+
+-- 2. Parked messages are no longer picked up by the relay
+DROP INDEX outbox_messages_unsent_idx;
+CREATE INDEX outbox_messages_unsent_idx ON outbox_messages (id) WHERE sent_at IS NULL AND failed_at IS NULL;
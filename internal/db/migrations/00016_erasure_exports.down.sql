@@ -0,0 +1,3 @@
+DROP INDEX outbox_messages_aggregate_idx;
+
+ALTER TABLE erasures DROP COLUMN exports_count;
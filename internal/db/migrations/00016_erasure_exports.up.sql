@@ -0,0 +1,6 @@
+-- 1. An erasure also deletes the user's data exports, whose archives hold the
+--    personal data being erased
+ALTER TABLE erasures ADD COLUMN exports_count INT NOT NULL DEFAULT 0;   -- data exports deleted
+
+-- 2. The notifications of a user's orders are looked up by aggregate for their exports
+CREATE INDEX outbox_messages_aggregate_idx ON outbox_messages (tenant_id, aggregate_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS orders_pending_created_idx;
+CREATE INDEX orders_pending_updated_idx ON orders (tenant_id, updated_at) WHERE status = 'pending';
@@ -0,0 +1,5 @@
+-- 1. The expiry job measures how long an order has been pending from its
+--    creation, or from its last move back into pending in order_events, so
+--    other writes to the order don't restart the clock
+DROP INDEX IF EXISTS orders_pending_updated_idx;
+CREATE INDEX orders_pending_created_idx ON orders (tenant_id, created_at) WHERE status = 'pending';
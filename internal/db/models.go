@@ -11,13 +11,177 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type DataExportStatus string
+
+const (
+	DataExportStatusPending   DataExportStatus = "pending"
+	DataExportStatusCompleted DataExportStatus = "completed"
+	DataExportStatusFailed    DataExportStatus = "failed"
+)
+
+func (e *DataExportStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = DataExportStatus(s)
+	case string:
+		*e = DataExportStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for DataExportStatus: %T", src)
+	}
+	return nil
+}
+
+type NullDataExportStatus struct {
+	DataExportStatus DataExportStatus
+	Valid            bool // Valid is true if DataExportStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullDataExportStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.DataExportStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.DataExportStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullDataExportStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.DataExportStatus), nil
+}
+
+func (e DataExportStatus) Valid() bool {
+	switch e {
+	case DataExportStatusPending,
+		DataExportStatusCompleted,
+		DataExportStatusFailed:
+		return true
+	}
+	return false
+}
+
+type JobRunStatus string
+
+const (
+	JobRunStatusRunning   JobRunStatus = "running"
+	JobRunStatusSucceeded JobRunStatus = "succeeded"
+	JobRunStatusFailed    JobRunStatus = "failed"
+)
+
+func (e *JobRunStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = JobRunStatus(s)
+	case string:
+		*e = JobRunStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for JobRunStatus: %T", src)
+	}
+	return nil
+}
+
+type NullJobRunStatus struct {
+	JobRunStatus JobRunStatus
+	Valid        bool // Valid is true if JobRunStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullJobRunStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.JobRunStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.JobRunStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullJobRunStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.JobRunStatus), nil
+}
+
+func (e JobRunStatus) Valid() bool {
+	switch e {
+	case JobRunStatusRunning,
+		JobRunStatusSucceeded,
+		JobRunStatusFailed:
+		return true
+	}
+	return false
+}
+
+type OrderEventType string
+
+const (
+	OrderEventTypeOrderCreated     OrderEventType = "order_created"
+	OrderEventTypeItemAdded        OrderEventType = "item_added"
+	OrderEventTypeStatusChanged    OrderEventType = "status_changed"
+	OrderEventTypeAddressCorrected OrderEventType = "address_corrected"
+)
+
+func (e *OrderEventType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = OrderEventType(s)
+	case string:
+		*e = OrderEventType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for OrderEventType: %T", src)
+	}
+	return nil
+}
+
+type NullOrderEventType struct {
+	OrderEventType OrderEventType
+	Valid          bool // Valid is true if OrderEventType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullOrderEventType) Scan(value interface{}) error {
+	if value == nil {
+		ns.OrderEventType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.OrderEventType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullOrderEventType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.OrderEventType), nil
+}
+
+func (e OrderEventType) Valid() bool {
+	switch e {
+	case OrderEventTypeOrderCreated,
+		OrderEventTypeItemAdded,
+		OrderEventTypeStatusChanged,
+		OrderEventTypeAddressCorrected:
+		return true
+	}
+	return false
+}
+
 type OrderStatus string
 
 const (
-	OrderStatusPending   OrderStatus = "pending"
-	OrderStatusShipped   OrderStatus = "shipped"
-	OrderStatusDelivered OrderStatus = "delivered"
-	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusPending           OrderStatus = "pending"
+	OrderStatusShipped           OrderStatus = "shipped"
+	OrderStatusDelivered         OrderStatus = "delivered"
+	OrderStatusCancelled         OrderStatus = "cancelled"
+	OrderStatusPartiallyReturned OrderStatus = "partially_returned"
+	OrderStatusReturned          OrderStatus = "returned"
+	OrderStatusOnHold            OrderStatus = "on_hold"
 )
 
 func (e *OrderStatus) Scan(src interface{}) error {
@@ -60,7 +224,122 @@ func (e OrderStatus) Valid() bool {
 	case OrderStatusPending,
 		OrderStatusShipped,
 		OrderStatusDelivered,
-		OrderStatusCancelled:
+		OrderStatusCancelled,
+		OrderStatusPartiallyReturned,
+		OrderStatusReturned,
+		OrderStatusOnHold:
+		return true
+	}
+	return false
+}
+
+type ReturnStatus string
+
+const (
+	ReturnStatusRequested ReturnStatus = "requested"
+	ReturnStatusApproved  ReturnStatus = "approved"
+	ReturnStatusRejected  ReturnStatus = "rejected"
+	ReturnStatusReceived  ReturnStatus = "received"
+	ReturnStatusInspected ReturnStatus = "inspected"
+)
+
+func (e *ReturnStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ReturnStatus(s)
+	case string:
+		*e = ReturnStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ReturnStatus: %T", src)
+	}
+	return nil
+}
+
+type NullReturnStatus struct {
+	ReturnStatus ReturnStatus
+	Valid        bool // Valid is true if ReturnStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullReturnStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.ReturnStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ReturnStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullReturnStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ReturnStatus), nil
+}
+
+func (e ReturnStatus) Valid() bool {
+	switch e {
+	case ReturnStatusRequested,
+		ReturnStatusApproved,
+		ReturnStatusRejected,
+		ReturnStatusReceived,
+		ReturnStatusInspected:
+		return true
+	}
+	return false
+}
+
+type ReviewStatus string
+
+const (
+	ReviewStatusOpen      ReviewStatus = "open"
+	ReviewStatusEscalated ReviewStatus = "escalated"
+	ReviewStatusApproved  ReviewStatus = "approved"
+	ReviewStatusRejected  ReviewStatus = "rejected"
+)
+
+func (e *ReviewStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ReviewStatus(s)
+	case string:
+		*e = ReviewStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ReviewStatus: %T", src)
+	}
+	return nil
+}
+
+type NullReviewStatus struct {
+	ReviewStatus ReviewStatus
+	Valid        bool // Valid is true if ReviewStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullReviewStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.ReviewStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ReviewStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullReviewStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ReviewStatus), nil
+}
+
+func (e ReviewStatus) Valid() bool {
+	switch e {
+	case ReviewStatusOpen,
+		ReviewStatusEscalated,
+		ReviewStatusApproved,
+		ReviewStatusRejected:
 		return true
 	}
 	return false
@@ -76,6 +355,96 @@ type Address struct {
 	Country    string
 	CreatedAt  pgtype.Timestamptz
 	UpdatedAt  pgtype.Timestamptz
+	TenantID   pgtype.UUID
+	KeyID      pgtype.Text
+	WrappedKey []byte
+}
+
+type Client struct {
+	ID                     pgtype.UUID
+	Name                   string
+	SecretHash             string
+	CreatedAt              pgtype.Timestamptz
+	UpdatedAt              pgtype.Timestamptz
+	PendingOrderTtlSeconds pgtype.Int4
+}
+
+type DataExport struct {
+	ID          pgtype.UUID
+	TenantID    pgtype.UUID
+	UserID      pgtype.UUID
+	RequestedBy string
+	Status      DataExportStatus
+	Archive     []byte
+	Error       pgtype.Text
+	CreatedAt   pgtype.Timestamptz
+	CompletedAt pgtype.Timestamptz
+	ExpiresAt   pgtype.Timestamptz
+}
+
+type Erasure struct {
+	ID             pgtype.UUID
+	TenantID       pgtype.UUID
+	UserID         pgtype.UUID
+	RequestedBy    string
+	Reason         pgtype.Text
+	OrdersCount    int32
+	AddressesCount int32
+	EventsCount    int32
+	CreatedAt      pgtype.Timestamptz
+	ExportsCount   int32
+}
+
+type InboxMessage struct {
+	MessageID  string
+	Handler    string
+	TenantID   pgtype.UUID
+	ReceivedAt pgtype.Timestamptz
+}
+
+type JobsRun struct {
+	ID         int64
+	JobName    string
+	Status     JobRunStatus
+	Instance   string
+	Error      pgtype.Text
+	DurationMs pgtype.Int8
+	StartedAt  pgtype.Timestamptz
+	FinishedAt pgtype.Timestamptz
+}
+
+type MqBinding struct {
+	Queue    string
+	Exchange string
+	Key      string
+}
+
+type MqExchange struct {
+	Name string
+	Kind string
+}
+
+type MqMessage struct {
+	ID            int64
+	Queue         string
+	Exchange      string
+	RoutingKey    string
+	ContentType   string
+	MessageID     string
+	CorrelationID string
+	PublishedAt   pgtype.Timestamptz
+	Headers       []byte
+	Body          []byte
+	Redelivered   bool
+	ExpiresAt     pgtype.Timestamptz
+	LockedBy      pgtype.Text
+	LockedUntil   pgtype.Timestamptz
+	CreatedAt     pgtype.Timestamptz
+}
+
+type MqQueue struct {
+	Name      string
+	Arguments []byte
 }
 
 type Order struct {
@@ -87,6 +456,19 @@ type Order struct {
 	Status            OrderStatus
 	CreatedAt         pgtype.Timestamptz
 	UpdatedAt         pgtype.Timestamptz
+	TenantID          pgtype.UUID
+	FraudScore        int32
+	FraudReasons      []string
+}
+
+type OrderEvent struct {
+	ID         int64
+	OrderID    pgtype.UUID
+	Version    int32
+	EventType  OrderEventType
+	Payload    []byte
+	OccurredAt pgtype.Timestamptz
+	TenantID   pgtype.UUID
 }
 
 type OrderItem struct {
@@ -98,4 +480,56 @@ type OrderItem struct {
 	TotalPrice pgtype.Numeric
 	CreatedAt  pgtype.Timestamptz
 	UpdatedAt  pgtype.Timestamptz
+	TenantID   pgtype.UUID
+}
+
+type OrderReview struct {
+	ID             pgtype.UUID
+	OrderID        pgtype.UUID
+	TenantID       pgtype.UUID
+	Status         ReviewStatus
+	Assignee       pgtype.Text
+	DecidedBy      pgtype.Text
+	DecisionReason pgtype.Text
+	EscalatedAt    pgtype.Timestamptz
+	DecidedAt      pgtype.Timestamptz
+	CreatedAt      pgtype.Timestamptz
+	UpdatedAt      pgtype.Timestamptz
+}
+
+type OutboxMessage struct {
+	ID          int64
+	MessageType string
+	Payload     []byte
+	Attempts    int32
+	LastError   pgtype.Text
+	CreatedAt   pgtype.Timestamptz
+	SentAt      pgtype.Timestamptz
+	TenantID    pgtype.UUID
+	AggregateID string
+	FailedAt    pgtype.Timestamptz
+}
+
+type Return struct {
+	ID              pgtype.UUID
+	OrderID         pgtype.UUID
+	Status          ReturnStatus
+	Reason          string
+	RejectionReason pgtype.Text
+	RefundAmount    pgtype.Numeric
+	CreatedAt       pgtype.Timestamptz
+	UpdatedAt       pgtype.Timestamptz
+	TenantID        pgtype.UUID
+}
+
+type ReturnItem struct {
+	ID               pgtype.UUID
+	ReturnID         pgtype.UUID
+	OrderItemID      pgtype.UUID
+	Quantity         int32
+	AcceptedQuantity pgtype.Int4
+	RefundAmount     pgtype.Numeric
+	CreatedAt        pgtype.Timestamptz
+	UpdatedAt        pgtype.Timestamptz
+	TenantID         pgtype.UUID
 }
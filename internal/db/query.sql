@@ -1,36 +1,37 @@
 -- name: GetOrder :one
 SELECT * FROM orders
-WHERE id = $1 LIMIT 1;
+WHERE id = $1 AND tenant_id = $2 LIMIT 1;
 
 -- name: ListOrders :many
 SELECT * FROM orders
-WHERE user_id = $1
+WHERE user_id = $1 AND tenant_id = $2
 ORDER BY updated_at;
 
 -- name: CreateOrder :one
 INSERT INTO orders (
   user_id, total_amount, status,
-  shipping_address_id, billing_address_id
+  shipping_address_id, billing_address_id, tenant_id,
+  fraud_score, fraud_reasons
 ) VALUES (
-  $1, $2, $3, $4, $5
+  $1, $2, $3, $4, $5, $6, $7, $8
 )
 RETURNING *;
 
 -- name: CreateAddress :one
 INSERT INTO addresses (
  line1, city, state, postal_code,
- country, line2
+ country, line2, tenant_id, key_id, wrapped_key
 ) VALUES (
- $1, $2, $3, $4, $5, $6
+ $1, $2, $3, $4, $5, $6, $7, $8, $9
 )
 RETURNING *;
 
 -- name: CreateOrderItem :one
 INSERT INTO order_items (
  order_id, product_id, quantity,
- price, total_price
+ price, total_price, tenant_id
 ) VALUES (
- $1, $2, $3, $4, $5
+ $1, $2, $3, $4, $5, $6
 )
 RETURNING *;
 
@@ -44,10 +45,541 @@ DELETE FROM orders RETURNING *;
 
 -- name: ListOrderItems :many
 SELECT * FROM order_items
-WHERE order_id = $1
+WHERE order_id = $1 AND tenant_id = $2
 ORDER BY updated_at;
 
 
 -- name: GetAddress :one
 SELECT * FROM addresses
+WHERE id = $1 AND tenant_id = $2 LIMIT 1;
+
+
+-- name: GetOrderForUpdate :one
+SELECT * FROM orders
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+FOR UPDATE;
+
+-- name: UpdateOrderStatus :one
+UPDATE orders
+SET status = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+
+-- name: CreateReturn :one
+INSERT INTO returns (
+ order_id, reason, refund_amount, tenant_id
+) VALUES (
+ $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: CreateReturnItem :one
+INSERT INTO return_items (
+ return_id, order_item_id, quantity,
+ refund_amount, tenant_id
+) VALUES (
+ $1, $2, $3, $4, $5
+)
+RETURNING *;
+
+-- name: GetReturn :one
+SELECT * FROM returns
+WHERE id = $1 AND tenant_id = $2 LIMIT 1;
+
+-- name: GetReturnForUpdate :one
+SELECT * FROM returns
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+FOR UPDATE;
+
+-- name: ListOrderReturns :many
+SELECT * FROM returns
+WHERE order_id = $1 AND tenant_id = $2
+ORDER BY created_at;
+
+-- name: ListReturnItems :many
+SELECT * FROM return_items
+WHERE return_id = $1 AND tenant_id = $2
+ORDER BY created_at;
+
+-- name: UpdateReturnStatus :one
+UPDATE returns
+SET status = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+-- name: RejectReturn :one
+UPDATE returns
+SET status = 'rejected', rejection_reason = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+-- name: InspectReturn :one
+UPDATE returns
+SET status = 'inspected', refund_amount = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+-- name: UpdateReturnItemInspection :one
+UPDATE return_items
+SET accepted_quantity = $2, refund_amount = $3, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $4
+RETURNING *;
+
+-- name: ListReturnedQuantities :many
+SELECT ri.order_item_id,
+       SUM(CASE WHEN r.status = 'inspected' THEN COALESCE(ri.accepted_quantity, 0) ELSE ri.quantity END)::int AS reserved_quantity,
+       SUM(COALESCE(ri.accepted_quantity, 0))::int AS accepted_quantity
+FROM return_items ri
+JOIN returns r ON r.id = ri.return_id
+WHERE r.order_id = $1 AND r.tenant_id = $2 AND r.status <> 'rejected'
+GROUP BY ri.order_item_id;
+
+-- name: DeleteReturnItems :many
+DELETE FROM return_items RETURNING *;
+
+-- name: DeleteReturns :many
+DELETE FROM returns RETURNING *;
+
+-- name: AppendOrderEvent :one
+INSERT INTO order_events (
+ order_id, version, event_type, payload, tenant_id
+) VALUES (
+ $1, (SELECT COALESCE(MAX(version), 0) + 1 FROM order_events WHERE order_id = $1), $2, $3, $4
+)
+RETURNING *;
+
+-- name: ListOrderEvents :many
+SELECT * FROM order_events
+WHERE order_id = $1 AND tenant_id = $2
+ORDER BY version;
+
+-- name: ListOrderEventsUntil :many
+SELECT * FROM order_events
+WHERE order_id = $1 AND tenant_id = $2 AND occurred_at <= $3
+ORDER BY version;
+
+-- name: ListOrderEventsWithAddresses :many
+SELECT * FROM order_events
+WHERE tenant_id = $1
+  AND (payload->'shipping_address'->>'line1' IS NOT NULL
+    OR payload->'billing_address'->>'line1' IS NOT NULL
+    OR payload->'address'->>'line1' IS NOT NULL)
+ORDER BY id
+LIMIT $2;
+
+-- name: ListEventSourcedOrderIDs :many
+SELECT DISTINCT order_id FROM order_events
+WHERE tenant_id = $1
+ORDER BY order_id;
+
+-- name: UpdateOrderAddresses :one
+UPDATE orders
+SET shipping_address_id = $2, billing_address_id = $3, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $4
+RETURNING *;
+
+-- name: UpsertAddress :exec
+INSERT INTO addresses (
+ id, line1, line2, city, state,
+ postal_code, country, created_at, tenant_id,
+ key_id, wrapped_key
+) VALUES (
+ $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+)
+ON CONFLICT (id) DO UPDATE SET
+ line1 = EXCLUDED.line1,
+ line2 = EXCLUDED.line2,
+ city = EXCLUDED.city,
+ state = EXCLUDED.state,
+ postal_code = EXCLUDED.postal_code,
+ country = EXCLUDED.country,
+ key_id = EXCLUDED.key_id,
+ wrapped_key = EXCLUDED.wrapped_key,
+ updated_at = NOW();
+
+-- name: UpsertOrder :exec
+INSERT INTO orders (
+  id, user_id, total_amount, status,
+  shipping_address_id, billing_address_id,
+  created_at, updated_at, tenant_id,
+  fraud_score, fraud_reasons
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+)
+ON CONFLICT (id) DO UPDATE SET
+  user_id = EXCLUDED.user_id,
+  total_amount = EXCLUDED.total_amount,
+  status = EXCLUDED.status,
+  fraud_score = EXCLUDED.fraud_score,
+  fraud_reasons = EXCLUDED.fraud_reasons,
+  shipping_address_id = EXCLUDED.shipping_address_id,
+  billing_address_id = EXCLUDED.billing_address_id,
+  updated_at = EXCLUDED.updated_at;
+
+-- name: UpsertOrderItem :exec
+INSERT INTO order_items (
+ id, order_id, product_id, quantity,
+ price, total_price, created_at, tenant_id
+) VALUES (
+ $1, $2, $3, $4, $5, $6, $7, $8
+)
+ON CONFLICT (id) DO UPDATE SET
+ product_id = EXCLUDED.product_id,
+ quantity = EXCLUDED.quantity,
+ price = EXCLUDED.price,
+ total_price = EXCLUDED.total_price,
+ updated_at = NOW();
+
+-- name: EnqueueOutboxMessage :one
+INSERT INTO outbox_messages (
+ message_type, payload, tenant_id, aggregate_id
+) VALUES (
+ $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: ListUnsentOutboxMessages :many
+SELECT * FROM outbox_messages
+WHERE sent_at IS NULL AND failed_at IS NULL
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxMessageSent :exec
+UPDATE outbox_messages
+SET sent_at = NOW(), attempts = attempts + 1
+WHERE id = $1;
+
+-- name: RecordOutboxMessageFailure :exec
+UPDATE outbox_messages
+SET attempts = attempts + 1, last_error = $2
+WHERE id = $1;
+
+-- name: ListAggregateOutboxMessages :many
+SELECT * FROM outbox_messages
+WHERE tenant_id = sqlc.arg(tenant_id) AND aggregate_id = ANY(sqlc.arg(aggregate_ids)::text[])
+ORDER BY id;
+
+-- name: TryOutboxLock :one
+SELECT pg_try_advisory_xact_lock(hashtext('outbox-relay')) AS locked;
+
+-- name: ParkOutboxMessage :exec
+UPDATE outbox_messages
+SET attempts = attempts + 1, last_error = $2, failed_at = NOW()
+WHERE id = $1;
+
+-- name: CreateClient :one
+INSERT INTO clients (
+ name, secret_hash
+) VALUES (
+ $1, $2
+)
+RETURNING *;
+
+-- name: GetClient :one
+SELECT * FROM clients
 WHERE id = $1 LIMIT 1;
+
+-- name: ListClients :many
+SELECT * FROM clients
+ORDER BY created_at;
+
+-- name: SetClientPendingOrderTTL :one
+UPDATE clients
+SET pending_order_ttl_seconds = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetTenant :exec
+SELECT set_config('app.tenant_id', $1::text, true);
+
+-- name: GetUserOrderCounts :one
+SELECT COUNT(*) AS total_orders,
+       COUNT(*) FILTER (WHERE created_at >= $3) AS recent_orders
+FROM orders
+WHERE user_id = $1 AND tenant_id = $2;
+
+-- name: CreateOrderReview :one
+INSERT INTO order_reviews (
+ order_id, tenant_id
+) VALUES (
+ $1, $2
+)
+RETURNING *;
+
+-- name: GetOrderReview :one
+SELECT * FROM order_reviews
+WHERE id = $1 AND tenant_id = $2 LIMIT 1;
+
+-- name: GetOrderReviewForUpdate :one
+SELECT * FROM order_reviews
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+FOR UPDATE;
+
+-- name: ListUndecidedOrderReviews :many
+SELECT * FROM order_reviews
+WHERE tenant_id = $1 AND status IN ('open', 'escalated')
+ORDER BY created_at;
+
+-- name: AssignOrderReview :one
+UPDATE order_reviews
+SET assignee = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+-- name: DecideOrderReview :one
+UPDATE order_reviews
+SET status = $2, decided_by = $3, decision_reason = $4, decided_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND tenant_id = $5
+RETURNING *;
+
+-- name: ListOverdueOrderReviews :many
+SELECT * FROM order_reviews
+WHERE tenant_id = $1 AND status = 'open' AND created_at <= $2
+ORDER BY created_at
+FOR UPDATE SKIP LOCKED;
+
+-- name: EscalateOrderReview :one
+UPDATE order_reviews
+SET status = 'escalated', escalated_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND tenant_id = $2
+RETURNING *;
+
+-- name: ListStalePendingOrders :many
+SELECT o.* FROM orders o
+WHERE o.tenant_id = sqlc.arg(tenant_id) AND o.status = 'pending'
+  AND COALESCE((
+    SELECT MAX(e.occurred_at) FROM order_events e
+    WHERE e.order_id = o.id AND e.event_type = 'status_changed' AND e.payload->>'to' = 'pending'
+  ), o.created_at) <= sqlc.arg(pending_before)
+ORDER BY o.created_at
+LIMIT sqlc.arg(batch_size)
+FOR UPDATE OF o SKIP LOCKED;
+
+-- name: TryJobLock :one
+SELECT pg_try_advisory_xact_lock(hashtext('job:' || $1::text)) AS locked;
+
+-- name: StartJobRun :one
+INSERT INTO jobs_runs (
+ job_name, instance
+) VALUES (
+ $1, $2
+)
+RETURNING *;
+
+-- name: FinishJobRun :one
+UPDATE jobs_runs
+SET status = $2, error = $3, duration_ms = $4, finished_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: ListJobRuns :many
+SELECT * FROM jobs_runs
+WHERE job_name = $1
+ORDER BY started_at DESC
+LIMIT $2;
+
+-- name: DeleteJobRunsBefore :execrows
+DELETE FROM jobs_runs
+WHERE finished_at < $1;
+
+-- name: EnableErasure :exec
+SELECT set_config('app.erasure', 'on', true);
+
+-- name: AnonymizeUserAddresses :execrows
+UPDATE addresses
+SET line1 = $3, line2 = NULL, city = $3, postal_code = $3, key_id = NULL, wrapped_key = NULL, updated_at = NOW()
+WHERE tenant_id = $2 AND id IN (
+    SELECT shipping_address_id FROM orders WHERE user_id = $1 AND tenant_id = $2
+    UNION
+    SELECT billing_address_id FROM orders WHERE user_id = $1 AND tenant_id = $2
+);
+
+-- name: RedactOrderEventPayload :exec
+UPDATE order_events
+SET payload = $2
+WHERE id = $1 AND tenant_id = $3;
+
+-- name: CreateErasure :one
+INSERT INTO erasures (
+ tenant_id, user_id, requested_by, reason, orders_count, addresses_count, events_count, exports_count
+) VALUES (
+ $1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING *;
+
+-- name: ListUserErasures :many
+SELECT * FROM erasures
+WHERE user_id = $1 AND tenant_id = $2
+ORDER BY created_at;
+
+-- name: CreateDataExport :one
+INSERT INTO data_exports (
+ tenant_id, user_id, requested_by
+) VALUES (
+ $1, $2, $3
+)
+RETURNING *;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND tenant_id = $2 LIMIT 1;
+
+-- name: ListPendingDataExports :many
+SELECT * FROM data_exports
+WHERE tenant_id = $1 AND status = 'pending'
+ORDER BY created_at
+LIMIT $2
+FOR UPDATE SKIP LOCKED;
+
+-- name: CompleteDataExport :one
+UPDATE data_exports
+SET status = 'completed', archive = $2, completed_at = NOW(), expires_at = $3
+WHERE id = $1 AND tenant_id = $4
+RETURNING *;
+
+-- name: FailDataExport :one
+UPDATE data_exports
+SET status = 'failed', error = $2, completed_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+-- name: DeleteExpiredDataExports :execrows
+DELETE FROM data_exports
+WHERE tenant_id = $1 AND expires_at < $2;
+
+-- name: DeleteUserDataExports :execrows
+DELETE FROM data_exports
+WHERE user_id = $1 AND tenant_id = $2;
+
+-- name: ListAddressesToReencrypt :many
+SELECT * FROM addresses
+WHERE tenant_id = $1 AND key_id IS DISTINCT FROM $2::text
+ORDER BY id
+LIMIT $3
+FOR UPDATE SKIP LOCKED;
+
+-- name: UpdateAddressEncryption :exec
+UPDATE addresses
+SET line1 = $2, line2 = $3, city = $4, postal_code = $5, key_id = $6, wrapped_key = $7
+WHERE id = $1 AND tenant_id = $8;
+
+-- name: DeclareMqExchange :one
+INSERT INTO mq_exchanges (
+ name, kind
+) VALUES (
+ $1, $2
+)
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING *;
+
+-- name: GetMqExchange :one
+SELECT * FROM mq_exchanges
+WHERE name = $1 LIMIT 1;
+
+-- name: DeclareMqQueue :one
+INSERT INTO mq_queues (
+ name, arguments
+) VALUES (
+ $1, $2
+)
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING *;
+
+-- name: GetMqQueue :one
+SELECT * FROM mq_queues
+WHERE name = $1 LIMIT 1;
+
+-- name: BindMqQueue :exec
+INSERT INTO mq_bindings (
+ queue, exchange, key
+) VALUES (
+ $1, $2, $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: ListMqBindings :many
+SELECT * FROM mq_bindings
+WHERE exchange = $1
+ORDER BY queue, key;
+
+-- name: EnqueueMqMessage :exec
+INSERT INTO mq_messages (
+ queue, exchange, routing_key, content_type, message_id, correlation_id, published_at, headers, body, expires_at
+) VALUES (
+ sqlc.arg(queue), sqlc.arg(exchange), sqlc.arg(routing_key), sqlc.arg(content_type), sqlc.arg(message_id),
+ sqlc.arg(correlation_id), sqlc.arg(published_at), sqlc.arg(headers), sqlc.arg(body),
+ NOW() + sqlc.narg(ttl_ms)::bigint * INTERVAL '1 millisecond'
+);
+
+-- name: CountMqMessages :one
+SELECT COUNT(*) FROM mq_messages
+WHERE queue = $1;
+
+-- name: ClaimMqMessages :many
+UPDATE mq_messages
+SET locked_by = sqlc.arg(locked_by),
+    locked_until = NOW() + sqlc.arg(lease_ms)::bigint * INTERVAL '1 millisecond',
+    redelivered = redelivered OR locked_by IS NOT NULL
+WHERE id IN (
+ SELECT id FROM mq_messages
+ WHERE queue = sqlc.arg(queue)
+   AND (locked_until IS NULL OR locked_until < NOW())
+   AND (expires_at IS NULL OR expires_at > NOW())
+ ORDER BY id
+ LIMIT sqlc.arg(max)
+ FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: ExtendMqLeases :exec
+UPDATE mq_messages
+SET locked_until = NOW() + sqlc.arg(lease_ms)::bigint * INTERVAL '1 millisecond'
+WHERE locked_by = sqlc.arg(locked_by);
+
+-- name: AckMqMessages :exec
+DELETE FROM mq_messages
+WHERE locked_by = sqlc.arg(locked_by) AND id = ANY(sqlc.arg(ids)::bigint[]);
+
+-- name: RejectMqMessages :many
+DELETE FROM mq_messages
+WHERE locked_by = sqlc.arg(locked_by) AND id = ANY(sqlc.arg(ids)::bigint[])
+RETURNING *;
+
+-- name: ReleaseMqMessages :exec
+UPDATE mq_messages
+SET locked_by = NULL, locked_until = NULL, redelivered = TRUE
+WHERE locked_by = sqlc.arg(locked_by) AND id = ANY(sqlc.arg(ids)::bigint[]);
+
+-- name: ReleaseMqChannel :exec
+UPDATE mq_messages
+SET locked_by = NULL, locked_until = NULL, redelivered = TRUE
+WHERE locked_by = $1;
+
+-- name: ExpireMqMessages :many
+DELETE FROM mq_messages
+WHERE id IN (
+ SELECT id FROM mq_messages
+ WHERE expires_at <= NOW()
+   AND (locked_until IS NULL OR locked_until < NOW())
+ ORDER BY id
+ FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: PurgeMqQueue :execrows
+DELETE FROM mq_messages
+WHERE queue = $1 AND (locked_until IS NULL OR locked_until < NOW());
+
+-- name: ReceiveInboxMessage :execrows
+INSERT INTO inbox_messages (
+ message_id, handler, tenant_id
+) VALUES (
+ $1, $2, $3
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteInboxMessagesBefore :execrows
+DELETE FROM inbox_messages
+WHERE received_at < $1;
@@ -11,14 +11,255 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const ackMqMessages = `-- name: AckMqMessages :exec
+DELETE FROM mq_messages
+WHERE locked_by = $1 AND id = ANY($2::bigint[])
+`
+
+type AckMqMessagesParams struct {
+	LockedBy pgtype.Text
+	Ids      []int64
+}
+
+func (q *Queries) AckMqMessages(ctx context.Context, arg *AckMqMessagesParams) error {
+	_, err := q.db.Exec(ctx, ackMqMessages, arg.LockedBy, arg.Ids)
+	return err
+}
+
+const anonymizeUserAddresses = `-- name: AnonymizeUserAddresses :execrows
+UPDATE addresses
+SET line1 = $3, line2 = NULL, city = $3, postal_code = $3, key_id = NULL, wrapped_key = NULL, updated_at = NOW()
+WHERE tenant_id = $2 AND id IN (
+    SELECT shipping_address_id FROM orders WHERE user_id = $1 AND tenant_id = $2
+    UNION
+    SELECT billing_address_id FROM orders WHERE user_id = $1 AND tenant_id = $2
+)
+`
+
+type AnonymizeUserAddressesParams struct {
+	UserID   pgtype.UUID
+	TenantID pgtype.UUID
+	Line1    string
+}
+
+func (q *Queries) AnonymizeUserAddresses(ctx context.Context, arg *AnonymizeUserAddressesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, anonymizeUserAddresses, arg.UserID, arg.TenantID, arg.Line1)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const appendOrderEvent = `-- name: AppendOrderEvent :one
+INSERT INTO order_events (
+ order_id, version, event_type, payload, tenant_id
+) VALUES (
+ $1, (SELECT COALESCE(MAX(version), 0) + 1 FROM order_events WHERE order_id = $1), $2, $3, $4
+)
+RETURNING id, order_id, version, event_type, payload, occurred_at, tenant_id
+`
+
+type AppendOrderEventParams struct {
+	OrderID   pgtype.UUID
+	EventType OrderEventType
+	Payload   []byte
+	TenantID  pgtype.UUID
+}
+
+func (q *Queries) AppendOrderEvent(ctx context.Context, arg *AppendOrderEventParams) (*OrderEvent, error) {
+	row := q.db.QueryRow(ctx, appendOrderEvent,
+		arg.OrderID,
+		arg.EventType,
+		arg.Payload,
+		arg.TenantID,
+	)
+	var i OrderEvent
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Version,
+		&i.EventType,
+		&i.Payload,
+		&i.OccurredAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const assignOrderReview = `-- name: AssignOrderReview :one
+UPDATE order_reviews
+SET assignee = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at
+`
+
+type AssignOrderReviewParams struct {
+	ID       pgtype.UUID
+	Assignee pgtype.Text
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) AssignOrderReview(ctx context.Context, arg *AssignOrderReviewParams) (*OrderReview, error) {
+	row := q.db.QueryRow(ctx, assignOrderReview, arg.ID, arg.Assignee, arg.TenantID)
+	var i OrderReview
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.TenantID,
+		&i.Status,
+		&i.Assignee,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.EscalatedAt,
+		&i.DecidedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return &i, err
+}
+
+const bindMqQueue = `-- name: BindMqQueue :exec
+INSERT INTO mq_bindings (
+ queue, exchange, key
+) VALUES (
+ $1, $2, $3
+)
+ON CONFLICT DO NOTHING
+`
+
+type BindMqQueueParams struct {
+	Queue    string
+	Exchange string
+	Key      string
+}
+
+func (q *Queries) BindMqQueue(ctx context.Context, arg *BindMqQueueParams) error {
+	_, err := q.db.Exec(ctx, bindMqQueue, arg.Queue, arg.Exchange, arg.Key)
+	return err
+}
+
+const claimMqMessages = `-- name: ClaimMqMessages :many
+UPDATE mq_messages
+SET locked_by = $1,
+    locked_until = NOW() + $2::bigint * INTERVAL '1 millisecond',
+    redelivered = redelivered OR locked_by IS NOT NULL
+WHERE id IN (
+ SELECT id FROM mq_messages
+ WHERE queue = $3
+   AND (locked_until IS NULL OR locked_until < NOW())
+   AND (expires_at IS NULL OR expires_at > NOW())
+ ORDER BY id
+ LIMIT $4
+ FOR UPDATE SKIP LOCKED
+)
+RETURNING id, queue, exchange, routing_key, content_type, message_id, correlation_id, published_at, headers, body, redelivered, expires_at, locked_by, locked_until, created_at
+`
+
+type ClaimMqMessagesParams struct {
+	LockedBy pgtype.Text
+	LeaseMs  int64
+	Queue    string
+	Max      int32
+}
+
+func (q *Queries) ClaimMqMessages(ctx context.Context, arg *ClaimMqMessagesParams) ([]*MqMessage, error) {
+	rows, err := q.db.Query(ctx, claimMqMessages,
+		arg.LockedBy,
+		arg.LeaseMs,
+		arg.Queue,
+		arg.Max,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*MqMessage{}
+	for rows.Next() {
+		var i MqMessage
+		if err := rows.Scan(
+			&i.ID,
+			&i.Queue,
+			&i.Exchange,
+			&i.RoutingKey,
+			&i.ContentType,
+			&i.MessageID,
+			&i.CorrelationID,
+			&i.PublishedAt,
+			&i.Headers,
+			&i.Body,
+			&i.Redelivered,
+			&i.ExpiresAt,
+			&i.LockedBy,
+			&i.LockedUntil,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const completeDataExport = `-- name: CompleteDataExport :one
+UPDATE data_exports
+SET status = 'completed', archive = $2, completed_at = NOW(), expires_at = $3
+WHERE id = $1 AND tenant_id = $4
+RETURNING id, tenant_id, user_id, requested_by, status, archive, error, created_at, completed_at, expires_at
+`
+
+type CompleteDataExportParams struct {
+	ID        pgtype.UUID
+	Archive   []byte
+	ExpiresAt pgtype.Timestamptz
+	TenantID  pgtype.UUID
+}
+
+func (q *Queries) CompleteDataExport(ctx context.Context, arg *CompleteDataExportParams) (*DataExport, error) {
+	row := q.db.QueryRow(ctx, completeDataExport,
+		arg.ID,
+		arg.Archive,
+		arg.ExpiresAt,
+		arg.TenantID,
+	)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.UserID,
+		&i.RequestedBy,
+		&i.Status,
+		&i.Archive,
+		&i.Error,
+		&i.CreatedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return &i, err
+}
+
+const countMqMessages = `-- name: CountMqMessages :one
+SELECT COUNT(*) FROM mq_messages
+WHERE queue = $1
+`
+
+func (q *Queries) CountMqMessages(ctx context.Context, queue string) (int64, error) {
+	row := q.db.QueryRow(ctx, countMqMessages, queue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createAddress = `-- name: CreateAddress :one
 INSERT INTO addresses (
  line1, city, state, postal_code,
- country, line2
+ country, line2, tenant_id, key_id, wrapped_key
 ) VALUES (
- $1, $2, $3, $4, $5, $6
+ $1, $2, $3, $4, $5, $6, $7, $8, $9
 )
-RETURNING id, line1, line2, city, state, postal_code, country, created_at, updated_at
+RETURNING id, line1, line2, city, state, postal_code, country, created_at, updated_at, tenant_id, key_id, wrapped_key
 `
 
 type CreateAddressParams struct {
@@ -28,6 +269,9 @@ type CreateAddressParams struct {
 	PostalCode string
 	Country    string
 	Line2      pgtype.Text
+	TenantID   pgtype.UUID
+	KeyID      pgtype.Text
+	WrappedKey []byte
 }
 
 func (q *Queries) CreateAddress(ctx context.Context, arg *CreateAddressParams) (*Address, error) {
@@ -38,6 +282,9 @@ func (q *Queries) CreateAddress(ctx context.Context, arg *CreateAddressParams) (
 		arg.PostalCode,
 		arg.Country,
 		arg.Line2,
+		arg.TenantID,
+		arg.KeyID,
+		arg.WrappedKey,
 	)
 	var i Address
 	err := row.Scan(
@@ -50,6 +297,117 @@ func (q *Queries) CreateAddress(ctx context.Context, arg *CreateAddressParams) (
 		&i.Country,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.TenantID,
+		&i.KeyID,
+		&i.WrappedKey,
+	)
+	return &i, err
+}
+
+const createClient = `-- name: CreateClient :one
+INSERT INTO clients (
+ name, secret_hash
+) VALUES (
+ $1, $2
+)
+RETURNING id, name, secret_hash, created_at, updated_at, pending_order_ttl_seconds
+`
+
+type CreateClientParams struct {
+	Name       string
+	SecretHash string
+}
+
+func (q *Queries) CreateClient(ctx context.Context, arg *CreateClientParams) (*Client, error) {
+	row := q.db.QueryRow(ctx, createClient, arg.Name, arg.SecretHash)
+	var i Client
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PendingOrderTtlSeconds,
+	)
+	return &i, err
+}
+
+const createDataExport = `-- name: CreateDataExport :one
+INSERT INTO data_exports (
+ tenant_id, user_id, requested_by
+) VALUES (
+ $1, $2, $3
+)
+RETURNING id, tenant_id, user_id, requested_by, status, archive, error, created_at, completed_at, expires_at
+`
+
+type CreateDataExportParams struct {
+	TenantID    pgtype.UUID
+	UserID      pgtype.UUID
+	RequestedBy string
+}
+
+func (q *Queries) CreateDataExport(ctx context.Context, arg *CreateDataExportParams) (*DataExport, error) {
+	row := q.db.QueryRow(ctx, createDataExport, arg.TenantID, arg.UserID, arg.RequestedBy)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.UserID,
+		&i.RequestedBy,
+		&i.Status,
+		&i.Archive,
+		&i.Error,
+		&i.CreatedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return &i, err
+}
+
+const createErasure = `-- name: CreateErasure :one
+INSERT INTO erasures (
+ tenant_id, user_id, requested_by, reason, orders_count, addresses_count, events_count, exports_count
+) VALUES (
+ $1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING id, tenant_id, user_id, requested_by, reason, orders_count, addresses_count, events_count, created_at, exports_count
+`
+
+type CreateErasureParams struct {
+	TenantID       pgtype.UUID
+	UserID         pgtype.UUID
+	RequestedBy    string
+	Reason         pgtype.Text
+	OrdersCount    int32
+	AddressesCount int32
+	EventsCount    int32
+	ExportsCount   int32
+}
+
+func (q *Queries) CreateErasure(ctx context.Context, arg *CreateErasureParams) (*Erasure, error) {
+	row := q.db.QueryRow(ctx, createErasure,
+		arg.TenantID,
+		arg.UserID,
+		arg.RequestedBy,
+		arg.Reason,
+		arg.OrdersCount,
+		arg.AddressesCount,
+		arg.EventsCount,
+		arg.ExportsCount,
+	)
+	var i Erasure
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.UserID,
+		&i.RequestedBy,
+		&i.Reason,
+		&i.OrdersCount,
+		&i.AddressesCount,
+		&i.EventsCount,
+		&i.CreatedAt,
+		&i.ExportsCount,
 	)
 	return &i, err
 }
@@ -57,11 +415,12 @@ func (q *Queries) CreateAddress(ctx context.Context, arg *CreateAddressParams) (
 const createOrder = `-- name: CreateOrder :one
 INSERT INTO orders (
   user_id, total_amount, status,
-  shipping_address_id, billing_address_id
+  shipping_address_id, billing_address_id, tenant_id,
+  fraud_score, fraud_reasons
 ) VALUES (
-  $1, $2, $3, $4, $5
+  $1, $2, $3, $4, $5, $6, $7, $8
 )
-RETURNING id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at
+RETURNING id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons
 `
 
 type CreateOrderParams struct {
@@ -70,6 +429,9 @@ type CreateOrderParams struct {
 	Status            OrderStatus
 	ShippingAddressID pgtype.UUID
 	BillingAddressID  pgtype.UUID
+	TenantID          pgtype.UUID
+	FraudScore        int32
+	FraudReasons      []string
 }
 
 func (q *Queries) CreateOrder(ctx context.Context, arg *CreateOrderParams) (*Order, error) {
@@ -79,6 +441,9 @@ func (q *Queries) CreateOrder(ctx context.Context, arg *CreateOrderParams) (*Ord
 		arg.Status,
 		arg.ShippingAddressID,
 		arg.BillingAddressID,
+		arg.TenantID,
+		arg.FraudScore,
+		arg.FraudReasons,
 	)
 	var i Order
 	err := row.Scan(
@@ -90,6 +455,9 @@ func (q *Queries) CreateOrder(ctx context.Context, arg *CreateOrderParams) (*Ord
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.TenantID,
+		&i.FraudScore,
+		&i.FraudReasons,
 	)
 	return &i, err
 }
@@ -97,11 +465,11 @@ func (q *Queries) CreateOrder(ctx context.Context, arg *CreateOrderParams) (*Ord
 const createOrderItem = `-- name: CreateOrderItem :one
 INSERT INTO order_items (
  order_id, product_id, quantity,
- price, total_price
+ price, total_price, tenant_id
 ) VALUES (
- $1, $2, $3, $4, $5
+ $1, $2, $3, $4, $5, $6
 )
-RETURNING id, order_id, product_id, quantity, price, total_price, created_at, updated_at
+RETURNING id, order_id, product_id, quantity, price, total_price, created_at, updated_at, tenant_id
 `
 
 type CreateOrderItemParams struct {
@@ -110,6 +478,7 @@ type CreateOrderItemParams struct {
 	Quantity   int32
 	Price      pgtype.Numeric
 	TotalPrice pgtype.Numeric
+	TenantID   pgtype.UUID
 }
 
 func (q *Queries) CreateOrderItem(ctx context.Context, arg *CreateOrderItemParams) (*OrderItem, error) {
@@ -119,6 +488,7 @@ func (q *Queries) CreateOrderItem(ctx context.Context, arg *CreateOrderItemParam
 		arg.Quantity,
 		arg.Price,
 		arg.TotalPrice,
+		arg.TenantID,
 	)
 	var i OrderItem
 	err := row.Scan(
@@ -130,12 +500,253 @@ func (q *Queries) CreateOrderItem(ctx context.Context, arg *CreateOrderItemParam
 		&i.TotalPrice,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const createOrderReview = `-- name: CreateOrderReview :one
+INSERT INTO order_reviews (
+ order_id, tenant_id
+) VALUES (
+ $1, $2
+)
+RETURNING id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at
+`
+
+type CreateOrderReviewParams struct {
+	OrderID  pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) CreateOrderReview(ctx context.Context, arg *CreateOrderReviewParams) (*OrderReview, error) {
+	row := q.db.QueryRow(ctx, createOrderReview, arg.OrderID, arg.TenantID)
+	var i OrderReview
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.TenantID,
+		&i.Status,
+		&i.Assignee,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.EscalatedAt,
+		&i.DecidedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return &i, err
+}
+
+const createReturn = `-- name: CreateReturn :one
+INSERT INTO returns (
+ order_id, reason, refund_amount, tenant_id
+) VALUES (
+ $1, $2, $3, $4
+)
+RETURNING id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id
+`
+
+type CreateReturnParams struct {
+	OrderID      pgtype.UUID
+	Reason       string
+	RefundAmount pgtype.Numeric
+	TenantID     pgtype.UUID
+}
+
+func (q *Queries) CreateReturn(ctx context.Context, arg *CreateReturnParams) (*Return, error) {
+	row := q.db.QueryRow(ctx, createReturn,
+		arg.OrderID,
+		arg.Reason,
+		arg.RefundAmount,
+		arg.TenantID,
+	)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RejectionReason,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const createReturnItem = `-- name: CreateReturnItem :one
+INSERT INTO return_items (
+ return_id, order_item_id, quantity,
+ refund_amount, tenant_id
+) VALUES (
+ $1, $2, $3, $4, $5
+)
+RETURNING id, return_id, order_item_id, quantity, accepted_quantity, refund_amount, created_at, updated_at, tenant_id
+`
+
+type CreateReturnItemParams struct {
+	ReturnID     pgtype.UUID
+	OrderItemID  pgtype.UUID
+	Quantity     int32
+	RefundAmount pgtype.Numeric
+	TenantID     pgtype.UUID
+}
+
+func (q *Queries) CreateReturnItem(ctx context.Context, arg *CreateReturnItemParams) (*ReturnItem, error) {
+	row := q.db.QueryRow(ctx, createReturnItem,
+		arg.ReturnID,
+		arg.OrderItemID,
+		arg.Quantity,
+		arg.RefundAmount,
+		arg.TenantID,
+	)
+	var i ReturnItem
+	err := row.Scan(
+		&i.ID,
+		&i.ReturnID,
+		&i.OrderItemID,
+		&i.Quantity,
+		&i.AcceptedQuantity,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const decideOrderReview = `-- name: DecideOrderReview :one
+UPDATE order_reviews
+SET status = $2, decided_by = $3, decision_reason = $4, decided_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND tenant_id = $5
+RETURNING id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at
+`
+
+type DecideOrderReviewParams struct {
+	ID             pgtype.UUID
+	Status         ReviewStatus
+	DecidedBy      pgtype.Text
+	DecisionReason pgtype.Text
+	TenantID       pgtype.UUID
+}
+
+func (q *Queries) DecideOrderReview(ctx context.Context, arg *DecideOrderReviewParams) (*OrderReview, error) {
+	row := q.db.QueryRow(ctx, decideOrderReview,
+		arg.ID,
+		arg.Status,
+		arg.DecidedBy,
+		arg.DecisionReason,
+		arg.TenantID,
+	)
+	var i OrderReview
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.TenantID,
+		&i.Status,
+		&i.Assignee,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.EscalatedAt,
+		&i.DecidedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return &i, err
 }
 
+const declareMqExchange = `-- name: DeclareMqExchange :one
+INSERT INTO mq_exchanges (
+ name, kind
+) VALUES (
+ $1, $2
+)
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING name, kind
+`
+
+type DeclareMqExchangeParams struct {
+	Name string
+	Kind string
+}
+
+func (q *Queries) DeclareMqExchange(ctx context.Context, arg *DeclareMqExchangeParams) (*MqExchange, error) {
+	row := q.db.QueryRow(ctx, declareMqExchange, arg.Name, arg.Kind)
+	var i MqExchange
+	err := row.Scan(&i.Name, &i.Kind)
+	return &i, err
+}
+
+const declareMqQueue = `-- name: DeclareMqQueue :one
+INSERT INTO mq_queues (
+ name, arguments
+) VALUES (
+ $1, $2
+)
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING name, arguments
+`
+
+type DeclareMqQueueParams struct {
+	Name      string
+	Arguments []byte
+}
+
+func (q *Queries) DeclareMqQueue(ctx context.Context, arg *DeclareMqQueueParams) (*MqQueue, error) {
+	row := q.db.QueryRow(ctx, declareMqQueue, arg.Name, arg.Arguments)
+	var i MqQueue
+	err := row.Scan(&i.Name, &i.Arguments)
+	return &i, err
+}
+
+const deleteExpiredDataExports = `-- name: DeleteExpiredDataExports :execrows
+DELETE FROM data_exports
+WHERE tenant_id = $1 AND expires_at < $2
+`
+
+type DeleteExpiredDataExportsParams struct {
+	TenantID  pgtype.UUID
+	ExpiresAt pgtype.Timestamptz
+}
+
+func (q *Queries) DeleteExpiredDataExports(ctx context.Context, arg *DeleteExpiredDataExportsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredDataExports, arg.TenantID, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteInboxMessagesBefore = `-- name: DeleteInboxMessagesBefore :execrows
+DELETE FROM inbox_messages
+WHERE received_at < $1
+`
+
+func (q *Queries) DeleteInboxMessagesBefore(ctx context.Context, receivedAt pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteInboxMessagesBefore, receivedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteJobRunsBefore = `-- name: DeleteJobRunsBefore :execrows
+DELETE FROM jobs_runs
+WHERE finished_at < $1
+`
+
+func (q *Queries) DeleteJobRunsBefore(ctx context.Context, finishedAt pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteJobRunsBefore, finishedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteOrderItems = `-- name: DeleteOrderItems :many
-DELETE FROM order_items RETURNING id, order_id, product_id, quantity, price, total_price, created_at, updated_at
+DELETE FROM order_items RETURNING id, order_id, product_id, quantity, price, total_price, created_at, updated_at, tenant_id
 `
 
 func (q *Queries) DeleteOrderItems(ctx context.Context) ([]*OrderItem, error) {
@@ -156,6 +767,7 @@ func (q *Queries) DeleteOrderItems(ctx context.Context) ([]*OrderItem, error) {
 			&i.TotalPrice,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.TenantID,
 		); err != nil {
 			return nil, err
 		}
@@ -168,7 +780,7 @@ func (q *Queries) DeleteOrderItems(ctx context.Context) ([]*OrderItem, error) {
 }
 
 const deleteOrders = `-- name: DeleteOrders :many
-DELETE FROM orders RETURNING id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at
+DELETE FROM orders RETURNING id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons
 `
 
 func (q *Queries) DeleteOrders(ctx context.Context) ([]*Order, error) {
@@ -189,6 +801,9 @@ func (q *Queries) DeleteOrders(ctx context.Context) ([]*Order, error) {
 			&i.Status,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.TenantID,
+			&i.FraudScore,
+			&i.FraudReasons,
 		); err != nil {
 			return nil, err
 		}
@@ -200,73 +815,63 @@ func (q *Queries) DeleteOrders(ctx context.Context) ([]*Order, error) {
 	return items, nil
 }
 
-const getAddress = `-- name: GetAddress :one
-SELECT id, line1, line2, city, state, postal_code, country, created_at, updated_at FROM addresses
-WHERE id = $1 LIMIT 1
+const deleteReturnItems = `-- name: DeleteReturnItems :many
+DELETE FROM return_items RETURNING id, return_id, order_item_id, quantity, accepted_quantity, refund_amount, created_at, updated_at, tenant_id
 `
 
-func (q *Queries) GetAddress(ctx context.Context, id pgtype.UUID) (*Address, error) {
-	row := q.db.QueryRow(ctx, getAddress, id)
-	var i Address
-	err := row.Scan(
-		&i.ID,
-		&i.Line1,
-		&i.Line2,
-		&i.City,
-		&i.State,
-		&i.PostalCode,
-		&i.Country,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return &i, err
-}
-
-const getOrder = `-- name: GetOrder :one
-SELECT id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at FROM orders
-WHERE id = $1 LIMIT 1
-`
-
-func (q *Queries) GetOrder(ctx context.Context, id pgtype.UUID) (*Order, error) {
-	row := q.db.QueryRow(ctx, getOrder, id)
-	var i Order
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.ShippingAddressID,
-		&i.BillingAddressID,
-		&i.TotalAmount,
-		&i.Status,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return &i, err
+func (q *Queries) DeleteReturnItems(ctx context.Context) ([]*ReturnItem, error) {
+	rows, err := q.db.Query(ctx, deleteReturnItems)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*ReturnItem{}
+	for rows.Next() {
+		var i ReturnItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.ReturnID,
+			&i.OrderItemID,
+			&i.Quantity,
+			&i.AcceptedQuantity,
+			&i.RefundAmount,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const listOrderItems = `-- name: ListOrderItems :many
-SELECT id, order_id, product_id, quantity, price, total_price, created_at, updated_at FROM order_items
-WHERE order_id = $1
-ORDER BY updated_at
+const deleteReturns = `-- name: DeleteReturns :many
+DELETE FROM returns RETURNING id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id
 `
 
-func (q *Queries) ListOrderItems(ctx context.Context, orderID pgtype.UUID) ([]*OrderItem, error) {
-	rows, err := q.db.Query(ctx, listOrderItems, orderID)
+func (q *Queries) DeleteReturns(ctx context.Context) ([]*Return, error) {
+	rows, err := q.db.Query(ctx, deleteReturns)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []*OrderItem{}
+	items := []*Return{}
 	for rows.Next() {
-		var i OrderItem
+		var i Return
 		if err := rows.Scan(
 			&i.ID,
 			&i.OrderID,
-			&i.ProductID,
-			&i.Quantity,
-			&i.Price,
-			&i.TotalPrice,
+			&i.Status,
+			&i.Reason,
+			&i.RejectionReason,
+			&i.RefundAmount,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.TenantID,
 		); err != nil {
 			return nil, err
 		}
@@ -278,30 +883,179 @@ func (q *Queries) ListOrderItems(ctx context.Context, orderID pgtype.UUID) ([]*O
 	return items, nil
 }
 
-const listOrders = `-- name: ListOrders :many
-SELECT id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at FROM orders
-WHERE user_id = $1
-ORDER BY updated_at
+const deleteUserDataExports = `-- name: DeleteUserDataExports :execrows
+DELETE FROM data_exports
+WHERE user_id = $1 AND tenant_id = $2
+`
+
+type DeleteUserDataExportsParams struct {
+	UserID   pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) DeleteUserDataExports(ctx context.Context, arg *DeleteUserDataExportsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserDataExports, arg.UserID, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const enableErasure = `-- name: EnableErasure :exec
+SELECT set_config('app.erasure', 'on', true)
 `
 
-func (q *Queries) ListOrders(ctx context.Context, userID pgtype.UUID) ([]*Order, error) {
-	rows, err := q.db.Query(ctx, listOrders, userID)
+func (q *Queries) EnableErasure(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, enableErasure)
+	return err
+}
+
+const enqueueMqMessage = `-- name: EnqueueMqMessage :exec
+INSERT INTO mq_messages (
+ queue, exchange, routing_key, content_type, message_id, correlation_id, published_at, headers, body, expires_at
+) VALUES (
+ $1, $2, $3, $4, $5,
+ $6, $7, $8, $9,
+ NOW() + $10::bigint * INTERVAL '1 millisecond'
+)
+`
+
+type EnqueueMqMessageParams struct {
+	Queue         string
+	Exchange      string
+	RoutingKey    string
+	ContentType   string
+	MessageID     string
+	CorrelationID string
+	PublishedAt   pgtype.Timestamptz
+	Headers       []byte
+	Body          []byte
+	TtlMs         pgtype.Int8
+}
+
+func (q *Queries) EnqueueMqMessage(ctx context.Context, arg *EnqueueMqMessageParams) error {
+	_, err := q.db.Exec(ctx, enqueueMqMessage,
+		arg.Queue,
+		arg.Exchange,
+		arg.RoutingKey,
+		arg.ContentType,
+		arg.MessageID,
+		arg.CorrelationID,
+		arg.PublishedAt,
+		arg.Headers,
+		arg.Body,
+		arg.TtlMs,
+	)
+	return err
+}
+
+const enqueueOutboxMessage = `-- name: EnqueueOutboxMessage :one
+INSERT INTO outbox_messages (
+ message_type, payload, tenant_id, aggregate_id
+) VALUES (
+ $1, $2, $3, $4
+)
+RETURNING id, message_type, payload, attempts, last_error, created_at, sent_at, tenant_id, aggregate_id, failed_at
+`
+
+type EnqueueOutboxMessageParams struct {
+	MessageType string
+	Payload     []byte
+	TenantID    pgtype.UUID
+	AggregateID string
+}
+
+func (q *Queries) EnqueueOutboxMessage(ctx context.Context, arg *EnqueueOutboxMessageParams) (*OutboxMessage, error) {
+	row := q.db.QueryRow(ctx, enqueueOutboxMessage,
+		arg.MessageType,
+		arg.Payload,
+		arg.TenantID,
+		arg.AggregateID,
+	)
+	var i OutboxMessage
+	err := row.Scan(
+		&i.ID,
+		&i.MessageType,
+		&i.Payload,
+		&i.Attempts,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.SentAt,
+		&i.TenantID,
+		&i.AggregateID,
+		&i.FailedAt,
+	)
+	return &i, err
+}
+
+const escalateOrderReview = `-- name: EscalateOrderReview :one
+UPDATE order_reviews
+SET status = 'escalated', escalated_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND tenant_id = $2
+RETURNING id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at
+`
+
+type EscalateOrderReviewParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) EscalateOrderReview(ctx context.Context, arg *EscalateOrderReviewParams) (*OrderReview, error) {
+	row := q.db.QueryRow(ctx, escalateOrderReview, arg.ID, arg.TenantID)
+	var i OrderReview
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.TenantID,
+		&i.Status,
+		&i.Assignee,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.EscalatedAt,
+		&i.DecidedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return &i, err
+}
+
+const expireMqMessages = `-- name: ExpireMqMessages :many
+DELETE FROM mq_messages
+WHERE id IN (
+ SELECT id FROM mq_messages
+ WHERE expires_at <= NOW()
+   AND (locked_until IS NULL OR locked_until < NOW())
+ ORDER BY id
+ FOR UPDATE SKIP LOCKED
+)
+RETURNING id, queue, exchange, routing_key, content_type, message_id, correlation_id, published_at, headers, body, redelivered, expires_at, locked_by, locked_until, created_at
+`
+
+func (q *Queries) ExpireMqMessages(ctx context.Context) ([]*MqMessage, error) {
+	rows, err := q.db.Query(ctx, expireMqMessages)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []*Order{}
+	items := []*MqMessage{}
 	for rows.Next() {
-		var i Order
+		var i MqMessage
 		if err := rows.Scan(
 			&i.ID,
-			&i.UserID,
-			&i.ShippingAddressID,
-			&i.BillingAddressID,
-			&i.TotalAmount,
-			&i.Status,
+			&i.Queue,
+			&i.Exchange,
+			&i.RoutingKey,
+			&i.ContentType,
+			&i.MessageID,
+			&i.CorrelationID,
+			&i.PublishedAt,
+			&i.Headers,
+			&i.Body,
+			&i.Redelivered,
+			&i.ExpiresAt,
+			&i.LockedBy,
+			&i.LockedUntil,
 			&i.CreatedAt,
-			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -312,3 +1066,1814 @@ func (q *Queries) ListOrders(ctx context.Context, userID pgtype.UUID) ([]*Order,
 	}
 	return items, nil
 }
+
+const extendMqLeases = `-- name: ExtendMqLeases :exec
+UPDATE mq_messages
+SET locked_until = NOW() + $1::bigint * INTERVAL '1 millisecond'
+WHERE locked_by = $2
+`
+
+type ExtendMqLeasesParams struct {
+	LeaseMs  int64
+	LockedBy pgtype.Text
+}
+
+func (q *Queries) ExtendMqLeases(ctx context.Context, arg *ExtendMqLeasesParams) error {
+	_, err := q.db.Exec(ctx, extendMqLeases, arg.LeaseMs, arg.LockedBy)
+	return err
+}
+
+const failDataExport = `-- name: FailDataExport :one
+UPDATE data_exports
+SET status = 'failed', error = $2, completed_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, tenant_id, user_id, requested_by, status, archive, error, created_at, completed_at, expires_at
+`
+
+type FailDataExportParams struct {
+	ID       pgtype.UUID
+	Error    pgtype.Text
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) FailDataExport(ctx context.Context, arg *FailDataExportParams) (*DataExport, error) {
+	row := q.db.QueryRow(ctx, failDataExport, arg.ID, arg.Error, arg.TenantID)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.UserID,
+		&i.RequestedBy,
+		&i.Status,
+		&i.Archive,
+		&i.Error,
+		&i.CreatedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return &i, err
+}
+
+const finishJobRun = `-- name: FinishJobRun :one
+UPDATE jobs_runs
+SET status = $2, error = $3, duration_ms = $4, finished_at = NOW()
+WHERE id = $1
+RETURNING id, job_name, status, instance, error, duration_ms, started_at, finished_at
+`
+
+type FinishJobRunParams struct {
+	ID         int64
+	Status     JobRunStatus
+	Error      pgtype.Text
+	DurationMs pgtype.Int8
+}
+
+func (q *Queries) FinishJobRun(ctx context.Context, arg *FinishJobRunParams) (*JobsRun, error) {
+	row := q.db.QueryRow(ctx, finishJobRun,
+		arg.ID,
+		arg.Status,
+		arg.Error,
+		arg.DurationMs,
+	)
+	var i JobsRun
+	err := row.Scan(
+		&i.ID,
+		&i.JobName,
+		&i.Status,
+		&i.Instance,
+		&i.Error,
+		&i.DurationMs,
+		&i.StartedAt,
+		&i.FinishedAt,
+	)
+	return &i, err
+}
+
+const getAddress = `-- name: GetAddress :one
+SELECT id, line1, line2, city, state, postal_code, country, created_at, updated_at, tenant_id, key_id, wrapped_key FROM addresses
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+`
+
+type GetAddressParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetAddress(ctx context.Context, arg *GetAddressParams) (*Address, error) {
+	row := q.db.QueryRow(ctx, getAddress, arg.ID, arg.TenantID)
+	var i Address
+	err := row.Scan(
+		&i.ID,
+		&i.Line1,
+		&i.Line2,
+		&i.City,
+		&i.State,
+		&i.PostalCode,
+		&i.Country,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+		&i.KeyID,
+		&i.WrappedKey,
+	)
+	return &i, err
+}
+
+const getClient = `-- name: GetClient :one
+SELECT id, name, secret_hash, created_at, updated_at, pending_order_ttl_seconds FROM clients
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetClient(ctx context.Context, id pgtype.UUID) (*Client, error) {
+	row := q.db.QueryRow(ctx, getClient, id)
+	var i Client
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PendingOrderTtlSeconds,
+	)
+	return &i, err
+}
+
+const getDataExport = `-- name: GetDataExport :one
+SELECT id, tenant_id, user_id, requested_by, status, archive, error, created_at, completed_at, expires_at FROM data_exports
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+`
+
+type GetDataExportParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetDataExport(ctx context.Context, arg *GetDataExportParams) (*DataExport, error) {
+	row := q.db.QueryRow(ctx, getDataExport, arg.ID, arg.TenantID)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.UserID,
+		&i.RequestedBy,
+		&i.Status,
+		&i.Archive,
+		&i.Error,
+		&i.CreatedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return &i, err
+}
+
+const getMqExchange = `-- name: GetMqExchange :one
+SELECT name, kind FROM mq_exchanges
+WHERE name = $1 LIMIT 1
+`
+
+func (q *Queries) GetMqExchange(ctx context.Context, name string) (*MqExchange, error) {
+	row := q.db.QueryRow(ctx, getMqExchange, name)
+	var i MqExchange
+	err := row.Scan(&i.Name, &i.Kind)
+	return &i, err
+}
+
+const getMqQueue = `-- name: GetMqQueue :one
+SELECT name, arguments FROM mq_queues
+WHERE name = $1 LIMIT 1
+`
+
+func (q *Queries) GetMqQueue(ctx context.Context, name string) (*MqQueue, error) {
+	row := q.db.QueryRow(ctx, getMqQueue, name)
+	var i MqQueue
+	err := row.Scan(&i.Name, &i.Arguments)
+	return &i, err
+}
+
+const getOrder = `-- name: GetOrder :one
+SELECT id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons FROM orders
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+`
+
+type GetOrderParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetOrder(ctx context.Context, arg *GetOrderParams) (*Order, error) {
+	row := q.db.QueryRow(ctx, getOrder, arg.ID, arg.TenantID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ShippingAddressID,
+		&i.BillingAddressID,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+		&i.FraudScore,
+		&i.FraudReasons,
+	)
+	return &i, err
+}
+
+const getOrderForUpdate = `-- name: GetOrderForUpdate :one
+SELECT id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons FROM orders
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+FOR UPDATE
+`
+
+type GetOrderForUpdateParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetOrderForUpdate(ctx context.Context, arg *GetOrderForUpdateParams) (*Order, error) {
+	row := q.db.QueryRow(ctx, getOrderForUpdate, arg.ID, arg.TenantID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ShippingAddressID,
+		&i.BillingAddressID,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+		&i.FraudScore,
+		&i.FraudReasons,
+	)
+	return &i, err
+}
+
+const getOrderReview = `-- name: GetOrderReview :one
+SELECT id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at FROM order_reviews
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+`
+
+type GetOrderReviewParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetOrderReview(ctx context.Context, arg *GetOrderReviewParams) (*OrderReview, error) {
+	row := q.db.QueryRow(ctx, getOrderReview, arg.ID, arg.TenantID)
+	var i OrderReview
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.TenantID,
+		&i.Status,
+		&i.Assignee,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.EscalatedAt,
+		&i.DecidedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return &i, err
+}
+
+const getOrderReviewForUpdate = `-- name: GetOrderReviewForUpdate :one
+SELECT id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at FROM order_reviews
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+FOR UPDATE
+`
+
+type GetOrderReviewForUpdateParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetOrderReviewForUpdate(ctx context.Context, arg *GetOrderReviewForUpdateParams) (*OrderReview, error) {
+	row := q.db.QueryRow(ctx, getOrderReviewForUpdate, arg.ID, arg.TenantID)
+	var i OrderReview
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.TenantID,
+		&i.Status,
+		&i.Assignee,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.EscalatedAt,
+		&i.DecidedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return &i, err
+}
+
+const getReturn = `-- name: GetReturn :one
+SELECT id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id FROM returns
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+`
+
+type GetReturnParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetReturn(ctx context.Context, arg *GetReturnParams) (*Return, error) {
+	row := q.db.QueryRow(ctx, getReturn, arg.ID, arg.TenantID)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RejectionReason,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const getReturnForUpdate = `-- name: GetReturnForUpdate :one
+SELECT id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id FROM returns
+WHERE id = $1 AND tenant_id = $2 LIMIT 1
+FOR UPDATE
+`
+
+type GetReturnForUpdateParams struct {
+	ID       pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) GetReturnForUpdate(ctx context.Context, arg *GetReturnForUpdateParams) (*Return, error) {
+	row := q.db.QueryRow(ctx, getReturnForUpdate, arg.ID, arg.TenantID)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RejectionReason,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const getUserOrderCounts = `-- name: GetUserOrderCounts :one
+SELECT COUNT(*) AS total_orders,
+       COUNT(*) FILTER (WHERE created_at >= $3) AS recent_orders
+FROM orders
+WHERE user_id = $1 AND tenant_id = $2
+`
+
+type GetUserOrderCountsParams struct {
+	UserID    pgtype.UUID
+	TenantID  pgtype.UUID
+	CreatedAt pgtype.Timestamptz
+}
+
+type GetUserOrderCountsRow struct {
+	TotalOrders  int64
+	RecentOrders int64
+}
+
+func (q *Queries) GetUserOrderCounts(ctx context.Context, arg *GetUserOrderCountsParams) (*GetUserOrderCountsRow, error) {
+	row := q.db.QueryRow(ctx, getUserOrderCounts, arg.UserID, arg.TenantID, arg.CreatedAt)
+	var i GetUserOrderCountsRow
+	err := row.Scan(&i.TotalOrders, &i.RecentOrders)
+	return &i, err
+}
+
+const inspectReturn = `-- name: InspectReturn :one
+UPDATE returns
+SET status = 'inspected', refund_amount = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id
+`
+
+type InspectReturnParams struct {
+	ID           pgtype.UUID
+	RefundAmount pgtype.Numeric
+	TenantID     pgtype.UUID
+}
+
+func (q *Queries) InspectReturn(ctx context.Context, arg *InspectReturnParams) (*Return, error) {
+	row := q.db.QueryRow(ctx, inspectReturn, arg.ID, arg.RefundAmount, arg.TenantID)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RejectionReason,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const listAddressesToReencrypt = `-- name: ListAddressesToReencrypt :many
+SELECT id, line1, line2, city, state, postal_code, country, created_at, updated_at, tenant_id, key_id, wrapped_key FROM addresses
+WHERE tenant_id = $1 AND key_id IS DISTINCT FROM $2::text
+ORDER BY id
+LIMIT $3
+FOR UPDATE SKIP LOCKED
+`
+
+type ListAddressesToReencryptParams struct {
+	TenantID pgtype.UUID
+	KeyID    string
+	Limit    int32
+}
+
+func (q *Queries) ListAddressesToReencrypt(ctx context.Context, arg *ListAddressesToReencryptParams) ([]*Address, error) {
+	rows, err := q.db.Query(ctx, listAddressesToReencrypt, arg.TenantID, arg.KeyID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Address{}
+	for rows.Next() {
+		var i Address
+		if err := rows.Scan(
+			&i.ID,
+			&i.Line1,
+			&i.Line2,
+			&i.City,
+			&i.State,
+			&i.PostalCode,
+			&i.Country,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+			&i.KeyID,
+			&i.WrappedKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAggregateOutboxMessages = `-- name: ListAggregateOutboxMessages :many
+SELECT id, message_type, payload, attempts, last_error, created_at, sent_at, tenant_id, aggregate_id, failed_at FROM outbox_messages
+WHERE tenant_id = $1 AND aggregate_id = ANY($2::text[])
+ORDER BY id
+`
+
+type ListAggregateOutboxMessagesParams struct {
+	TenantID     pgtype.UUID
+	AggregateIds []string
+}
+
+func (q *Queries) ListAggregateOutboxMessages(ctx context.Context, arg *ListAggregateOutboxMessagesParams) ([]*OutboxMessage, error) {
+	rows, err := q.db.Query(ctx, listAggregateOutboxMessages, arg.TenantID, arg.AggregateIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OutboxMessage{}
+	for rows.Next() {
+		var i OutboxMessage
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageType,
+			&i.Payload,
+			&i.Attempts,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.SentAt,
+			&i.TenantID,
+			&i.AggregateID,
+			&i.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listClients = `-- name: ListClients :many
+SELECT id, name, secret_hash, created_at, updated_at, pending_order_ttl_seconds FROM clients
+ORDER BY created_at
+`
+
+func (q *Queries) ListClients(ctx context.Context) ([]*Client, error) {
+	rows, err := q.db.Query(ctx, listClients)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Client{}
+	for rows.Next() {
+		var i Client
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.SecretHash,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PendingOrderTtlSeconds,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEventSourcedOrderIDs = `-- name: ListEventSourcedOrderIDs :many
+SELECT DISTINCT order_id FROM order_events
+WHERE tenant_id = $1
+ORDER BY order_id
+`
+
+func (q *Queries) ListEventSourcedOrderIDs(ctx context.Context, tenantID pgtype.UUID) ([]pgtype.UUID, error) {
+	rows, err := q.db.Query(ctx, listEventSourcedOrderIDs, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []pgtype.UUID{}
+	for rows.Next() {
+		var orderID pgtype.UUID
+		if err := rows.Scan(&orderID); err != nil {
+			return nil, err
+		}
+		items = append(items, orderID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJobRuns = `-- name: ListJobRuns :many
+SELECT id, job_name, status, instance, error, duration_ms, started_at, finished_at FROM jobs_runs
+WHERE job_name = $1
+ORDER BY started_at DESC
+LIMIT $2
+`
+
+type ListJobRunsParams struct {
+	JobName string
+	Limit   int32
+}
+
+func (q *Queries) ListJobRuns(ctx context.Context, arg *ListJobRunsParams) ([]*JobsRun, error) {
+	rows, err := q.db.Query(ctx, listJobRuns, arg.JobName, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*JobsRun{}
+	for rows.Next() {
+		var i JobsRun
+		if err := rows.Scan(
+			&i.ID,
+			&i.JobName,
+			&i.Status,
+			&i.Instance,
+			&i.Error,
+			&i.DurationMs,
+			&i.StartedAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMqBindings = `-- name: ListMqBindings :many
+SELECT queue, exchange, key FROM mq_bindings
+WHERE exchange = $1
+ORDER BY queue, key
+`
+
+func (q *Queries) ListMqBindings(ctx context.Context, exchange string) ([]*MqBinding, error) {
+	rows, err := q.db.Query(ctx, listMqBindings, exchange)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*MqBinding{}
+	for rows.Next() {
+		var i MqBinding
+		if err := rows.Scan(
+			&i.Queue,
+			&i.Exchange,
+			&i.Key,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderEvents = `-- name: ListOrderEvents :many
+SELECT id, order_id, version, event_type, payload, occurred_at, tenant_id FROM order_events
+WHERE order_id = $1 AND tenant_id = $2
+ORDER BY version
+`
+
+type ListOrderEventsParams struct {
+	OrderID  pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) ListOrderEvents(ctx context.Context, arg *ListOrderEventsParams) ([]*OrderEvent, error) {
+	rows, err := q.db.Query(ctx, listOrderEvents, arg.OrderID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OrderEvent{}
+	for rows.Next() {
+		var i OrderEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Version,
+			&i.EventType,
+			&i.Payload,
+			&i.OccurredAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderEventsUntil = `-- name: ListOrderEventsUntil :many
+SELECT id, order_id, version, event_type, payload, occurred_at, tenant_id FROM order_events
+WHERE order_id = $1 AND tenant_id = $2 AND occurred_at <= $3
+ORDER BY version
+`
+
+type ListOrderEventsUntilParams struct {
+	OrderID    pgtype.UUID
+	TenantID   pgtype.UUID
+	OccurredAt pgtype.Timestamptz
+}
+
+func (q *Queries) ListOrderEventsUntil(ctx context.Context, arg *ListOrderEventsUntilParams) ([]*OrderEvent, error) {
+	rows, err := q.db.Query(ctx, listOrderEventsUntil, arg.OrderID, arg.TenantID, arg.OccurredAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OrderEvent{}
+	for rows.Next() {
+		var i OrderEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Version,
+			&i.EventType,
+			&i.Payload,
+			&i.OccurredAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderEventsWithAddresses = `-- name: ListOrderEventsWithAddresses :many
+SELECT id, order_id, version, event_type, payload, occurred_at, tenant_id FROM order_events
+WHERE tenant_id = $1
+  AND (payload->'shipping_address'->>'line1' IS NOT NULL
+    OR payload->'billing_address'->>'line1' IS NOT NULL
+    OR payload->'address'->>'line1' IS NOT NULL)
+ORDER BY id
+LIMIT $2
+`
+
+type ListOrderEventsWithAddressesParams struct {
+	TenantID pgtype.UUID
+	Limit    int32
+}
+
+func (q *Queries) ListOrderEventsWithAddresses(ctx context.Context, arg *ListOrderEventsWithAddressesParams) ([]*OrderEvent, error) {
+	rows, err := q.db.Query(ctx, listOrderEventsWithAddresses, arg.TenantID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OrderEvent{}
+	for rows.Next() {
+		var i OrderEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Version,
+			&i.EventType,
+			&i.Payload,
+			&i.OccurredAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderItems = `-- name: ListOrderItems :many
+SELECT id, order_id, product_id, quantity, price, total_price, created_at, updated_at, tenant_id FROM order_items
+WHERE order_id = $1 AND tenant_id = $2
+ORDER BY updated_at
+`
+
+type ListOrderItemsParams struct {
+	OrderID  pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) ListOrderItems(ctx context.Context, arg *ListOrderItemsParams) ([]*OrderItem, error) {
+	rows, err := q.db.Query(ctx, listOrderItems, arg.OrderID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OrderItem{}
+	for rows.Next() {
+		var i OrderItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.Price,
+			&i.TotalPrice,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderReturns = `-- name: ListOrderReturns :many
+SELECT id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id FROM returns
+WHERE order_id = $1 AND tenant_id = $2
+ORDER BY created_at
+`
+
+type ListOrderReturnsParams struct {
+	OrderID  pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) ListOrderReturns(ctx context.Context, arg *ListOrderReturnsParams) ([]*Return, error) {
+	rows, err := q.db.Query(ctx, listOrderReturns, arg.OrderID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Return{}
+	for rows.Next() {
+		var i Return
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Status,
+			&i.Reason,
+			&i.RejectionReason,
+			&i.RefundAmount,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrders = `-- name: ListOrders :many
+SELECT id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons FROM orders
+WHERE user_id = $1 AND tenant_id = $2
+ORDER BY updated_at
+`
+
+type ListOrdersParams struct {
+	UserID   pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) ListOrders(ctx context.Context, arg *ListOrdersParams) ([]*Order, error) {
+	rows, err := q.db.Query(ctx, listOrders, arg.UserID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Order{}
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ShippingAddressID,
+			&i.BillingAddressID,
+			&i.TotalAmount,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+			&i.FraudScore,
+			&i.FraudReasons,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOverdueOrderReviews = `-- name: ListOverdueOrderReviews :many
+SELECT id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at FROM order_reviews
+WHERE tenant_id = $1 AND status = 'open' AND created_at <= $2
+ORDER BY created_at
+FOR UPDATE SKIP LOCKED
+`
+
+type ListOverdueOrderReviewsParams struct {
+	TenantID  pgtype.UUID
+	CreatedAt pgtype.Timestamptz
+}
+
+func (q *Queries) ListOverdueOrderReviews(ctx context.Context, arg *ListOverdueOrderReviewsParams) ([]*OrderReview, error) {
+	rows, err := q.db.Query(ctx, listOverdueOrderReviews, arg.TenantID, arg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OrderReview{}
+	for rows.Next() {
+		var i OrderReview
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.TenantID,
+			&i.Status,
+			&i.Assignee,
+			&i.DecidedBy,
+			&i.DecisionReason,
+			&i.EscalatedAt,
+			&i.DecidedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingDataExports = `-- name: ListPendingDataExports :many
+SELECT id, tenant_id, user_id, requested_by, status, archive, error, created_at, completed_at, expires_at FROM data_exports
+WHERE tenant_id = $1 AND status = 'pending'
+ORDER BY created_at
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`
+
+type ListPendingDataExportsParams struct {
+	TenantID pgtype.UUID
+	Limit    int32
+}
+
+func (q *Queries) ListPendingDataExports(ctx context.Context, arg *ListPendingDataExportsParams) ([]*DataExport, error) {
+	rows, err := q.db.Query(ctx, listPendingDataExports, arg.TenantID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*DataExport{}
+	for rows.Next() {
+		var i DataExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.UserID,
+			&i.RequestedBy,
+			&i.Status,
+			&i.Archive,
+			&i.Error,
+			&i.CreatedAt,
+			&i.CompletedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReturnItems = `-- name: ListReturnItems :many
+SELECT id, return_id, order_item_id, quantity, accepted_quantity, refund_amount, created_at, updated_at, tenant_id FROM return_items
+WHERE return_id = $1 AND tenant_id = $2
+ORDER BY created_at
+`
+
+type ListReturnItemsParams struct {
+	ReturnID pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) ListReturnItems(ctx context.Context, arg *ListReturnItemsParams) ([]*ReturnItem, error) {
+	rows, err := q.db.Query(ctx, listReturnItems, arg.ReturnID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*ReturnItem{}
+	for rows.Next() {
+		var i ReturnItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.ReturnID,
+			&i.OrderItemID,
+			&i.Quantity,
+			&i.AcceptedQuantity,
+			&i.RefundAmount,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReturnedQuantities = `-- name: ListReturnedQuantities :many
+SELECT ri.order_item_id,
+       SUM(CASE WHEN r.status = 'inspected' THEN COALESCE(ri.accepted_quantity, 0) ELSE ri.quantity END)::int AS reserved_quantity,
+       SUM(COALESCE(ri.accepted_quantity, 0))::int AS accepted_quantity
+FROM return_items ri
+JOIN returns r ON r.id = ri.return_id
+WHERE r.order_id = $1 AND r.tenant_id = $2 AND r.status <> 'rejected'
+GROUP BY ri.order_item_id
+`
+
+type ListReturnedQuantitiesParams struct {
+	OrderID  pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+type ListReturnedQuantitiesRow struct {
+	OrderItemID      pgtype.UUID
+	ReservedQuantity int32
+	AcceptedQuantity int32
+}
+
+func (q *Queries) ListReturnedQuantities(ctx context.Context, arg *ListReturnedQuantitiesParams) ([]*ListReturnedQuantitiesRow, error) {
+	rows, err := q.db.Query(ctx, listReturnedQuantities, arg.OrderID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*ListReturnedQuantitiesRow{}
+	for rows.Next() {
+		var i ListReturnedQuantitiesRow
+		if err := rows.Scan(&i.OrderItemID, &i.ReservedQuantity, &i.AcceptedQuantity); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStalePendingOrders = `-- name: ListStalePendingOrders :many
+SELECT o.id, o.user_id, o.shipping_address_id, o.billing_address_id, o.total_amount, o.status, o.created_at, o.updated_at, o.tenant_id, o.fraud_score, o.fraud_reasons FROM orders o
+WHERE o.tenant_id = $1 AND o.status = 'pending'
+  AND COALESCE((
+    SELECT MAX(e.occurred_at) FROM order_events e
+    WHERE e.order_id = o.id AND e.event_type = 'status_changed' AND e.payload->>'to' = 'pending'
+  ), o.created_at) <= $2
+ORDER BY o.created_at
+LIMIT $3
+FOR UPDATE OF o SKIP LOCKED
+`
+
+type ListStalePendingOrdersParams struct {
+	TenantID      pgtype.UUID
+	PendingBefore pgtype.Timestamptz
+	BatchSize     int32
+}
+
+func (q *Queries) ListStalePendingOrders(ctx context.Context, arg *ListStalePendingOrdersParams) ([]*Order, error) {
+	rows, err := q.db.Query(ctx, listStalePendingOrders, arg.TenantID, arg.PendingBefore, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Order{}
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ShippingAddressID,
+			&i.BillingAddressID,
+			&i.TotalAmount,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+			&i.FraudScore,
+			&i.FraudReasons,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUndecidedOrderReviews = `-- name: ListUndecidedOrderReviews :many
+SELECT id, order_id, tenant_id, status, assignee, decided_by, decision_reason, escalated_at, decided_at, created_at, updated_at FROM order_reviews
+WHERE tenant_id = $1 AND status IN ('open', 'escalated')
+ORDER BY created_at
+`
+
+func (q *Queries) ListUndecidedOrderReviews(ctx context.Context, tenantID pgtype.UUID) ([]*OrderReview, error) {
+	rows, err := q.db.Query(ctx, listUndecidedOrderReviews, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OrderReview{}
+	for rows.Next() {
+		var i OrderReview
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.TenantID,
+			&i.Status,
+			&i.Assignee,
+			&i.DecidedBy,
+			&i.DecisionReason,
+			&i.EscalatedAt,
+			&i.DecidedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUnsentOutboxMessages = `-- name: ListUnsentOutboxMessages :many
+SELECT id, message_type, payload, attempts, last_error, created_at, sent_at, tenant_id, aggregate_id, failed_at FROM outbox_messages
+WHERE sent_at IS NULL AND failed_at IS NULL
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`
+
+func (q *Queries) ListUnsentOutboxMessages(ctx context.Context, limit int32) ([]*OutboxMessage, error) {
+	rows, err := q.db.Query(ctx, listUnsentOutboxMessages, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*OutboxMessage{}
+	for rows.Next() {
+		var i OutboxMessage
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageType,
+			&i.Payload,
+			&i.Attempts,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.SentAt,
+			&i.TenantID,
+			&i.AggregateID,
+			&i.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserErasures = `-- name: ListUserErasures :many
+SELECT id, tenant_id, user_id, requested_by, reason, orders_count, addresses_count, events_count, created_at, exports_count FROM erasures
+WHERE user_id = $1 AND tenant_id = $2
+ORDER BY created_at
+`
+
+type ListUserErasuresParams struct {
+	UserID   pgtype.UUID
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) ListUserErasures(ctx context.Context, arg *ListUserErasuresParams) ([]*Erasure, error) {
+	rows, err := q.db.Query(ctx, listUserErasures, arg.UserID, arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Erasure{}
+	for rows.Next() {
+		var i Erasure
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.UserID,
+			&i.RequestedBy,
+			&i.Reason,
+			&i.OrdersCount,
+			&i.AddressesCount,
+			&i.EventsCount,
+			&i.CreatedAt,
+			&i.ExportsCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markOutboxMessageSent = `-- name: MarkOutboxMessageSent :exec
+UPDATE outbox_messages
+SET sent_at = NOW(), attempts = attempts + 1
+WHERE id = $1
+`
+
+func (q *Queries) MarkOutboxMessageSent(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markOutboxMessageSent, id)
+	return err
+}
+
+const parkOutboxMessage = `-- name: ParkOutboxMessage :exec
+UPDATE outbox_messages
+SET attempts = attempts + 1, last_error = $2, failed_at = NOW()
+WHERE id = $1
+`
+
+type ParkOutboxMessageParams struct {
+	ID        int64
+	LastError pgtype.Text
+}
+
+func (q *Queries) ParkOutboxMessage(ctx context.Context, arg *ParkOutboxMessageParams) error {
+	_, err := q.db.Exec(ctx, parkOutboxMessage, arg.ID, arg.LastError)
+	return err
+}
+
+const purgeMqQueue = `-- name: PurgeMqQueue :execrows
+DELETE FROM mq_messages
+WHERE queue = $1 AND (locked_until IS NULL OR locked_until < NOW())
+`
+
+func (q *Queries) PurgeMqQueue(ctx context.Context, queue string) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeMqQueue, queue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const receiveInboxMessage = `-- name: ReceiveInboxMessage :execrows
+INSERT INTO inbox_messages (
+ message_id, handler, tenant_id
+) VALUES (
+ $1, $2, $3
+)
+ON CONFLICT DO NOTHING
+`
+
+type ReceiveInboxMessageParams struct {
+	MessageID string
+	Handler   string
+	TenantID  pgtype.UUID
+}
+
+func (q *Queries) ReceiveInboxMessage(ctx context.Context, arg *ReceiveInboxMessageParams) (int64, error) {
+	result, err := q.db.Exec(ctx, receiveInboxMessage, arg.MessageID, arg.Handler, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordOutboxMessageFailure = `-- name: RecordOutboxMessageFailure :exec
+UPDATE outbox_messages
+SET attempts = attempts + 1, last_error = $2
+WHERE id = $1
+`
+
+type RecordOutboxMessageFailureParams struct {
+	ID        int64
+	LastError pgtype.Text
+}
+
+func (q *Queries) RecordOutboxMessageFailure(ctx context.Context, arg *RecordOutboxMessageFailureParams) error {
+	_, err := q.db.Exec(ctx, recordOutboxMessageFailure, arg.ID, arg.LastError)
+	return err
+}
+
+const redactOrderEventPayload = `-- name: RedactOrderEventPayload :exec
+UPDATE order_events
+SET payload = $2
+WHERE id = $1 AND tenant_id = $3
+`
+
+type RedactOrderEventPayloadParams struct {
+	ID       int64
+	Payload  []byte
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) RedactOrderEventPayload(ctx context.Context, arg *RedactOrderEventPayloadParams) error {
+	_, err := q.db.Exec(ctx, redactOrderEventPayload, arg.ID, arg.Payload, arg.TenantID)
+	return err
+}
+
+const rejectMqMessages = `-- name: RejectMqMessages :many
+DELETE FROM mq_messages
+WHERE locked_by = $1 AND id = ANY($2::bigint[])
+RETURNING id, queue, exchange, routing_key, content_type, message_id, correlation_id, published_at, headers, body, redelivered, expires_at, locked_by, locked_until, created_at
+`
+
+type RejectMqMessagesParams struct {
+	LockedBy pgtype.Text
+	Ids      []int64
+}
+
+func (q *Queries) RejectMqMessages(ctx context.Context, arg *RejectMqMessagesParams) ([]*MqMessage, error) {
+	rows, err := q.db.Query(ctx, rejectMqMessages, arg.LockedBy, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*MqMessage{}
+	for rows.Next() {
+		var i MqMessage
+		if err := rows.Scan(
+			&i.ID,
+			&i.Queue,
+			&i.Exchange,
+			&i.RoutingKey,
+			&i.ContentType,
+			&i.MessageID,
+			&i.CorrelationID,
+			&i.PublishedAt,
+			&i.Headers,
+			&i.Body,
+			&i.Redelivered,
+			&i.ExpiresAt,
+			&i.LockedBy,
+			&i.LockedUntil,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const rejectReturn = `-- name: RejectReturn :one
+UPDATE returns
+SET status = 'rejected', rejection_reason = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id
+`
+
+type RejectReturnParams struct {
+	ID              pgtype.UUID
+	RejectionReason pgtype.Text
+	TenantID        pgtype.UUID
+}
+
+func (q *Queries) RejectReturn(ctx context.Context, arg *RejectReturnParams) (*Return, error) {
+	row := q.db.QueryRow(ctx, rejectReturn, arg.ID, arg.RejectionReason, arg.TenantID)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RejectionReason,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const releaseMqChannel = `-- name: ReleaseMqChannel :exec
+UPDATE mq_messages
+SET locked_by = NULL, locked_until = NULL, redelivered = TRUE
+WHERE locked_by = $1
+`
+
+func (q *Queries) ReleaseMqChannel(ctx context.Context, lockedBy pgtype.Text) error {
+	_, err := q.db.Exec(ctx, releaseMqChannel, lockedBy)
+	return err
+}
+
+const releaseMqMessages = `-- name: ReleaseMqMessages :exec
+UPDATE mq_messages
+SET locked_by = NULL, locked_until = NULL, redelivered = TRUE
+WHERE locked_by = $1 AND id = ANY($2::bigint[])
+`
+
+type ReleaseMqMessagesParams struct {
+	LockedBy pgtype.Text
+	Ids      []int64
+}
+
+func (q *Queries) ReleaseMqMessages(ctx context.Context, arg *ReleaseMqMessagesParams) error {
+	_, err := q.db.Exec(ctx, releaseMqMessages, arg.LockedBy, arg.Ids)
+	return err
+}
+
+const setClientPendingOrderTTL = `-- name: SetClientPendingOrderTTL :one
+UPDATE clients
+SET pending_order_ttl_seconds = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, name, secret_hash, created_at, updated_at, pending_order_ttl_seconds
+`
+
+type SetClientPendingOrderTTLParams struct {
+	ID                     pgtype.UUID
+	PendingOrderTtlSeconds pgtype.Int4
+}
+
+func (q *Queries) SetClientPendingOrderTTL(ctx context.Context, arg *SetClientPendingOrderTTLParams) (*Client, error) {
+	row := q.db.QueryRow(ctx, setClientPendingOrderTTL, arg.ID, arg.PendingOrderTtlSeconds)
+	var i Client
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.SecretHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PendingOrderTtlSeconds,
+	)
+	return &i, err
+}
+
+const setTenant = `-- name: SetTenant :exec
+SELECT set_config('app.tenant_id', $1::text, true)
+`
+
+func (q *Queries) SetTenant(ctx context.Context, tenantID string) error {
+	_, err := q.db.Exec(ctx, setTenant, tenantID)
+	return err
+}
+
+const startJobRun = `-- name: StartJobRun :one
+INSERT INTO jobs_runs (
+ job_name, instance
+) VALUES (
+ $1, $2
+)
+RETURNING id, job_name, status, instance, error, duration_ms, started_at, finished_at
+`
+
+type StartJobRunParams struct {
+	JobName  string
+	Instance string
+}
+
+func (q *Queries) StartJobRun(ctx context.Context, arg *StartJobRunParams) (*JobsRun, error) {
+	row := q.db.QueryRow(ctx, startJobRun, arg.JobName, arg.Instance)
+	var i JobsRun
+	err := row.Scan(
+		&i.ID,
+		&i.JobName,
+		&i.Status,
+		&i.Instance,
+		&i.Error,
+		&i.DurationMs,
+		&i.StartedAt,
+		&i.FinishedAt,
+	)
+	return &i, err
+}
+
+const tryJobLock = `-- name: TryJobLock :one
+SELECT pg_try_advisory_xact_lock(hashtext('job:' || $1::text)) AS locked
+`
+
+func (q *Queries) TryJobLock(ctx context.Context, jobName string) (bool, error) {
+	row := q.db.QueryRow(ctx, tryJobLock, jobName)
+	var locked bool
+	err := row.Scan(&locked)
+	return locked, err
+}
+
+const tryOutboxLock = `-- name: TryOutboxLock :one
+SELECT pg_try_advisory_xact_lock(hashtext('outbox-relay')) AS locked
+`
+
+func (q *Queries) TryOutboxLock(ctx context.Context) (bool, error) {
+	row := q.db.QueryRow(ctx, tryOutboxLock)
+	var locked bool
+	err := row.Scan(&locked)
+	return locked, err
+}
+
+const updateAddressEncryption = `-- name: UpdateAddressEncryption :exec
+UPDATE addresses
+SET line1 = $2, line2 = $3, city = $4, postal_code = $5, key_id = $6, wrapped_key = $7
+WHERE id = $1 AND tenant_id = $8
+`
+
+type UpdateAddressEncryptionParams struct {
+	ID         pgtype.UUID
+	Line1      string
+	Line2      pgtype.Text
+	City       string
+	PostalCode string
+	KeyID      pgtype.Text
+	WrappedKey []byte
+	TenantID   pgtype.UUID
+}
+
+func (q *Queries) UpdateAddressEncryption(ctx context.Context, arg *UpdateAddressEncryptionParams) error {
+	_, err := q.db.Exec(ctx, updateAddressEncryption,
+		arg.ID,
+		arg.Line1,
+		arg.Line2,
+		arg.City,
+		arg.PostalCode,
+		arg.KeyID,
+		arg.WrappedKey,
+		arg.TenantID,
+	)
+	return err
+}
+
+const updateOrderAddresses = `-- name: UpdateOrderAddresses :one
+UPDATE orders
+SET shipping_address_id = $2, billing_address_id = $3, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $4
+RETURNING id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons
+`
+
+type UpdateOrderAddressesParams struct {
+	ID                pgtype.UUID
+	ShippingAddressID pgtype.UUID
+	BillingAddressID  pgtype.UUID
+	TenantID          pgtype.UUID
+}
+
+func (q *Queries) UpdateOrderAddresses(ctx context.Context, arg *UpdateOrderAddressesParams) (*Order, error) {
+	row := q.db.QueryRow(ctx, updateOrderAddresses,
+		arg.ID,
+		arg.ShippingAddressID,
+		arg.BillingAddressID,
+		arg.TenantID,
+	)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ShippingAddressID,
+		&i.BillingAddressID,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+		&i.FraudScore,
+		&i.FraudReasons,
+	)
+	return &i, err
+}
+
+const updateOrderStatus = `-- name: UpdateOrderStatus :one
+UPDATE orders
+SET status = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, user_id, shipping_address_id, billing_address_id, total_amount, status, created_at, updated_at, tenant_id, fraud_score, fraud_reasons
+`
+
+type UpdateOrderStatusParams struct {
+	ID       pgtype.UUID
+	Status   OrderStatus
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) UpdateOrderStatus(ctx context.Context, arg *UpdateOrderStatusParams) (*Order, error) {
+	row := q.db.QueryRow(ctx, updateOrderStatus, arg.ID, arg.Status, arg.TenantID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ShippingAddressID,
+		&i.BillingAddressID,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+		&i.FraudScore,
+		&i.FraudReasons,
+	)
+	return &i, err
+}
+
+const updateReturnItemInspection = `-- name: UpdateReturnItemInspection :one
+UPDATE return_items
+SET accepted_quantity = $2, refund_amount = $3, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $4
+RETURNING id, return_id, order_item_id, quantity, accepted_quantity, refund_amount, created_at, updated_at, tenant_id
+`
+
+type UpdateReturnItemInspectionParams struct {
+	ID               pgtype.UUID
+	AcceptedQuantity pgtype.Int4
+	RefundAmount     pgtype.Numeric
+	TenantID         pgtype.UUID
+}
+
+func (q *Queries) UpdateReturnItemInspection(ctx context.Context, arg *UpdateReturnItemInspectionParams) (*ReturnItem, error) {
+	row := q.db.QueryRow(ctx, updateReturnItemInspection,
+		arg.ID,
+		arg.AcceptedQuantity,
+		arg.RefundAmount,
+		arg.TenantID,
+	)
+	var i ReturnItem
+	err := row.Scan(
+		&i.ID,
+		&i.ReturnID,
+		&i.OrderItemID,
+		&i.Quantity,
+		&i.AcceptedQuantity,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const updateReturnStatus = `-- name: UpdateReturnStatus :one
+UPDATE returns
+SET status = $2, updated_at = NOW()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, order_id, status, reason, rejection_reason, refund_amount, created_at, updated_at, tenant_id
+`
+
+type UpdateReturnStatusParams struct {
+	ID       pgtype.UUID
+	Status   ReturnStatus
+	TenantID pgtype.UUID
+}
+
+func (q *Queries) UpdateReturnStatus(ctx context.Context, arg *UpdateReturnStatusParams) (*Return, error) {
+	row := q.db.QueryRow(ctx, updateReturnStatus, arg.ID, arg.Status, arg.TenantID)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RejectionReason,
+		&i.RefundAmount,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TenantID,
+	)
+	return &i, err
+}
+
+const upsertAddress = `-- name: UpsertAddress :exec
+INSERT INTO addresses (
+ id, line1, line2, city, state,
+ postal_code, country, created_at, tenant_id,
+ key_id, wrapped_key
+) VALUES (
+ $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+)
+ON CONFLICT (id) DO UPDATE SET
+ line1 = EXCLUDED.line1,
+ line2 = EXCLUDED.line2,
+ city = EXCLUDED.city,
+ state = EXCLUDED.state,
+ postal_code = EXCLUDED.postal_code,
+ country = EXCLUDED.country,
+ key_id = EXCLUDED.key_id,
+ wrapped_key = EXCLUDED.wrapped_key,
+ updated_at = NOW()
+`
+
+type UpsertAddressParams struct {
+	ID         pgtype.UUID
+	Line1      string
+	Line2      pgtype.Text
+	City       string
+	State      string
+	PostalCode string
+	Country    string
+	CreatedAt  pgtype.Timestamptz
+	TenantID   pgtype.UUID
+	KeyID      pgtype.Text
+	WrappedKey []byte
+}
+
+func (q *Queries) UpsertAddress(ctx context.Context, arg *UpsertAddressParams) error {
+	_, err := q.db.Exec(ctx, upsertAddress,
+		arg.ID,
+		arg.Line1,
+		arg.Line2,
+		arg.City,
+		arg.State,
+		arg.PostalCode,
+		arg.Country,
+		arg.CreatedAt,
+		arg.TenantID,
+		arg.KeyID,
+		arg.WrappedKey,
+	)
+	return err
+}
+
+const upsertOrder = `-- name: UpsertOrder :exec
+INSERT INTO orders (
+  id, user_id, total_amount, status,
+  shipping_address_id, billing_address_id,
+  created_at, updated_at, tenant_id,
+  fraud_score, fraud_reasons
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+)
+ON CONFLICT (id) DO UPDATE SET
+  user_id = EXCLUDED.user_id,
+  total_amount = EXCLUDED.total_amount,
+  status = EXCLUDED.status,
+  fraud_score = EXCLUDED.fraud_score,
+  fraud_reasons = EXCLUDED.fraud_reasons,
+  shipping_address_id = EXCLUDED.shipping_address_id,
+  billing_address_id = EXCLUDED.billing_address_id,
+  updated_at = EXCLUDED.updated_at
+`
+
+type UpsertOrderParams struct {
+	ID                pgtype.UUID
+	UserID            pgtype.UUID
+	TotalAmount       pgtype.Numeric
+	Status            OrderStatus
+	ShippingAddressID pgtype.UUID
+	BillingAddressID  pgtype.UUID
+	CreatedAt         pgtype.Timestamptz
+	UpdatedAt         pgtype.Timestamptz
+	TenantID          pgtype.UUID
+	FraudScore        int32
+	FraudReasons      []string
+}
+
+func (q *Queries) UpsertOrder(ctx context.Context, arg *UpsertOrderParams) error {
+	_, err := q.db.Exec(ctx, upsertOrder,
+		arg.ID,
+		arg.UserID,
+		arg.TotalAmount,
+		arg.Status,
+		arg.ShippingAddressID,
+		arg.BillingAddressID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.TenantID,
+		arg.FraudScore,
+		arg.FraudReasons,
+	)
+	return err
+}
+
+const upsertOrderItem = `-- name: UpsertOrderItem :exec
+INSERT INTO order_items (
+ id, order_id, product_id, quantity,
+ price, total_price, created_at, tenant_id
+) VALUES (
+ $1, $2, $3, $4, $5, $6, $7, $8
+)
+ON CONFLICT (id) DO UPDATE SET
+ product_id = EXCLUDED.product_id,
+ quantity = EXCLUDED.quantity,
+ price = EXCLUDED.price,
+ total_price = EXCLUDED.total_price,
+ updated_at = NOW()
+`
+
+type UpsertOrderItemParams struct {
+	ID         pgtype.UUID
+	OrderID    pgtype.UUID
+	ProductID  pgtype.UUID
+	Quantity   int32
+	Price      pgtype.Numeric
+	TotalPrice pgtype.Numeric
+	CreatedAt  pgtype.Timestamptz
+	TenantID   pgtype.UUID
+}
+
+func (q *Queries) UpsertOrderItem(ctx context.Context, arg *UpsertOrderItemParams) error {
+	_, err := q.db.Exec(ctx, upsertOrderItem,
+		arg.ID,
+		arg.OrderID,
+		arg.ProductID,
+		arg.Quantity,
+		arg.Price,
+		arg.TotalPrice,
+		arg.CreatedAt,
+		arg.TenantID,
+	)
+	return err
+}
@@ -0,0 +1,97 @@
+package encryption
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+// Represents the columns of an address holding personal data
+type AddressPII struct {
+	Line1      string
+	Line2      pgtype.Text
+	City       string
+	PostalCode string
+}
+
+// Represents the personal data of an address as it's stored. KeyID is NULL
+// and the data plaintext when encryption is disabled.
+type SealedAddress struct {
+	AddressPII
+	KeyID      pgtype.Text
+	WrappedKey []byte
+}
+
+// SealAddress encrypts the personal data of an address under a new data key.
+func (k *Keyring) SealAddress(a AddressPII) (*SealedAddress, error) {
+	if k == nil {
+		return &SealedAddress{AddressPII: a}, nil
+	}
+
+	dk, err := k.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &SealedAddress{
+		KeyID:      pgtype.Text{String: dk.KeyID, Valid: true},
+		WrappedKey: dk.Wrapped,
+	}
+
+	if out.Line1, err = dk.Encrypt(a.Line1, "line1"); err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt line1")
+	}
+	if out.City, err = dk.Encrypt(a.City, "city"); err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt city")
+	}
+	if out.PostalCode, err = dk.Encrypt(a.PostalCode, "postal_code"); err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt postal_code")
+	}
+	if a.Line2.Valid {
+		line2, err := dk.Encrypt(a.Line2.String, "line2")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt line2")
+		}
+		out.Line2 = pgtype.Text{String: line2, Valid: true}
+	}
+
+	return out, nil
+}
+
+// OpenAddress decrypts an address read from the database in place. Rows
+// without a key id are plaintext and left as they are.
+func (k *Keyring) OpenAddress(a *db.Address) error {
+	if !a.KeyID.Valid {
+		return nil
+	}
+	if k == nil {
+		return ErrDisabled
+	}
+
+	dk, err := k.Unwrap(a.KeyID.String, a.WrappedKey)
+	if err != nil {
+		return errors.Wrapf(err, "address %v", a.ID)
+	}
+
+	var pii AddressPII
+	if pii.Line1, err = dk.Decrypt(a.Line1, "line1"); err != nil {
+		return errors.Wrapf(err, "failed to decrypt line1 of address %v", a.ID)
+	}
+	if pii.City, err = dk.Decrypt(a.City, "city"); err != nil {
+		return errors.Wrapf(err, "failed to decrypt city of address %v", a.ID)
+	}
+	if pii.PostalCode, err = dk.Decrypt(a.PostalCode, "postal_code"); err != nil {
+		return errors.Wrapf(err, "failed to decrypt postal_code of address %v", a.ID)
+	}
+	if a.Line2.Valid {
+		line2, err := dk.Decrypt(a.Line2.String, "line2")
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt line2 of address %v", a.ID)
+		}
+		pii.Line2 = pgtype.Text{String: line2, Valid: true}
+	}
+
+	a.Line1, a.Line2, a.City, a.PostalCode = pii.Line1, pii.Line2, pii.City, pii.PostalCode
+	return nil
+}
@@ -0,0 +1,72 @@
+package encryption
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+func Test_SealOpenAddress(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+
+	pii := AddressPII{
+		Line1:      "1 Strand",
+		Line2:      pgtype.Text{String: "Flat 2", Valid: true},
+		City:       "London",
+		PostalCode: "WC2N 5HR",
+	}
+
+	sealed, err := k.SealAddress(pii)
+	if err != nil {
+		t.Fatalf("Expected address to be sealed %s", err)
+	}
+
+	if sealed.Line1 == pii.Line1 || sealed.City == pii.City || sealed.PostalCode == pii.PostalCode || sealed.Line2 == pii.Line2 {
+		t.Errorf("Expected every personal field to be encrypted, got %+v", sealed)
+	}
+
+	a := &db.Address{
+		Line1:      sealed.Line1,
+		Line2:      sealed.Line2,
+		City:       sealed.City,
+		State:      "LDN",
+		PostalCode: sealed.PostalCode,
+		KeyID:      sealed.KeyID,
+		WrappedKey: sealed.WrappedKey,
+	}
+
+	if err := k.OpenAddress(a); err != nil {
+		t.Fatalf("Expected address to be opened %s", err)
+	}
+
+	if a.Line1 != pii.Line1 || a.Line2 != pii.Line2 || a.City != pii.City || a.PostalCode != pii.PostalCode || a.State != "LDN" {
+		t.Errorf("Expected the original address, got %+v", a)
+	}
+
+	var disabled *Keyring
+	if err := disabled.OpenAddress(&db.Address{Line1: sealed.Line1, KeyID: sealed.KeyID, WrappedKey: sealed.WrappedKey}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Expected ErrDisabled reading an encrypted row without a keyring, got %v", err)
+	}
+}
+
+func Test_NilKeyringIsPlaintext(t *testing.T) {
+	var k *Keyring
+
+	pii := AddressPII{Line1: "1 Strand", City: "London", PostalCode: "WC2N 5HR"}
+	sealed, err := k.SealAddress(pii)
+	if err != nil {
+		t.Fatalf("Expected no error %s", err)
+	}
+
+	if sealed.AddressPII != pii || sealed.KeyID.Valid {
+		t.Errorf("Expected the address to be left plaintext, got %+v", sealed)
+	}
+
+	a := &db.Address{Line1: "1 Strand"}
+	if err := k.OpenAddress(a); err != nil || a.Line1 != "1 Strand" {
+		t.Errorf("Expected a plaintext row to be read as is, got %q %v", a.Line1, err)
+	}
+}
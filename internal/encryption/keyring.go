@@ -0,0 +1,189 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown master key")
+	ErrDisabled   = errors.New("value is encrypted but no keyfile is configured")
+)
+
+// Size of master and data keys; both are AES-256 keys
+const keySize = 32
+
+// Keyring holds the master keys data keys are wrapped with. New data keys are
+// wrapped with the current key; the others are kept to unwrap the data keys
+// of rows that haven't been re-encrypted yet. A nil *Keyring disables
+// encryption: values are written and read as they are.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// Represents the keyfile a Keyring is loaded from. Keys are base64 encoded
+// 32 byte keys, e.g. generated with `openssl rand -base64 32`.
+type keyfile struct {
+	CurrentKeyID string            `json:"current_key_id"`
+	Keys         map[string]string `json:"keys"`
+}
+
+// Load reads a keyring from a JSON keyfile.
+func Load(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read keyfile")
+	}
+
+	var f keyfile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, errors.Wrap(err, "failed to decode keyfile")
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode key %s", id)
+		}
+		keys[id] = key
+	}
+
+	return New(f.CurrentKeyID, keys)
+}
+
+// New returns a keyring wrapping new data keys with the key current.
+func New(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "current key %q", current)
+	}
+
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(key) != keySize {
+			return nil, errors.Errorf("key %s must be %d bytes, got %d", id, keySize, len(key))
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", id)
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// CurrentKeyID returns the id of the key new data keys are wrapped with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Represents a data key together with the master key it's wrapped with. Only
+// KeyID and Wrapped are stored.
+type DataKey struct {
+	KeyID   string
+	Wrapped []byte
+	aead    cipher.AEAD
+}
+
+// NewDataKey generates a data key wrapped with the current master key.
+func (k *Keyring) NewDataKey() (*DataKey, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate data key")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(k.keys[k.current], key, k.current)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wrap data key")
+	}
+
+	return &DataKey{KeyID: k.current, Wrapped: wrapped, aead: aead}, nil
+}
+
+// Unwrap returns the data key wrapped with the master key keyID.
+func (k *Keyring) Unwrap(keyID string, wrapped []byte) (*DataKey, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", keyID)
+	}
+
+	key, err := open(master, wrapped, keyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unwrap data key")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataKey{KeyID: keyID, Wrapped: wrapped, aead: aead}, nil
+}
+
+// Encrypt returns the base64 encoded ciphertext of plaintext. aad, e.g. the
+// column name, must be passed to Decrypt again so a value can't be moved to
+// another column.
+func (d *DataKey) Encrypt(plaintext string, aad string) (string, error) {
+	b, err := seal(d.aead, []byte(plaintext), aad)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (d *DataKey) Decrypt(ciphertext string, aad string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode ciphertext")
+	}
+
+	plaintext, err := open(d.aead, b, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, aad string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(aad)), nil
+}
+
+func open(aead cipher.AEAD, b []byte, aad string) ([]byte, error) {
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(aad))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,101 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func testKeyring(t *testing.T, current string, ids ...string) *Keyring {
+	keys := make(map[string][]byte, len(ids))
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, keySize)
+	}
+
+	k, err := New(current, keys)
+	if err != nil {
+		t.Fatalf("Failed to create keyring %s", err)
+	}
+	return k
+}
+
+func Test_DataKeyRoundTrip(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+
+	dk, err := k.NewDataKey()
+	if err != nil {
+		t.Fatalf("Expected data key to be generated %s", err)
+	}
+
+	ciphertext, err := dk.Encrypt("1 Strand", "line1")
+	if err != nil {
+		t.Fatalf("Expected value to be encrypted %s", err)
+	}
+
+	if ciphertext == "1 Strand" {
+		t.Error("Expected the value to be encrypted")
+	}
+
+	unwrapped, err := k.Unwrap(dk.KeyID, dk.Wrapped)
+	if err != nil {
+		t.Fatalf("Expected data key to be unwrapped %s", err)
+	}
+
+	plaintext, err := unwrapped.Decrypt(ciphertext, "line1")
+	if err != nil || plaintext != "1 Strand" {
+		t.Errorf("Expected 1 Strand, got %q %v", plaintext, err)
+	}
+
+	if _, err := unwrapped.Decrypt(ciphertext, "city"); err == nil {
+		t.Error("Expected a value moved to another column not to decrypt")
+	}
+}
+
+func Test_KeyRotation(t *testing.T) {
+	old := testKeyring(t, "k1", "k1")
+	dk, _ := old.NewDataKey()
+
+	// k2 is the new current key, k1 is kept to read rows not yet re-encrypted
+	rotated := testKeyring(t, "k2", "k1", "k2")
+	if _, err := rotated.Unwrap(dk.KeyID, dk.Wrapped); err != nil {
+		t.Errorf("Expected a data key wrapped with the old key to unwrap %s", err)
+	}
+
+	fresh, _ := rotated.NewDataKey()
+	if fresh.KeyID != "k2" {
+		t.Errorf("Expected new data keys to be wrapped with k2, got %s", fresh.KeyID)
+	}
+
+	if _, err := old.Unwrap(fresh.KeyID, fresh.Wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func Test_Load(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, keySize))
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`{"current_key_id": "k1", "keys": {"k1": "`+key+`"}}`), 0600); err != nil {
+		t.Fatalf("Failed to write keyfile %s", err)
+	}
+
+	k, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected keyfile to load %s", err)
+	}
+
+	if k.CurrentKeyID() != "k1" {
+		t.Errorf("Expected current key k1, got %s", k.CurrentKeyID())
+	}
+
+	if _, err := New("k2", map[string][]byte{"k1": bytes.Repeat([]byte{7}, keySize)}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected a missing current key to be rejected, got %v", err)
+	}
+
+	if _, err := New("k1", map[string][]byte{"k1": []byte("short")}); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
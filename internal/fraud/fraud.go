@@ -0,0 +1,111 @@
+package fraud
+
+import (
+	"time"
+)
+
+// Represents what the rules know about an incoming order
+type Order struct {
+	UserID          string
+	TotalAmount     float64
+	ShippingCountry string
+	BillingCountry  string
+	// Number of orders the user placed before this one
+	PreviousOrders int
+	// Number of orders the user placed within the engine's Window
+	RecentOrders int
+}
+
+// A Rule scores a single risk signal. It returns the weight the order adds to
+// the score and why, or 0 when the order doesn't match.
+type Rule interface {
+	Score(Order) (int32, string)
+}
+
+type Config struct {
+	// Orders scoring at or above this are put on hold
+	HoldThreshold int32
+
+	HighAmount       float64
+	HighAmountWeight int32
+
+	CountryMismatchWeight int32
+
+	VelocityWindow    time.Duration
+	VelocityMaxOrders int
+	VelocityWeight    int32
+
+	NewUserBasketAmount float64
+	NewUserBasketWeight int32
+}
+
+func DefaultConfig() Config {
+	return Config{
+		HoldThreshold:         50,
+		HighAmount:            1000,
+		HighAmountWeight:      40,
+		CountryMismatchWeight: 30,
+		VelocityWindow:        time.Hour,
+		VelocityMaxOrders:     3,
+		VelocityWeight:        30,
+		NewUserBasketAmount:   500,
+		NewUserBasketWeight:   25,
+	}
+}
+
+// Engine scores orders against its rules. Rules can be appended to plug in
+// checks beyond the default ones.
+type Engine struct {
+	HoldThreshold int32
+	// Window Order.RecentOrders is counted over
+	Window time.Duration
+	Rules  []Rule
+}
+
+// New returns an engine with the default rules configured from cfg. A rule
+// with a weight of 0 is left out.
+func New(cfg Config) *Engine {
+	e := &Engine{
+		HoldThreshold: cfg.HoldThreshold,
+		Window:        cfg.VelocityWindow,
+	}
+
+	if cfg.HighAmountWeight > 0 {
+		e.Rules = append(e.Rules, HighAmount{Threshold: cfg.HighAmount, Weight: cfg.HighAmountWeight})
+	}
+	if cfg.CountryMismatchWeight > 0 {
+		e.Rules = append(e.Rules, CountryMismatch{Weight: cfg.CountryMismatchWeight})
+	}
+	if cfg.VelocityWeight > 0 {
+		e.Rules = append(e.Rules, Velocity{MaxOrders: cfg.VelocityMaxOrders, Weight: cfg.VelocityWeight})
+	}
+	if cfg.NewUserBasketWeight > 0 {
+		e.Rules = append(e.Rules, NewUserLargeBasket{Amount: cfg.NewUserBasketAmount, Weight: cfg.NewUserBasketWeight})
+	}
+
+	return e
+}
+
+// Represents the outcome of scoring an order
+type Result struct {
+	Score   int32
+	Reasons []string
+	Hold    bool
+}
+
+func (e *Engine) Evaluate(o Order) Result {
+	r := Result{Reasons: []string{}}
+
+	for _, rule := range e.Rules {
+		weight, reason := rule.Score(o)
+		if weight <= 0 {
+			continue
+		}
+		r.Score += weight
+		r.Reasons = append(r.Reasons, reason)
+	}
+
+	r.Hold = e.HoldThreshold > 0 && r.Score >= e.HoldThreshold
+
+	return r
+}
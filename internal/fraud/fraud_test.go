@@ -0,0 +1,65 @@
+package fraud
+
+import (
+	"testing"
+)
+
+func Test_Rules(t *testing.T) {
+	cases := []struct {
+		name  string
+		rule  Rule
+		order Order
+		hit   bool
+	}{
+		{"high amount", HighAmount{Threshold: 1000, Weight: 40}, Order{TotalAmount: 1000}, true},
+		{"low amount", HighAmount{Threshold: 1000, Weight: 40}, Order{TotalAmount: 999.99}, false},
+		{"country mismatch", CountryMismatch{Weight: 30}, Order{ShippingCountry: "GB", BillingCountry: "NG"}, true},
+		{"same country", CountryMismatch{Weight: 30}, Order{ShippingCountry: "GB", BillingCountry: "gb"}, false},
+		{"unknown country", CountryMismatch{Weight: 30}, Order{ShippingCountry: "GB"}, false},
+		{"velocity", Velocity{MaxOrders: 3, Weight: 30}, Order{RecentOrders: 3}, true},
+		{"slow user", Velocity{MaxOrders: 3, Weight: 30}, Order{RecentOrders: 2}, false},
+		{"new user large basket", NewUserLargeBasket{Amount: 500, Weight: 25}, Order{TotalAmount: 600}, true},
+		{"returning user large basket", NewUserLargeBasket{Amount: 500, Weight: 25}, Order{TotalAmount: 600, PreviousOrders: 1}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			weight, reason := c.rule.Score(c.order)
+			if hit := weight > 0; hit != c.hit {
+				t.Errorf("Expected hit to be %v, got weight %d", c.hit, weight)
+			}
+			if c.hit && reason == "" {
+				t.Error("Expected a reason")
+			}
+		})
+	}
+}
+
+func Test_Evaluate(t *testing.T) {
+	e := New(DefaultConfig())
+
+	r := e.Evaluate(Order{TotalAmount: 20, PreviousOrders: 4})
+	if r.Score != 0 || r.Hold || len(r.Reasons) != 0 {
+		t.Errorf("Expected a clean order, got %+v", r)
+	}
+
+	// high amount (40) + new user with a large basket (25)
+	r = e.Evaluate(Order{TotalAmount: 1500})
+	if r.Score != 65 {
+		t.Errorf("Expected a score of 65, got %d", r.Score)
+	}
+
+	if !r.Hold {
+		t.Error("Expected the order to be held")
+	}
+
+	if len(r.Reasons) != 2 {
+		t.Errorf("Expected two reasons, got %v", r.Reasons)
+	}
+
+	cfg := DefaultConfig()
+	cfg.HighAmountWeight = 0
+	if r := New(cfg).Evaluate(Order{TotalAmount: 1500}); r.Hold {
+		t.Errorf("Expected a disabled rule not to count, got %+v", r)
+	}
+}
@@ -0,0 +1,60 @@
+package fraud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HighAmount matches orders whose total is at least Threshold
+type HighAmount struct {
+	Threshold float64
+	Weight    int32
+}
+
+func (r HighAmount) Score(o Order) (int32, string) {
+	if o.TotalAmount < r.Threshold {
+		return 0, ""
+	}
+	return r.Weight, fmt.Sprintf("total amount %.2f is at least %.2f", o.TotalAmount, r.Threshold)
+}
+
+// CountryMismatch matches orders shipped to a different country than the one
+// they are billed to
+type CountryMismatch struct {
+	Weight int32
+}
+
+func (r CountryMismatch) Score(o Order) (int32, string) {
+	if o.ShippingCountry == "" || o.BillingCountry == "" || strings.EqualFold(o.ShippingCountry, o.BillingCountry) {
+		return 0, ""
+	}
+	return r.Weight, fmt.Sprintf("shipping country %s differs from billing country %s", o.ShippingCountry, o.BillingCountry)
+}
+
+// Velocity matches users who placed MaxOrders or more orders within the
+// engine's window before this one
+type Velocity struct {
+	MaxOrders int
+	Weight    int32
+}
+
+func (r Velocity) Score(o Order) (int32, string) {
+	if o.RecentOrders < r.MaxOrders {
+		return 0, ""
+	}
+	return r.Weight, fmt.Sprintf("user placed %d orders in the velocity window", o.RecentOrders)
+}
+
+// NewUserLargeBasket matches a user's first order when its total is at least
+// Amount
+type NewUserLargeBasket struct {
+	Amount float64
+	Weight int32
+}
+
+func (r NewUserLargeBasket) Score(o Order) (int32, string) {
+	if o.PreviousOrders > 0 || o.TotalAmount < r.Amount {
+		return 0, ""
+	}
+	return r.Weight, fmt.Sprintf("first order of the user totals %.2f", o.TotalAmount)
+}
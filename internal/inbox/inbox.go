@@ -0,0 +1,50 @@
+package inbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+)
+
+// Receive records that handler received the message it's handling in ctx and
+// reports whether it's the first time. Callers pass the queries of the
+// transaction their changes are made in, so the message is recorded if and only
+// if it was handled: a message redelivered after that is acked without being
+// handled again, and one whose handling failed is handled when redelivered.
+//
+// Handling a message blocks while another transaction handles the same one, and
+// then reports it received if that transaction committed. Messages without an
+// id, e.g. published before ids were, are always handled.
+func Receive(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, handler string) (bool, error) {
+	info, ok := rabbitmq.MessageInfoFromContext(ctx)
+	if !ok || info.ID == "" {
+		return true, nil
+	}
+
+	n, err := q.ReceiveInboxMessage(ctx, &db.ReceiveInboxMessageParams{
+		MessageID: info.ID,
+		Handler:   handler,
+		TenantID:  tenantID,
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record inbox message")
+	}
+	return n == 1, nil
+}
+
+// Prune deletes the messages received before the retention period and returns
+// how many were deleted. Messages redelivered after that are handled again, so
+// the retention must outlast the retries of a message. It is run by the
+// scheduler.
+func Prune(ctx context.Context, q *db.Queries, retention time.Duration) (int64, error) {
+	n, err := q.DeleteInboxMessagesBefore(ctx, pgtype.Timestamptz{Time: time.Now().Add(-retention), Valid: true})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to prune inbox messages")
+	}
+	return n, nil
+}
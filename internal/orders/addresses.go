@@ -0,0 +1,337 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/encryption"
+)
+
+const reencryptBatchSize = 100
+
+// createAddress encrypts the personal data of the address, inserts it and
+// returns the row decrypted.
+func (p *processor) createAddress(ctx context.Context, q *db.Queries, arg db.CreateAddressParams) (*db.Address, error) {
+	sealed, err := p.keys.SealAddress(encryption.AddressPII{
+		Line1:      arg.Line1,
+		Line2:      arg.Line2,
+		City:       arg.City,
+		PostalCode: arg.PostalCode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	arg.Line1, arg.Line2, arg.City, arg.PostalCode = sealed.Line1, sealed.Line2, sealed.City, sealed.PostalCode
+	arg.KeyID, arg.WrappedKey = sealed.KeyID, sealed.WrappedKey
+
+	a, err := q.CreateAddress(ctx, &arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.keys.OpenAddress(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// getAddress returns the address decrypted
+func (p *processor) getAddress(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, ID pgtype.UUID) (*db.Address, error) {
+	a, err := q.GetAddress(ctx, &db.GetAddressParams{ID: ID, TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.keys.OpenAddress(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// resolveAddress fills in an address recorded by id from its row, decrypted.
+// Addresses older events recorded in full are left as they are.
+func (p *processor) resolveAddress(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, a *EventAddress) error {
+	if a == nil || a.inline() {
+		return nil
+	}
+
+	var id pgtype.UUID
+	if err := id.Scan(a.ID); err != nil {
+		return errors.Wrap(err, "failed to parse UUID")
+	}
+
+	row, err := p.getAddress(ctx, q, tenantID, id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch address %s", a.ID)
+	}
+
+	*a = EventAddress{
+		ID:         a.ID,
+		Line1:      row.Line1,
+		City:       row.City,
+		State:      row.State,
+		PostalCode: row.PostalCode,
+		Country:    row.Country,
+	}
+	if row.Line2.Valid {
+		a.Line2 = &row.Line2.String
+	}
+	return nil
+}
+
+// upsertAddress returns the id of the address of a rebuilt order. An address
+// recorded by id must exist already; one recorded in full is written back.
+func (p *processor) upsertAddress(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, a *EventAddress, createdAt time.Time) (pgtype.UUID, error) {
+	var id pgtype.UUID
+	if a == nil {
+		return id, nil
+	}
+
+	if err := id.Scan(a.ID); err != nil {
+		return id, errors.Wrap(err, "failed to parse UUID")
+	}
+
+	if !a.inline() {
+		if _, err := q.GetAddress(ctx, &db.GetAddressParams{ID: id, TenantID: tenantID}); err != nil {
+			return id, errors.Wrapf(err, "failed to fetch address %s", a.ID)
+		}
+		return id, nil
+	}
+
+	line2 := pgtype.Text{}
+	if a.Line2 != nil {
+		line2 = pgtype.Text{String: *a.Line2, Valid: true}
+	}
+
+	sealed, err := p.keys.SealAddress(encryption.AddressPII{
+		Line1:      a.Line1,
+		Line2:      line2,
+		City:       a.City,
+		PostalCode: a.PostalCode,
+	})
+	if err != nil {
+		return id, err
+	}
+
+	if err := q.UpsertAddress(ctx, &db.UpsertAddressParams{
+		ID:         id,
+		Line1:      sealed.Line1,
+		Line2:      sealed.Line2,
+		City:       sealed.City,
+		State:      a.State,
+		PostalCode: sealed.PostalCode,
+		Country:    a.Country,
+		CreatedAt:  pgtype.Timestamptz{Time: createdAt, Valid: true},
+		TenantID:   tenantID,
+		KeyID:      sealed.KeyID,
+		WrappedKey: sealed.WrappedKey,
+	}); err != nil {
+		return id, errors.Wrapf(err, "failed to upsert address %s", a.ID)
+	}
+
+	return id, nil
+}
+
+// ReencryptAddresses re-encrypts, for every client, the addresses not
+// encrypted under the current master key, plaintext ones included, each
+// under a new data key, and replaces the addresses older order events
+// recorded in full with their ids. It returns how many addresses were
+// re-encrypted and how many events were stripped. Once it's done the keys
+// the addresses were encrypted with before can be removed from the keyfile.
+func (p *processor) ReencryptAddresses(ctx context.Context) (int, int, error) {
+	if p.keys == nil {
+		return 0, 0, errors.New("no keyfile configured")
+	}
+
+	clients, err := p.queries.ListClients(ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to list clients")
+	}
+
+	n, stripped := 0, 0
+	for _, c := range clients {
+		for {
+			reencrypted, err := p.reencryptAddresses(ctx, c.ID)
+			n += reencrypted
+			if err != nil {
+				return n, stripped, err
+			}
+			if reencrypted < reencryptBatchSize {
+				break
+			}
+		}
+
+		for {
+			events, err := p.stripEventAddresses(ctx, c.ID)
+			stripped += events
+			if err != nil {
+				return n, stripped, err
+			}
+			if events < reencryptBatchSize {
+				break
+			}
+		}
+	}
+
+	log.Infof("Re-encrypted %d addresses under key %s, stripped the addresses of %d order events", n, p.keys.CurrentKeyID(), stripped)
+	return n, stripped, nil
+}
+
+func (p *processor) reencryptAddresses(ctx context.Context, tenantID pgtype.UUID) (int, error) {
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	addresses, err := q.ListAddressesToReencrypt(ctx, &db.ListAddressesToReencryptParams{
+		TenantID: tenantID,
+		KeyID:    p.keys.CurrentKeyID(),
+		Limit:    reencryptBatchSize,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch addresses")
+	}
+
+	for _, a := range addresses {
+		if err := p.keys.OpenAddress(a); err != nil {
+			return 0, err
+		}
+
+		sealed, err := p.keys.SealAddress(encryption.AddressPII{
+			Line1:      a.Line1,
+			Line2:      a.Line2,
+			City:       a.City,
+			PostalCode: a.PostalCode,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if err := q.UpdateAddressEncryption(ctx, &db.UpdateAddressEncryptionParams{
+			ID:         a.ID,
+			Line1:      sealed.Line1,
+			Line2:      sealed.Line2,
+			City:       sealed.City,
+			PostalCode: sealed.PostalCode,
+			KeyID:      sealed.KeyID,
+			WrappedKey: sealed.WrappedKey,
+			TenantID:   tenantID,
+		}); err != nil {
+			return 0, errors.Wrapf(err, "failed to re-encrypt address %v", a.ID)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit re-encrypted addresses")
+	}
+
+	return len(addresses), nil
+}
+
+// stripEventAddresses replaces the addresses a batch of order events recorded
+// in full with their ids and returns how many events were rewritten. An
+// address whose row is missing is written back from the event first, so
+// nothing is lost.
+func (p *processor) stripEventAddresses(ctx context.Context, tenantID pgtype.UUID) (int, error) {
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	evs, err := q.ListOrderEventsWithAddresses(ctx, &db.ListOrderEventsWithAddressesParams{
+		TenantID: tenantID,
+		Limit:    reencryptBatchSize,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch order events")
+	}
+	if len(evs) == 0 {
+		return 0, nil
+	}
+
+	// lets this transaction rewrite the otherwise append-only order events
+	if err := q.EnableErasure(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to enable erasure")
+	}
+
+	for _, e := range evs {
+		payload, err := p.stripAddresses(ctx, q, tenantID, e)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to strip event %d", e.ID)
+		}
+
+		if err := q.RedactOrderEventPayload(ctx, &db.RedactOrderEventPayloadParams{
+			ID:       e.ID,
+			Payload:  payload,
+			TenantID: tenantID,
+		}); err != nil {
+			return 0, errors.Wrapf(err, "failed to strip event %d", e.ID)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit stripped events")
+	}
+
+	return len(evs), nil
+}
+
+// stripAddresses returns the payload of e with every address recorded in full
+// replaced by its id. Other fields are kept as they are.
+func (p *processor) stripAddresses(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, e *db.OrderEvent) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.Payload, &fields); err != nil {
+		return nil, err
+	}
+
+	for _, key := range eventAddressKeys {
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+
+		var a *EventAddress
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		if a == nil || !a.inline() {
+			continue
+		}
+
+		var id pgtype.UUID
+		if err := id.Scan(a.ID); err != nil {
+			return nil, errors.Wrap(err, "failed to parse UUID")
+		}
+
+		if _, err := q.GetAddress(ctx, &db.GetAddressParams{ID: id, TenantID: tenantID}); err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return nil, errors.Wrapf(err, "failed to fetch address %s", a.ID)
+			}
+			if _, err := p.upsertAddress(ctx, q, tenantID, a, e.OccurredAt.Time); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(EventAddress{ID: a.ID})
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = b
+	}
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,153 @@
+package orders
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/encryption"
+	"github.com/ponty96/simple-web-app/internal/fraud"
+)
+
+func Test_EncryptedAddresses(t *testing.T) {
+	conn := SetupTestDb(t)
+	ctx := context.Background()
+	defer conn.Close(ctx)
+
+	k1 := bytes.Repeat([]byte{1}, 32)
+	keys, err := encryption.New("k1", map[string][]byte{"k1": k1})
+	if err != nil {
+		t.Fatalf("Failed to create keyring %s", err)
+	}
+
+	p := NewProcessor(conn, fraud.New(fraud.DefaultConfig()), keys)
+	ctx, tenantID := newTenant(t, ctx, p)
+
+	userId := pgtype.UUID{Bytes: [16]byte{9}, Valid: true}
+
+	o := schemas.Order{
+		UserId:          userId.String(),
+		OrderStatus:     "pending",
+		TotalAmount:     20,
+		ShippingAddress: &schemas.Address{City: "London", State: "LDN", Street: "1 Strand", Zip: "WC2N 5HR"},
+		BillingAddress:  &schemas.Address{City: "London", State: "LDN", Street: "1 Strand", Zip: "WC2N 5HR"},
+	}
+
+	if err := p.NewOrder(ctx, &o); err != nil {
+		t.Fatalf("Expected successfully created order %s", err)
+	}
+
+	os, err := p.queries.ListOrders(ctx, &db.ListOrdersParams{UserID: userId, TenantID: tenantID})
+	if err != nil || len(os) != 1 {
+		t.Fatalf("Expected one order %v", err)
+	}
+
+	raw, err := p.queries.GetAddress(ctx, &db.GetAddressParams{ID: os[0].ShippingAddressID, TenantID: tenantID})
+	if err != nil {
+		t.Fatalf("Expected address to be fetched %s", err)
+	}
+
+	if raw.Line1 == "1 Strand" || raw.PostalCode == "WC2N 5HR" || raw.KeyID.String != "k1" {
+		t.Errorf("Expected the address to be stored encrypted under k1, got %+v", raw)
+	}
+
+	listed, err := p.ListUserOrders(ctx, userId.String())
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("Expected one order %v", err)
+	}
+
+	if listed[0].ShippingAddress.Line1 != "1 Strand" || listed[0].ShippingAddress.PostalCode != "WC2N 5HR" {
+		t.Errorf("Expected the address to be decrypted, got %+v", listed[0].ShippingAddress)
+	}
+
+	// rotate to k2, keeping k1 to read the rows not re-encrypted yet
+	rotated, err := encryption.New("k2", map[string][]byte{"k1": k1, "k2": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatalf("Failed to create keyring %s", err)
+	}
+
+	p = NewProcessor(conn, fraud.New(fraud.DefaultConfig()), rotated)
+	if _, err := p.reencryptAddresses(ctx, tenantID); err != nil {
+		t.Fatalf("Expected addresses to be re-encrypted %s", err)
+	}
+
+	a, err := p.getAddress(ctx, p.queries, tenantID, os[0].ShippingAddressID)
+	if err != nil {
+		t.Fatalf("Expected address to be fetched %s", err)
+	}
+
+	if a.KeyID.String != "k2" || a.Line1 != "1 Strand" {
+		t.Errorf("Expected the address to be readable under k2, got %+v", a)
+	}
+}
+
+func Test_StripEventAddresses(t *testing.T) {
+	conn := SetupTestDb(t)
+	ctx := context.Background()
+	defer conn.Close(ctx)
+
+	p := NewProcessor(conn, fraud.New(fraud.DefaultConfig()), nil)
+	ctx, tenantID := newTenant(t, ctx, p)
+
+	userId := pgtype.UUID{Bytes: [16]byte{13}, Valid: true}
+
+	if err := p.NewOrder(ctx, &schemas.Order{
+		UserId:          userId.String(),
+		OrderStatus:     "pending",
+		TotalAmount:     20,
+		ShippingAddress: &schemas.Address{City: "London", State: "LDN", Street: "1 Strand", Zip: "WC2N 5HR"},
+	}); err != nil {
+		t.Fatalf("Expected successfully created order %s", err)
+	}
+
+	os, err := p.queries.ListOrders(ctx, &db.ListOrdersParams{UserID: userId, TenantID: tenantID})
+	if err != nil || len(os) != 1 {
+		t.Fatalf("Expected one order %v", err)
+	}
+
+	// an event recorded before addresses were recorded by id
+	legacy := fmt.Sprintf(`{"kind": "shipping", "address": {"id": %q, "line1": "1 Strand", "line2": null, "city": "London", "state": "LDN", "postal_code": "WC2N 5HR", "country": "GB"}}`, os[0].ShippingAddressID.String())
+	if _, err := p.queries.AppendOrderEvent(ctx, &db.AppendOrderEventParams{
+		OrderID:   os[0].ID,
+		EventType: db.OrderEventTypeAddressCorrected,
+		Payload:   []byte(legacy),
+		TenantID:  tenantID,
+	}); err != nil {
+		t.Fatalf("Failed to append event %s", err)
+	}
+
+	n, err := p.stripEventAddresses(ctx, tenantID)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one event to be stripped, got %d %v", n, err)
+	}
+
+	evs, err := p.ListOrderEvents(ctx, os[0].ID.String())
+	if err != nil {
+		t.Fatalf("Expected order events %s", err)
+	}
+	for _, e := range evs {
+		if strings.Contains(string(e.Payload), "Strand") {
+			t.Errorf("Expected no address in the %s event, got %s", e.Type, e.Payload)
+		}
+	}
+
+	if err := p.RebuildOrder(ctx, tenantID, os[0].ID); err != nil {
+		t.Fatalf("Expected order to be rebuilt %s", err)
+	}
+
+	o, err := p.OrderAt(ctx, os[0].ID.String(), time.Now())
+	if err != nil {
+		t.Fatalf("Expected order snapshot %s", err)
+	}
+
+	if o.ShippingAddress.Line1 != "1 Strand" {
+		t.Errorf("Expected the address to be resolved from its id, got %+v", o.ShippingAddress)
+	}
+}
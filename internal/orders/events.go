@@ -0,0 +1,315 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/events"
+	"github.com/ponty96/simple-web-app/internal/outbox"
+)
+
+// Payload of an order_created event. Addresses are recorded by id only, see
+// EventAddress.
+type OrderCreated struct {
+	UserID          string        `json:"user_id"`
+	TotalAmount     float64       `json:"total_amount"`
+	Status          string        `json:"status"`
+	ShippingAddress *EventAddress `json:"shipping_address"`
+	BillingAddress  *EventAddress `json:"billing_address"`
+	FraudScore      int32         `json:"fraud_score,omitempty"`
+	FraudReasons    []string      `json:"fraud_reasons,omitempty"`
+}
+
+// Payload of an item_added event
+type ItemAdded struct {
+	OrderItemID string  `json:"order_item_id"`
+	ProductID   string  `json:"product_id"`
+	Quantity    int32   `json:"quantity"`
+	Price       float64 `json:"price"`
+	TotalPrice  float64 `json:"total_price"`
+}
+
+// Payload of a status_changed event
+type StatusChanged struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Payload of an address_corrected event. Kind is either shipping or billing.
+type AddressCorrected struct {
+	Kind    string       `json:"kind"`
+	Address EventAddress `json:"address"`
+}
+
+// Represents an address as recorded in an event. Events only record the id of
+// the addresses row, so the personal data stays there, encrypted, and out of
+// order_events. Events recorded before then hold the whole address until
+// reencrypt-addresses strips it.
+type EventAddress struct {
+	ID         string  `json:"id"`
+	Line1      string  `json:"line1,omitempty"`
+	Line2      *string `json:"line2,omitempty"`
+	City       string  `json:"city,omitempty"`
+	State      string  `json:"state,omitempty"`
+	PostalCode string  `json:"postal_code,omitempty"`
+	Country    string  `json:"country,omitempty"`
+}
+
+// Order event payload keys holding an address
+var eventAddressKeys = []string{"shipping_address", "billing_address", "address"}
+
+// Represents a recorded change to an order as returned by the API
+type Event struct {
+	Version    int32           `json:"version"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// transitions lists the statuses an order can move to from a given status.
+// Cancelled and returned orders are final.
+var transitions = map[db.OrderStatus][]db.OrderStatus{
+	db.OrderStatusPending:           {db.OrderStatusShipped, db.OrderStatusCancelled, db.OrderStatusOnHold},
+	db.OrderStatusOnHold:            {db.OrderStatusPending, db.OrderStatusCancelled},
+	db.OrderStatusShipped:           {db.OrderStatusDelivered},
+	db.OrderStatusDelivered:         {db.OrderStatusPartiallyReturned, db.OrderStatusReturned},
+	db.OrderStatusPartiallyReturned: {db.OrderStatusReturned},
+}
+
+// updates lists the moves UpdateStatus, the status API, can make. Holds are
+// placed and released by fraud reviews and the return statuses are set by the
+// returns processor, so the API can't skip those workflows.
+var updates = map[db.OrderStatus][]db.OrderStatus{
+	db.OrderStatusPending: {db.OrderStatusShipped, db.OrderStatusCancelled},
+	db.OrderStatusShipped: {db.OrderStatusDelivered},
+}
+
+func canTransition(from, to db.OrderStatus) bool {
+	return contains(transitions[from], to)
+}
+
+func canUpdate(from, to db.OrderStatus) bool {
+	return contains(updates[from], to)
+}
+
+// updatable reports whether UpdateStatus can move an order to status at all
+func updatable(status db.OrderStatus) bool {
+	for _, to := range updates {
+		if contains(to, status) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(statuses []db.OrderStatus, status db.OrderStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// appendEvent records a change against the order's event stream. It must be
+// called with the same queries (transaction) that updates the projection.
+func appendEvent(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, orderID pgtype.UUID, t db.OrderEventType, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode order event")
+	}
+
+	if _, err := q.AppendOrderEvent(ctx, &db.AppendOrderEventParams{
+		OrderID:   orderID,
+		EventType: t,
+		Payload:   b,
+		TenantID:  tenantID,
+	}); err != nil {
+		return errors.Wrapf(err, "failed to append %s event", t)
+	}
+
+	return nil
+}
+
+// ChangeStatus moves the order to the given status, records the change and
+// enqueues an OrderStatusChanged message. Callers pass the queries of their
+// transaction so the projection, the event and the message are written
+// atomically. Setting the status the order already has is a no-op and moves
+// missing from the transitions table fail with ErrInvalidTransition.
+func ChangeStatus(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, orderID pgtype.UUID, status db.OrderStatus, reason string) (*db.Order, error) {
+	order, err := q.GetOrderForUpdate(ctx, &db.GetOrderForUpdateParams{ID: orderID, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch order")
+	}
+
+	if order.Status == status {
+		return order, nil
+	}
+
+	if !canTransition(order.Status, status) {
+		return nil, errors.Wrapf(ErrInvalidTransition, "cannot move order from %s to %s", order.Status, status)
+	}
+
+	updated, err := q.UpdateOrderStatus(ctx, &db.UpdateOrderStatusParams{ID: orderID, Status: status, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update order status")
+	}
+
+	if err := appendEvent(ctx, q, tenantID, orderID, db.OrderEventTypeStatusChanged, StatusChanged{
+		From:   string(order.Status),
+		To:     string(status),
+		Reason: reason,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := outbox.Enqueue(ctx, q, tenantID, orderID.String(), &events.OrderStatusChanged{
+		OrderId:    orderID.String(),
+		UserId:     order.UserID.String(),
+		FromStatus: string(order.Status),
+		ToStatus:   string(status),
+		Reason:     reason,
+		OccurredAt: timestamppb.Now(),
+	}); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func toEventAddress(a *db.Address) *EventAddress {
+	return &EventAddress{ID: a.ID.String()}
+}
+
+// inline reports whether the event recorded the address itself rather than
+// only its id.
+func (a *EventAddress) inline() bool {
+	return a.Line1 != ""
+}
+
+// orderState is an order folded from its events
+type orderState struct {
+	ID              pgtype.UUID
+	UserID          string
+	TotalAmount     float64
+	Status          string
+	ShippingAddress *EventAddress
+	BillingAddress  *EventAddress
+	FraudScore      int32
+	FraudReasons    []string
+	Items           []ItemAdded
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// fold replays the events of a single order, in version order, into its state.
+// Addresses recorded by id are left for the caller to resolve.
+func fold(stream []*db.OrderEvent) (*orderState, error) {
+	if len(stream) == 0 {
+		return nil, ErrNotFound
+	}
+
+	s := &orderState{ID: stream[0].OrderID}
+
+	for _, e := range stream {
+		switch e.EventType {
+		case db.OrderEventTypeOrderCreated:
+			var p OrderCreated
+			if err := json.Unmarshal(e.Payload, &p); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode event %d", e.Version)
+			}
+			s.UserID = p.UserID
+			s.TotalAmount = p.TotalAmount
+			s.Status = p.Status
+			s.ShippingAddress = p.ShippingAddress
+			s.BillingAddress = p.BillingAddress
+			s.FraudScore = p.FraudScore
+			s.FraudReasons = p.FraudReasons
+			s.CreatedAt = e.OccurredAt.Time
+		case db.OrderEventTypeItemAdded:
+			var p ItemAdded
+			if err := json.Unmarshal(e.Payload, &p); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode event %d", e.Version)
+			}
+			s.Items = append(s.Items, p)
+		case db.OrderEventTypeStatusChanged:
+			var p StatusChanged
+			if err := json.Unmarshal(e.Payload, &p); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode event %d", e.Version)
+			}
+			s.Status = p.To
+		case db.OrderEventTypeAddressCorrected:
+			var p AddressCorrected
+			if err := json.Unmarshal(e.Payload, &p); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode event %d", e.Version)
+			}
+			a := p.Address
+			if p.Kind == AddressKindBilling {
+				s.BillingAddress = &a
+			} else {
+				s.ShippingAddress = &a
+			}
+		default:
+			return nil, fmt.Errorf("unknown order event type: %s", e.EventType)
+		}
+		s.UpdatedAt = e.OccurredAt.Time
+	}
+
+	return s, nil
+}
+
+func (s *orderState) toOrder() Order {
+	id := s.ID.String()
+	userID := s.UserID
+	total := s.TotalAmount
+	status := s.Status
+
+	score := s.FraudScore
+
+	o := Order{
+		OrderID:      &id,
+		UserID:       &userID,
+		TotalAmount:  &total,
+		Status:       &status,
+		FraudScore:   &score,
+		FraudReasons: s.FraudReasons,
+	}
+	if s.ShippingAddress != nil {
+		o.ShippingAddress = s.ShippingAddress.toAddress()
+	}
+	if s.BillingAddress != nil {
+		o.BillingAddress = s.BillingAddress.toAddress()
+	}
+	for _, item := range s.Items {
+		o.Items = append(o.Items, OrderItem{
+			OrderItemID: item.OrderItemID,
+			ProductID:   item.ProductID,
+			Quantity:    item.Quantity,
+			Price:       item.Price,
+			TotalPrice:  item.TotalPrice,
+		})
+	}
+	return o
+}
+
+func (a *EventAddress) toAddress() Address {
+	return Address{
+		Line1:      a.Line1,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
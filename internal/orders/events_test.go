@@ -0,0 +1,161 @@
+package orders
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+func newEvent(t *testing.T, version int32, et db.OrderEventType, payload interface{}, at time.Time) *db.OrderEvent {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to encode payload %s", err)
+	}
+	return &db.OrderEvent{
+		OrderID:    pgtype.UUID{Bytes: [16]byte{1}, Valid: true},
+		Version:    version,
+		EventType:  et,
+		Payload:    b,
+		OccurredAt: pgtype.Timestamptz{Time: at, Valid: true},
+	}
+}
+
+func Test_FoldOrderEvents(t *testing.T) {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	events := []*db.OrderEvent{
+		newEvent(t, 1, db.OrderEventTypeOrderCreated, OrderCreated{
+			UserID:          "user-1",
+			TotalAmount:     21.98,
+			Status:          "pending",
+			ShippingAddress: &EventAddress{ID: "a-1", Line1: "123 Main St", City: "New York"},
+		}, created),
+		newEvent(t, 2, db.OrderEventTypeItemAdded, ItemAdded{OrderItemID: "i-1", ProductID: "p-1", Quantity: 2, Price: 10.99, TotalPrice: 21.98}, created),
+		newEvent(t, 3, db.OrderEventTypeStatusChanged, StatusChanged{From: "pending", To: "shipped"}, created.Add(24*time.Hour)),
+		newEvent(t, 4, db.OrderEventTypeAddressCorrected, AddressCorrected{
+			Kind:    AddressKindShipping,
+			Address: EventAddress{ID: "a-2"},
+		}, created.Add(48*time.Hour)),
+	}
+
+	s, err := fold(events)
+	if err != nil {
+		t.Fatalf("Expected events to fold %s", err)
+	}
+
+	if s.Status != "shipped" {
+		t.Errorf("Expected status to be shipped, got %s", s.Status)
+	}
+
+	if len(s.Items) != 1 || s.Items[0].OrderItemID != "i-1" {
+		t.Errorf("Expected one item i-1, got %+v", s.Items)
+	}
+
+	if s.ShippingAddress.ID != "a-2" || s.ShippingAddress.inline() {
+		t.Errorf("Expected corrected shipping address a-2 recorded by id, got %+v", s.ShippingAddress)
+	}
+
+	if !s.UpdatedAt.Equal(created.Add(48 * time.Hour)) {
+		t.Errorf("Expected updated at to be the last event time, got %v", s.UpdatedAt)
+	}
+
+	// replaying only the events up to the 2nd gives the order as it was then
+	s, err = fold(events[:3])
+	if err != nil {
+		t.Fatalf("Expected events to fold %s", err)
+	}
+
+	o := s.toOrder()
+	if o.ShippingAddress.City != "New York" {
+		t.Errorf("Expected the original shipping address, got %s", o.ShippingAddress.City)
+	}
+}
+
+func Test_EventAddressRecordsID(t *testing.T) {
+	line2 := "Flat 2"
+	a := &db.Address{
+		ID:         pgtype.UUID{Bytes: [16]byte{1}, Valid: true},
+		Line1:      "1 Strand",
+		Line2:      pgtype.Text{String: line2, Valid: true},
+		City:       "London",
+		PostalCode: "WC2N 5HR",
+	}
+
+	b, err := json.Marshal(AddressCorrected{Kind: AddressKindShipping, Address: *toEventAddress(a)})
+	if err != nil {
+		t.Fatalf("failed to encode payload %s", err)
+	}
+
+	want := `{"kind":"shipping","address":{"id":"` + a.ID.String() + `"}}`
+	if string(b) != want {
+		t.Errorf("Expected only the address id to be recorded, got %s", b)
+	}
+}
+
+func Test_FoldNoEvents(t *testing.T) {
+	if _, err := fold(nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func Test_CanTransition(t *testing.T) {
+	allowed := [][2]db.OrderStatus{
+		{db.OrderStatusPending, db.OrderStatusShipped},
+		{db.OrderStatusPending, db.OrderStatusCancelled},
+		{db.OrderStatusOnHold, db.OrderStatusPending},
+		{db.OrderStatusShipped, db.OrderStatusDelivered},
+		{db.OrderStatusDelivered, db.OrderStatusPartiallyReturned},
+		{db.OrderStatusPartiallyReturned, db.OrderStatusReturned},
+	}
+	for _, tr := range allowed {
+		if !canTransition(tr[0], tr[1]) {
+			t.Errorf("Expected %s -> %s to be allowed", tr[0], tr[1])
+		}
+	}
+
+	denied := [][2]db.OrderStatus{
+		{db.OrderStatusPending, db.OrderStatusDelivered},
+		{db.OrderStatusShipped, db.OrderStatusPending},
+		{db.OrderStatusDelivered, db.OrderStatusCancelled},
+		{db.OrderStatusCancelled, db.OrderStatusPending},
+		{db.OrderStatusReturned, db.OrderStatusDelivered},
+	}
+	for _, tr := range denied {
+		if canTransition(tr[0], tr[1]) {
+			t.Errorf("Expected %s -> %s to be denied", tr[0], tr[1])
+		}
+	}
+}
+
+func Test_CanUpdate(t *testing.T) {
+	allowed := [][2]db.OrderStatus{
+		{db.OrderStatusPending, db.OrderStatusShipped},
+		{db.OrderStatusPending, db.OrderStatusCancelled},
+		{db.OrderStatusShipped, db.OrderStatusDelivered},
+	}
+	for _, tr := range allowed {
+		if !canUpdate(tr[0], tr[1]) {
+			t.Errorf("Expected %s -> %s to be allowed", tr[0], tr[1])
+		}
+	}
+
+	// holds belong to fraud reviews and return statuses to returns
+	denied := [][2]db.OrderStatus{
+		{db.OrderStatusPending, db.OrderStatusOnHold},
+		{db.OrderStatusOnHold, db.OrderStatusPending},
+		{db.OrderStatusOnHold, db.OrderStatusCancelled},
+		{db.OrderStatusDelivered, db.OrderStatusPartiallyReturned},
+		{db.OrderStatusDelivered, db.OrderStatusReturned},
+		{db.OrderStatusPartiallyReturned, db.OrderStatusReturned},
+	}
+	for _, tr := range denied {
+		if canUpdate(tr[0], tr[1]) {
+			t.Errorf("Expected %s -> %s to be denied", tr[0], tr[1])
+		}
+	}
+}
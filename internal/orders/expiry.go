@@ -0,0 +1,97 @@
+package orders
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+// ExpiredReason is the reason recorded on orders cancelled for staying
+// pending longer than their client's TTL.
+const ExpiredReason = "expired"
+
+const expiryBatchSize = 100
+
+// ExpirePending cancels, for every client, the orders that have been pending
+// for longer than the client's TTL and returns how many were cancelled. Orders
+// locked by a request or another replica are skipped until the next run.
+func (p *processor) ExpirePending(ctx context.Context, defaultTTL time.Duration) (int, error) {
+	clients, err := p.queries.ListClients(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list clients")
+	}
+
+	n := 0
+	for _, c := range clients {
+		ttl := pendingTTL(c, defaultTTL)
+		if ttl <= 0 {
+			continue
+		}
+
+		for {
+			expired, err := p.expirePending(ctx, c.ID, time.Now().Add(-ttl))
+			n += expired
+			if err != nil {
+				return n, err
+			}
+			if expired < expiryBatchSize {
+				break
+			}
+		}
+	}
+
+	if n > 0 {
+		log.Infof("Cancelled %d expired orders", n)
+	}
+
+	return n, nil
+}
+
+// expirePending cancels a batch of the tenant's orders pending since before
+// pendingBefore. An order is pending since it was created or, when it was
+// moved back to pending (e.g. a released hold), since its last such move;
+// other changes to the order don't restart the clock.
+func (p *processor) expirePending(ctx context.Context, tenantID pgtype.UUID, pendingBefore time.Time) (int, error) {
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	stale, err := q.ListStalePendingOrders(ctx, &db.ListStalePendingOrdersParams{
+		TenantID:      tenantID,
+		PendingBefore: pgtype.Timestamptz{Time: pendingBefore, Valid: true},
+		BatchSize:     expiryBatchSize,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch stale orders")
+	}
+
+	for _, o := range stale {
+		if _, err := ChangeStatus(ctx, q, tenantID, o.ID, db.OrderStatusCancelled, ExpiredReason); err != nil {
+			return 0, errors.Wrapf(err, "failed to expire order %v", o.ID)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit expired orders")
+	}
+
+	return len(stale), nil
+}
+
+// pendingTTL returns the client's own TTL, or defaultTTL if it has none. A
+// TTL of 0 means the client's orders never expire.
+func pendingTTL(c *db.Client, defaultTTL time.Duration) time.Duration {
+	if !c.PendingOrderTtlSeconds.Valid {
+		return defaultTTL
+	}
+	return time.Duration(c.PendingOrderTtlSeconds.Int32) * time.Second
+}
@@ -0,0 +1,80 @@
+package orders
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/fraud"
+)
+
+func Test_PendingTTL(t *testing.T) {
+	cases := map[string]struct {
+		seconds pgtype.Int4
+		want    time.Duration
+	}{
+		"default":      {pgtype.Int4{}, 72 * time.Hour},
+		"client ttl":   {pgtype.Int4{Int32: 3600, Valid: true}, time.Hour},
+		"never expire": {pgtype.Int4{Int32: 0, Valid: true}, 0},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := pendingTTL(&db.Client{PendingOrderTtlSeconds: c.seconds}, 72*time.Hour)
+			if got != c.want {
+				t.Errorf("Expected %s, got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_ExpirePendingSince(t *testing.T) {
+	conn := SetupTestDb(t)
+	ctx := context.Background()
+	defer conn.Close(ctx)
+
+	p := NewProcessor(conn, fraud.New(fraud.DefaultConfig()), nil)
+	ctx, tenantID := newTenant(t, ctx, p)
+
+	userId := pgtype.UUID{Bytes: [16]byte{8}, Valid: true}
+	for i := 0; i < 2; i++ {
+		if err := p.NewOrder(ctx, &schemas.Order{UserId: userId.String(), OrderStatus: "pending", TotalAmount: 10}); err != nil {
+			t.Fatalf("Expected successfully created order %s", err)
+		}
+	}
+
+	orders, err := p.queries.ListOrders(ctx, &db.ListOrdersParams{UserID: userId, TenantID: tenantID})
+	if err != nil || len(orders) != 2 {
+		t.Fatalf("Expected two orders %s", err)
+	}
+	stale, released := orders[0], orders[1]
+
+	// both were created two hours ago and changed since
+	if _, err := conn.Exec(ctx, "UPDATE orders SET created_at = NOW() - INTERVAL '2 hours', updated_at = NOW() WHERE tenant_id = $1", tenantID); err != nil {
+		t.Fatalf("Failed to age orders %s", err)
+	}
+	// the second one was moved back to pending since
+	payload := []byte(`{"from": "on_hold", "to": "pending"}`)
+	if _, err := p.queries.AppendOrderEvent(ctx, &db.AppendOrderEventParams{OrderID: released.ID, EventType: db.OrderEventTypeStatusChanged, Payload: payload, TenantID: tenantID}); err != nil {
+		t.Fatalf("Failed to append event %s", err)
+	}
+
+	if _, err := p.ExpirePending(ctx, time.Hour); err != nil {
+		t.Fatalf("Expected pending orders to be expired %s", err)
+	}
+
+	want := map[pgtype.UUID]db.OrderStatus{stale.ID: db.OrderStatusCancelled, released.ID: db.OrderStatusPending}
+	for id, status := range want {
+		o, err := p.queries.GetOrder(ctx, &db.GetOrderParams{ID: id, TenantID: tenantID})
+		if err != nil {
+			t.Fatalf("Failed to fetch order %s", err)
+		}
+		if o.Status != status {
+			t.Errorf("Expected order %v to be %s, got %s", id, status, o.Status)
+		}
+	}
+}
@@ -9,29 +9,61 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/pkg/errors"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ponty96/my-proto-schemas/output/schemas"
 	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/encryption"
+	"github.com/ponty96/simple-web-app/internal/events"
+	"github.com/ponty96/simple-web-app/internal/fraud"
+	"github.com/ponty96/simple-web-app/internal/inbox"
+	"github.com/ponty96/simple-web-app/internal/outbox"
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+	"github.com/ponty96/simple-web-app/internal/tenant"
 )
 
+var (
+	ErrNotFound          = errors.New("order not found")
+	ErrInvalidStatus     = errors.New("invalid order status")
+	ErrInvalidTransition = errors.New("order cannot move to that status")
+	ErrInvalidKind       = errors.New("address kind must be shipping or billing")
+)
+
+const (
+	AddressKindShipping = "shipping"
+	AddressKindBilling  = "billing"
+)
+
+// Name NewOrder records the messages it handles under in the inbox
+const newOrderHandler = "orders.NewOrder"
+
 type Processor interface {
 	NewOrder(context.Context, proto.Message) error
 	ListUserOrders(context.Context, string) ([]Order, error)
+	UpdateStatus(context.Context, string, string, string) error
+	CorrectAddress(context.Context, string, string, Address) error
+	ListOrderEvents(context.Context, string) ([]Event, error)
+	OrderAt(context.Context, string, time.Time) (*Order, error)
 }
 
 type processor struct {
-	db      *pgx.Conn
+	db      db.Conn
 	queries *db.Queries
-	// publisher rabbitmq.MQ
+	fraud   *fraud.Engine
+	keys    *encryption.Keyring
 }
 
-func NewProcessor(d *pgx.Conn) *processor {
+// NewProcessor returns an order processor. Addresses are stored plaintext
+// when k is nil.
+func NewProcessor(d db.Conn, f *fraud.Engine, k *encryption.Keyring) *processor {
 	client := db.New(d)
 	return &processor{
 		db:      d,
 		queries: client,
+		fraud:   f,
+		keys:    k,
 	}
 }
 
@@ -44,14 +76,17 @@ type Order struct {
 	BillingAddress  Address     `json:"billing_address"`
 	TotalAmount     *float64    `json:"total_amount"`
 	Status          *string     `json:"status"`
+	FraudScore      *int32      `json:"fraud_score,omitempty"`
+	FraudReasons    []string    `json:"fraud_reasons,omitempty"`
 }
 
 // Represents a single item within an order
 type OrderItem struct {
-	ProductID  string  `json:"product_id"`
-	Quantity   int32   `json:"quantity"`
-	Price      float64 `json:"price"`
-	TotalPrice float64 `json:"total_price"`
+	OrderItemID string  `json:"order_item_id,omitempty"`
+	ProductID   string  `json:"product_id"`
+	Quantity    int32   `json:"quantity"`
+	Price       float64 `json:"price"`
+	TotalPrice  float64 `json:"total_price"`
 }
 
 // Represents an address used for shipping or billing
@@ -63,7 +98,19 @@ type Address struct {
 	Country    string `json:"country"`
 }
 
+// NewOrder creates the order msg describes. Database failures that may go away
+// on their own are returned as rabbitmq.ErrRetryable so the message is tried
+// again rather than dead-lettered; the transaction was rolled back and the
+// inbox lets the retry through.
 func (p *processor) NewOrder(ctx context.Context, msg proto.Message) error {
+	err := p.newOrder(ctx, msg)
+	if db.IsTransient(err) {
+		return errors.Wrap(rabbitmq.ErrRetryable, err.Error())
+	}
+	return err
+}
+
+func (p *processor) newOrder(ctx context.Context, msg proto.Message) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
@@ -73,34 +120,69 @@ func (p *processor) NewOrder(ctx context.Context, msg proto.Message) error {
 		return fmt.Errorf("unexpected message type: %T", msg)
 	}
 
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	// the order of a redelivered message was created already
+	first, err := inbox.Receive(ctx, q, tenantID, newOrderHandler)
+	if err != nil {
+		return err
+	}
+	if !first {
+		log.Infof("Skipping order of user %s, its message was handled already", o.UserId)
+		return nil
+	}
+
 	var shippingAddressID pgtype.UUID = pgtype.UUID{Valid: false}
 	var billingAddressID pgtype.UUID = pgtype.UUID{Valid: false}
 
+	created := OrderCreated{
+		UserID:      o.UserId,
+		TotalAmount: o.TotalAmount,
+		Status:      o.OrderStatus,
+	}
+
 	if o.ShippingAddress != nil && o.ShippingAddress.Street != "" {
-		sAdd, err := p.queries.CreateAddress(ctx, &db.CreateAddressParams{
-			Line1:   o.ShippingAddress.Street,
-			State:   o.ShippingAddress.State,
-			City:    o.ShippingAddress.City,
-			Country: "GB",
+		sAdd, err := p.createAddress(ctx, q, db.CreateAddressParams{
+			Line1:      o.ShippingAddress.Street,
+			State:      o.ShippingAddress.State,
+			City:       o.ShippingAddress.City,
+			PostalCode: o.ShippingAddress.Zip,
+			Country:    country(o.ShippingAddress),
+			TenantID:   tenantID,
 		})
 		if err != nil {
 			return errors.Wrap(err, "failed to insert shipping address")
 		}
 
 		shippingAddressID = sAdd.ID
+		created.ShippingAddress = toEventAddress(sAdd)
 	}
 
 	if o.BillingAddress != nil && o.BillingAddress.Street != "" {
-		bAdd, err := p.queries.CreateAddress(ctx, &db.CreateAddressParams{
-			Line1:   o.BillingAddress.Street,
-			State:   o.BillingAddress.State,
-			City:    o.BillingAddress.City,
-			Country: "GB",
+		bAdd, err := p.createAddress(ctx, q, db.CreateAddressParams{
+			Line1:      o.BillingAddress.Street,
+			State:      o.BillingAddress.State,
+			City:       o.BillingAddress.City,
+			PostalCode: o.BillingAddress.Zip,
+			Country:    country(o.BillingAddress),
+			TenantID:   tenantID,
 		})
 		if err != nil {
 			return errors.Wrap(err, "failed to insert billing address")
 		}
 		billingAddressID = bAdd.ID
+		created.BillingAddress = toEventAddress(bAdd)
 	}
 
 	var userUUID pgtype.UUID
@@ -113,18 +195,51 @@ func (p *processor) NewOrder(ctx context.Context, msg proto.Message) error {
 		return errors.Wrap(err, "failed to convert total amount to numeric")
 	}
 
-	insertedOrder, err := p.queries.CreateOrder(ctx, &db.CreateOrderParams{
+	score, err := p.score(ctx, q, tenantID, userUUID, o)
+	if err != nil {
+		return err
+	}
+
+	// orders that would be accepted into pending are held for review instead
+	status := db.OrderStatus(o.OrderStatus)
+	if score.Hold && status == db.OrderStatusPending {
+		status = db.OrderStatusOnHold
+		log.Infof("Holding order of user %s with fraud score %d: %v", o.UserId, score.Score, score.Reasons)
+	}
+
+	created.Status = string(status)
+	created.FraudScore = score.Score
+	created.FraudReasons = score.Reasons
+
+	insertedOrder, err := q.CreateOrder(ctx, &db.CreateOrderParams{
 		ShippingAddressID: shippingAddressID,
 		BillingAddressID:  billingAddressID,
 		UserID:            userUUID,
-		Status:            db.OrderStatus(o.OrderStatus),
+		Status:            status,
 		TotalAmount:       totalAmount,
+		TenantID:          tenantID,
+		FraudScore:        score.Score,
+		FraudReasons:      score.Reasons,
 	})
 
 	if err != nil {
 		return errors.Wrap(err, "failed to create order")
 	}
 
+	if err := appendEvent(ctx, q, tenantID, insertedOrder.ID, db.OrderEventTypeOrderCreated, created); err != nil {
+		return err
+	}
+
+	persisted := &events.OrderPersisted{
+		OrderId:      insertedOrder.ID.String(),
+		UserId:       o.UserId,
+		Status:       string(insertedOrder.Status),
+		TotalAmount:  o.TotalAmount,
+		OccurredAt:   timestamppb.Now(),
+		FraudScore:   score.Score,
+		FraudReasons: score.Reasons,
+	}
+
 	for _, item := range o.GetItems() {
 		var price pgtype.Numeric
 		if err := price.Scan(fmt.Sprintf("%.2f", item.Price)); err != nil {
@@ -141,17 +256,50 @@ func (p *processor) NewOrder(ctx context.Context, msg proto.Message) error {
 			return errors.Wrap(err, "failed to parse UUID")
 		}
 
-		_, err := p.queries.CreateOrderItem(ctx, &db.CreateOrderItemParams{
+		insertedItem, err := q.CreateOrderItem(ctx, &db.CreateOrderItemParams{
 			OrderID:    insertedOrder.ID,
 			Price:      price,
 			Quantity:   item.Quantity,
 			TotalPrice: tP,
 			ProductID:  productUUID,
+			TenantID:   tenantID,
 		})
 
 		if err != nil {
 			return errors.Wrap(err, "failed to create order item")
 		}
+
+		if err := appendEvent(ctx, q, tenantID, insertedOrder.ID, db.OrderEventTypeItemAdded, ItemAdded{
+			OrderItemID: insertedItem.ID.String(),
+			ProductID:   item.ProductId,
+			Quantity:    item.Quantity,
+			Price:       item.Price,
+			TotalPrice:  item.TotalPrice,
+		}); err != nil {
+			return err
+		}
+
+		persisted.Items = append(persisted.Items, &events.OrderItem{
+			OrderItemId: insertedItem.ID.String(),
+			ProductId:   item.ProductId,
+			Quantity:    item.Quantity,
+			Price:       item.Price,
+			TotalPrice:  item.TotalPrice,
+		})
+	}
+
+	if err := outbox.Enqueue(ctx, q, tenantID, insertedOrder.ID.String(), persisted); err != nil {
+		return err
+	}
+
+	if insertedOrder.Status == db.OrderStatusOnHold {
+		if err := openReview(ctx, q, insertedOrder); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit order")
 	}
 
 	log.Print("Successfully created an order")
@@ -159,6 +307,28 @@ func (p *processor) NewOrder(ctx context.Context, msg proto.Message) error {
 	return nil
 }
 
+// score runs the fraud rules against an incoming order. The user's order
+// counts are read in the same transaction the order is created in.
+func (p *processor) score(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, userID pgtype.UUID, o *schemas.Order) (fraud.Result, error) {
+	counts, err := q.GetUserOrderCounts(ctx, &db.GetUserOrderCountsParams{
+		UserID:    userID,
+		TenantID:  tenantID,
+		CreatedAt: pgtype.Timestamptz{Time: time.Now().Add(-p.fraud.Window), Valid: true},
+	})
+	if err != nil {
+		return fraud.Result{}, errors.Wrap(err, "failed to count user orders")
+	}
+
+	return p.fraud.Evaluate(fraud.Order{
+		UserID:          o.UserId,
+		TotalAmount:     o.TotalAmount,
+		ShippingCountry: o.GetShippingAddress().GetCountry(),
+		BillingCountry:  o.GetBillingAddress().GetCountry(),
+		PreviousOrders:  int(counts.TotalOrders),
+		RecentOrders:    int(counts.RecentOrders),
+	}), nil
+}
+
 func (p *processor) ListUserOrders(ctx context.Context, ID string) ([]Order, error) {
 	var userID pgtype.UUID
 
@@ -166,7 +336,21 @@ func (p *processor) ListUserOrders(ctx context.Context, ID string) ([]Order, err
 		return nil, errors.Wrap(err, "failed to parse UUID")
 	}
 
-	orders, err := p.queries.ListOrders(ctx, userID)
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// read-only, rolled back once done
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	orders, err := q.ListOrders(ctx, &db.ListOrdersParams{UserID: userID, TenantID: tenantID})
 
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch orders")
@@ -175,17 +359,17 @@ func (p *processor) ListUserOrders(ctx context.Context, ID string) ([]Order, err
 	var os []Order
 
 	for _, o := range orders {
-		shippingAddress, err := p.queries.GetAddress(ctx, o.ShippingAddressID)
+		shippingAddress, err := p.getAddress(ctx, q, tenantID, o.ShippingAddressID)
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("failed to fetch shipping address for %v", o.ID))
 		}
 
-		billingAddress, err := p.queries.GetAddress(ctx, o.BillingAddressID)
+		billingAddress, err := p.getAddress(ctx, q, tenantID, o.BillingAddressID)
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("failed to fetch billing address for %v", o.ID))
 		}
 
-		items, err := p.queries.ListOrderItems(ctx, o.ID)
+		items, err := q.ListOrderItems(ctx, &db.ListOrderItemsParams{OrderID: o.ID, TenantID: tenantID})
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("failed to fetch orders items for %v", o.ID))
 		}
@@ -197,10 +381,11 @@ func (p *processor) ListUserOrders(ctx context.Context, ID string) ([]Order, err
 			itemTotalPrice, _ := item.TotalPrice.Float64Value()
 
 			orderItems = append(orderItems, OrderItem{
-				ProductID:  item.ProductID.String(),
-				Price:      itemPrice.Float64,
-				TotalPrice: itemTotalPrice.Float64,
-				Quantity:   item.Quantity,
+				OrderItemID: item.ID.String(),
+				ProductID:   item.ProductID.String(),
+				Price:       itemPrice.Float64,
+				TotalPrice:  itemTotalPrice.Float64,
+				Quantity:    item.Quantity,
 			})
 		}
 
@@ -209,10 +394,12 @@ func (p *processor) ListUserOrders(ctx context.Context, ID string) ([]Order, err
 		tA, _ := o.TotalAmount.Float64Value()
 
 		os = append(os, Order{
-			OrderID:     &id,
-			TotalAmount: &tA.Float64,
-			Status:      (*string)(&o.Status),
-			UserID:      &userId,
+			OrderID:      &id,
+			TotalAmount:  &tA.Float64,
+			Status:       (*string)(&o.Status),
+			UserID:       &userId,
+			FraudScore:   &o.FraudScore,
+			FraudReasons: o.FraudReasons,
 			ShippingAddress: Address{
 				Line1:   shippingAddress.Line1,
 				City:    shippingAddress.City,
@@ -231,3 +418,213 @@ func (p *processor) ListUserOrders(ctx context.Context, ID string) ([]Order, err
 
 	return os, nil
 }
+
+// UpdateStatus moves an order to status on behalf of the API. Only the moves in
+// the updates table are allowed; the others belong to fraud reviews and returns
+// and fail with ErrInvalidTransition.
+func (p *processor) UpdateStatus(ctx context.Context, ID string, status string, reason string) error {
+	var orderID pgtype.UUID
+	if err := orderID.Scan(ID); err != nil {
+		return ErrNotFound
+	}
+
+	if !db.OrderStatus(status).Valid() {
+		return errors.Wrapf(ErrInvalidStatus, "%q", status)
+	}
+	if !updatable(db.OrderStatus(status)) {
+		return errors.Wrapf(ErrInvalidTransition, "cannot move order to %s", status)
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	order, err := q.GetOrderForUpdate(ctx, &db.GetOrderForUpdateParams{ID: orderID, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "failed to fetch order")
+	}
+	if order.Status != db.OrderStatus(status) && !canUpdate(order.Status, db.OrderStatus(status)) {
+		return errors.Wrapf(ErrInvalidTransition, "cannot move order from %s to %s", order.Status, status)
+	}
+
+	if _, err := ChangeStatus(ctx, q, tenantID, orderID, db.OrderStatus(status), reason); err != nil {
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(ctx), "failed to commit order status")
+}
+
+// CorrectAddress replaces the shipping or billing address of an order. A new
+// address row is created so earlier events keep pointing at the old one.
+func (p *processor) CorrectAddress(ctx context.Context, ID string, kind string, a Address) error {
+	var orderID pgtype.UUID
+	if err := orderID.Scan(ID); err != nil {
+		return ErrNotFound
+	}
+
+	if kind != AddressKindShipping && kind != AddressKindBilling {
+		return ErrInvalidKind
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	order, err := q.GetOrderForUpdate(ctx, &db.GetOrderForUpdateParams{ID: orderID, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "failed to fetch order")
+	}
+
+	address, err := p.createAddress(ctx, q, db.CreateAddressParams{
+		Line1:      a.Line1,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+		TenantID:   tenantID,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to insert %s address", kind)
+	}
+
+	shippingAddressID, billingAddressID := order.ShippingAddressID, order.BillingAddressID
+	if kind == AddressKindShipping {
+		shippingAddressID = address.ID
+	} else {
+		billingAddressID = address.ID
+	}
+
+	if _, err := q.UpdateOrderAddresses(ctx, &db.UpdateOrderAddressesParams{
+		ID:                orderID,
+		ShippingAddressID: shippingAddressID,
+		BillingAddressID:  billingAddressID,
+		TenantID:          tenantID,
+	}); err != nil {
+		return errors.Wrap(err, "failed to update order addresses")
+	}
+
+	if err := appendEvent(ctx, q, tenantID, orderID, db.OrderEventTypeAddressCorrected, AddressCorrected{
+		Kind:    kind,
+		Address: *toEventAddress(address),
+	}); err != nil {
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(ctx), "failed to commit address correction")
+}
+
+func (p *processor) ListOrderEvents(ctx context.Context, ID string) ([]Event, error) {
+	var orderID pgtype.UUID
+	if err := orderID.Scan(ID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	stream, err := p.queries.WithTx(tx).ListOrderEvents(ctx, &db.ListOrderEventsParams{OrderID: orderID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order events")
+	}
+
+	if len(stream) == 0 {
+		return nil, ErrNotFound
+	}
+
+	es := []Event{}
+	for _, e := range stream {
+		es = append(es, Event{
+			Version:    e.Version,
+			Type:       string(e.EventType),
+			Payload:    e.Payload,
+			OccurredAt: e.OccurredAt.Time,
+		})
+	}
+
+	return es, nil
+}
+
+// OrderAt reconstructs the order as it was at the given time by replaying the
+// events that had occurred by then.
+func (p *processor) OrderAt(ctx context.Context, ID string, at time.Time) (*Order, error) {
+	var orderID pgtype.UUID
+	if err := orderID.Scan(ID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	stream, err := p.queries.WithTx(tx).ListOrderEventsUntil(ctx, &db.ListOrderEventsUntilParams{
+		OrderID:    orderID,
+		TenantID:   tenantID,
+		OccurredAt: pgtype.Timestamptz{Time: at, Valid: true},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order events")
+	}
+
+	state, err := fold(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	q := p.queries.WithTx(tx)
+	if err := p.resolveAddress(ctx, q, tenantID, state.ShippingAddress); err != nil {
+		return nil, err
+	}
+	if err := p.resolveAddress(ctx, q, tenantID, state.BillingAddress); err != nil {
+		return nil, err
+	}
+
+	o := state.toOrder()
+	return &o, nil
+}
+
+// country of the address, defaulting to GB for senders that don't set one
+func country(a *schemas.Address) string {
+	if a.Country == "" {
+		return "GB"
+	}
+	return a.Country
+}
@@ -0,0 +1,154 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+// RebuildProjections replays the event stream of every order of every client
+// and upserts the orders, order_items and addresses rows from the result. Each
+// order is rebuilt in its own transaction; the number of rebuilt orders is
+// returned.
+func (p *processor) RebuildProjections(ctx context.Context) (int, error) {
+	clients, err := p.queries.ListClients(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list clients")
+	}
+
+	n := 0
+	for _, c := range clients {
+		ids, err := p.listEventSourcedOrderIDs(ctx, c.ID)
+		if err != nil {
+			return n, err
+		}
+
+		for _, id := range ids {
+			if err := p.RebuildOrder(ctx, c.ID, id); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	log.Infof("Rebuilt %d orders from their events", n)
+
+	return n, nil
+}
+
+func (p *processor) listEventSourcedOrderIDs(ctx context.Context, tenantID pgtype.UUID) ([]pgtype.UUID, error) {
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := p.queries.WithTx(tx).ListEventSourcedOrderIDs(ctx, tenantID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list orders of client %v", tenantID)
+	}
+
+	return ids, nil
+}
+
+// RebuildOrder replays the events of a single order into its projection rows.
+func (p *processor) RebuildOrder(ctx context.Context, tenantID pgtype.UUID, orderID pgtype.UUID) error {
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	stream, err := q.ListOrderEvents(ctx, &db.ListOrderEventsParams{OrderID: orderID, TenantID: tenantID})
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch events for %v", orderID)
+	}
+
+	s, err := fold(stream)
+	if err != nil {
+		return errors.Wrapf(err, "failed to replay events for %v", orderID)
+	}
+
+	shippingAddressID, err := p.upsertAddress(ctx, q, tenantID, s.ShippingAddress, s.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	billingAddressID, err := p.upsertAddress(ctx, q, tenantID, s.BillingAddress, s.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	var userID pgtype.UUID
+	if err := userID.Scan(s.UserID); err != nil {
+		return errors.Wrap(err, "failed to parse UUID")
+	}
+
+	var totalAmount pgtype.Numeric
+	if err := totalAmount.Scan(fmt.Sprintf("%.2f", s.TotalAmount)); err != nil {
+		return errors.Wrap(err, "failed to convert total amount to numeric")
+	}
+
+	// events recorded before fraud scoring have no reasons; the column is NOT NULL
+	fraudReasons := s.FraudReasons
+	if fraudReasons == nil {
+		fraudReasons = []string{}
+	}
+
+	if err := q.UpsertOrder(ctx, &db.UpsertOrderParams{
+		ID:                orderID,
+		UserID:            userID,
+		TotalAmount:       totalAmount,
+		Status:            db.OrderStatus(s.Status),
+		ShippingAddressID: shippingAddressID,
+		BillingAddressID:  billingAddressID,
+		CreatedAt:         pgtype.Timestamptz{Time: s.CreatedAt, Valid: true},
+		UpdatedAt:         pgtype.Timestamptz{Time: s.UpdatedAt, Valid: true},
+		TenantID:          tenantID,
+		FraudScore:        s.FraudScore,
+		FraudReasons:      fraudReasons,
+	}); err != nil {
+		return errors.Wrapf(err, "failed to upsert order %v", orderID)
+	}
+
+	for _, item := range s.Items {
+		var itemID, productID pgtype.UUID
+		if err := itemID.Scan(item.OrderItemID); err != nil {
+			return errors.Wrap(err, "failed to parse UUID")
+		}
+		if err := productID.Scan(item.ProductID); err != nil {
+			return errors.Wrap(err, "failed to parse UUID")
+		}
+
+		var price, totalPrice pgtype.Numeric
+		if err := price.Scan(fmt.Sprintf("%.2f", item.Price)); err != nil {
+			return errors.Wrap(err, "failed to convert price to numeric")
+		}
+		if err := totalPrice.Scan(fmt.Sprintf("%.2f", item.TotalPrice)); err != nil {
+			return errors.Wrap(err, "failed to convert total price to numeric")
+		}
+
+		if err := q.UpsertOrderItem(ctx, &db.UpsertOrderItemParams{
+			ID:         itemID,
+			OrderID:    orderID,
+			ProductID:  productID,
+			Quantity:   item.Quantity,
+			Price:      price,
+			TotalPrice: totalPrice,
+			CreatedAt:  pgtype.Timestamptz{Time: s.CreatedAt, Valid: true},
+			TenantID:   tenantID,
+		}); err != nil {
+			return errors.Wrapf(err, "failed to upsert order item %s", item.OrderItemID)
+		}
+	}
+
+	return errors.Wrap(tx.Commit(ctx), "failed to commit rebuilt order")
+}
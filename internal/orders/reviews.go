@@ -0,0 +1,369 @@
+package orders
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/events"
+	"github.com/ponty96/simple-web-app/internal/outbox"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+var (
+	ErrReviewNotFound = errors.New("review not found")
+	ErrReviewDecided  = errors.New("review has already been decided")
+)
+
+// Reviews is the manual review queue of orders held by the fraud rules.
+type Reviews interface {
+	ListReviews(context.Context) ([]Review, error)
+	GetReview(context.Context, string) (*Review, error)
+	AssignReview(context.Context, string, string) (*Review, error)
+	ApproveReview(context.Context, string, Decision) (*Review, error)
+	RejectReview(context.Context, string, Decision) (*Review, error)
+}
+
+type reviews struct {
+	db      db.Conn
+	queries *db.Queries
+}
+
+func NewReviews(d db.Conn) *reviews {
+	return &reviews{
+		db:      d,
+		queries: db.New(d),
+	}
+}
+
+// Represents the manual review of a held order
+type Review struct {
+	ReviewID       string     `json:"review_id"`
+	OrderID        string     `json:"order_id"`
+	UserID         string     `json:"user_id"`
+	Status         string     `json:"status"`
+	OrderStatus    string     `json:"order_status"`
+	TotalAmount    float64    `json:"total_amount"`
+	FraudScore     int32      `json:"fraud_score"`
+	FraudReasons   []string   `json:"fraud_reasons"`
+	Assignee       *string    `json:"assignee,omitempty"`
+	DecidedBy      *string    `json:"decided_by,omitempty"`
+	DecisionReason *string    `json:"decision_reason,omitempty"`
+	EscalatedAt    *time.Time `json:"escalated_at,omitempty"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Represents a reviewer's decision on a held order
+type Decision struct {
+	Reviewer string `json:"reviewer"`
+	Reason   string `json:"reason"`
+}
+
+// ListReviews returns the open and escalated reviews, oldest first.
+func (r *reviews) ListReviews(ctx context.Context) ([]Review, error) {
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := r.queries.WithTx(tx)
+
+	rvs, err := q.ListUndecidedOrderReviews(ctx, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch reviews")
+	}
+
+	out := []Review{}
+	for _, rv := range rvs {
+		order, err := q.GetOrder(ctx, &db.GetOrderParams{ID: rv.OrderID, TenantID: tenantID})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch order %v", rv.OrderID)
+		}
+		out = append(out, toReview(rv, order))
+	}
+
+	return out, nil
+}
+
+func (r *reviews) GetReview(ctx context.Context, ID string) (*Review, error) {
+	var id pgtype.UUID
+	if err := id.Scan(ID); err != nil {
+		return nil, ErrReviewNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := r.queries.WithTx(tx)
+
+	rv, err := q.GetOrderReview(ctx, &db.GetOrderReviewParams{ID: id, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReviewNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch review")
+	}
+
+	order, err := q.GetOrder(ctx, &db.GetOrderParams{ID: rv.OrderID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order")
+	}
+
+	out := toReview(rv, order)
+	return &out, nil
+}
+
+func (r *reviews) AssignReview(ctx context.Context, ID string, assignee string) (*Review, error) {
+	return r.update(ctx, ID, "assigned", func(q *db.Queries, rv *db.OrderReview, order *db.Order) (*db.OrderReview, error) {
+		return q.AssignOrderReview(ctx, &db.AssignOrderReviewParams{
+			ID:       rv.ID,
+			Assignee: pgtype.Text{String: assignee, Valid: true},
+			TenantID: rv.TenantID,
+		})
+	})
+}
+
+// ApproveReview releases the held order into pending.
+func (r *reviews) ApproveReview(ctx context.Context, ID string, d Decision) (*Review, error) {
+	return r.decide(ctx, ID, db.ReviewStatusApproved, db.OrderStatusPending, d)
+}
+
+// RejectReview cancels the held order with the decision's reason.
+func (r *reviews) RejectReview(ctx context.Context, ID string, d Decision) (*Review, error) {
+	return r.decide(ctx, ID, db.ReviewStatusRejected, db.OrderStatusCancelled, d)
+}
+
+func (r *reviews) decide(ctx context.Context, ID string, status db.ReviewStatus, orderStatus db.OrderStatus, d Decision) (*Review, error) {
+	return r.update(ctx, ID, string(status), func(q *db.Queries, rv *db.OrderReview, order *db.Order) (*db.OrderReview, error) {
+		if order.Status != db.OrderStatusOnHold {
+			return nil, errors.Wrapf(ErrInvalidStatus, "order is %s, not on_hold", order.Status)
+		}
+
+		reason := d.Reason
+		if reason == "" {
+			reason = "review " + string(status)
+		}
+
+		if _, err := ChangeStatus(ctx, q, rv.TenantID, rv.OrderID, orderStatus, reason); err != nil {
+			return nil, err
+		}
+
+		return q.DecideOrderReview(ctx, &db.DecideOrderReviewParams{
+			ID:             rv.ID,
+			Status:         status,
+			DecidedBy:      pgtype.Text{String: d.Reviewer, Valid: d.Reviewer != ""},
+			DecisionReason: pgtype.Text{String: d.Reason, Valid: d.Reason != ""},
+			TenantID:       rv.TenantID,
+		})
+	})
+}
+
+// update locks an undecided review, applies the change and enqueues an event
+// for it in a single transaction.
+func (r *reviews) update(ctx context.Context, ID string, eventType string, apply func(*db.Queries, *db.OrderReview, *db.Order) (*db.OrderReview, error)) (*Review, error) {
+	var id pgtype.UUID
+	if err := id.Scan(ID); err != nil {
+		return nil, ErrReviewNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, r.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := r.queries.WithTx(tx)
+
+	rv, err := q.GetOrderReviewForUpdate(ctx, &db.GetOrderReviewForUpdateParams{ID: id, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReviewNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch review")
+	}
+
+	if rv.Status == db.ReviewStatusApproved || rv.Status == db.ReviewStatusRejected {
+		return nil, errors.Wrapf(ErrReviewDecided, "review is %s", rv.Status)
+	}
+
+	order, err := q.GetOrder(ctx, &db.GetOrderParams{ID: rv.OrderID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order")
+	}
+
+	updated, err := apply(q, rv, order)
+	if err != nil {
+		return nil, err
+	}
+
+	// the order may have moved on by now
+	order, err = q.GetOrder(ctx, &db.GetOrderParams{ID: rv.OrderID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order")
+	}
+
+	if err := outbox.Enqueue(ctx, q, tenantID, order.ID.String(), reviewEvent(eventType, updated, order)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to commit review")
+	}
+
+	out := toReview(updated, order)
+	return &out, nil
+}
+
+// EscalateOverdue escalates the reviews of every client that have been open
+// for longer than timeout and returns how many were escalated. Reviews locked
+// by a reviewer or another replica are skipped until the next run.
+func (r *reviews) EscalateOverdue(ctx context.Context, timeout time.Duration) (int, error) {
+	clients, err := r.queries.ListClients(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list clients")
+	}
+
+	n := 0
+	for _, c := range clients {
+		escalated, err := r.escalateOverdue(ctx, c.ID, time.Now().Add(-timeout))
+		n += escalated
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n > 0 {
+		log.Infof("Escalated %d overdue reviews", n)
+	}
+
+	return n, nil
+}
+
+func (r *reviews) escalateOverdue(ctx context.Context, tenantID pgtype.UUID, openedBefore time.Time) (int, error) {
+	tx, err := db.BeginTenant(ctx, r.db, tenantID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := r.queries.WithTx(tx)
+
+	rvs, err := q.ListOverdueOrderReviews(ctx, &db.ListOverdueOrderReviewsParams{
+		TenantID:  tenantID,
+		CreatedAt: pgtype.Timestamptz{Time: openedBefore, Valid: true},
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch overdue reviews")
+	}
+
+	for _, rv := range rvs {
+		updated, err := q.EscalateOrderReview(ctx, &db.EscalateOrderReviewParams{ID: rv.ID, TenantID: tenantID})
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to escalate review %v", rv.ID)
+		}
+
+		order, err := q.GetOrder(ctx, &db.GetOrderParams{ID: rv.OrderID, TenantID: tenantID})
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to fetch order")
+		}
+
+		if err := outbox.Enqueue(ctx, q, tenantID, order.ID.String(), reviewEvent("escalated", updated, order)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit escalated reviews")
+	}
+
+	return len(rvs), nil
+}
+
+// openReview queues a held order for review. It is called with the queries of
+// the transaction that creates the order.
+func openReview(ctx context.Context, q *db.Queries, order *db.Order) error {
+	rv, err := q.CreateOrderReview(ctx, &db.CreateOrderReviewParams{OrderID: order.ID, TenantID: order.TenantID})
+	if err != nil {
+		return errors.Wrap(err, "failed to open review")
+	}
+
+	return outbox.Enqueue(ctx, q, order.TenantID, order.ID.String(), reviewEvent("opened", rv, order))
+}
+
+// reviewEvent builds the message enqueued on every change to a review.
+func reviewEvent(eventType string, rv *db.OrderReview, order *db.Order) *events.OrderReviewEvent {
+	return &events.OrderReviewEvent{
+		EventType:      eventType,
+		ReviewId:       rv.ID.String(),
+		OrderId:        rv.OrderID.String(),
+		UserId:         order.UserID.String(),
+		ReviewStatus:   string(rv.Status),
+		OrderStatus:    string(order.Status),
+		Assignee:       rv.Assignee.String,
+		DecidedBy:      rv.DecidedBy.String,
+		DecisionReason: rv.DecisionReason.String,
+		FraudScore:     order.FraudScore,
+		FraudReasons:   order.FraudReasons,
+		OccurredAt:     timestamppb.Now(),
+	}
+}
+
+func toReview(rv *db.OrderReview, order *db.Order) Review {
+	total, _ := order.TotalAmount.Float64Value()
+
+	r := Review{
+		ReviewID:     rv.ID.String(),
+		OrderID:      rv.OrderID.String(),
+		UserID:       order.UserID.String(),
+		Status:       string(rv.Status),
+		OrderStatus:  string(order.Status),
+		TotalAmount:  total.Float64,
+		FraudScore:   order.FraudScore,
+		FraudReasons: order.FraudReasons,
+		CreatedAt:    rv.CreatedAt.Time,
+	}
+	if rv.Assignee.Valid {
+		r.Assignee = &rv.Assignee.String
+	}
+	if rv.DecidedBy.Valid {
+		r.DecidedBy = &rv.DecidedBy.String
+	}
+	if rv.DecisionReason.Valid {
+		r.DecisionReason = &rv.DecisionReason.String
+	}
+	if rv.EscalatedAt.Valid {
+		r.EscalatedAt = &rv.EscalatedAt.Time
+	}
+	if rv.DecidedAt.Valid {
+		r.DecidedAt = &rv.DecidedAt.Time
+	}
+
+	return r
+}
@@ -0,0 +1,234 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+// Enqueue writes msg to the outbox. Callers pass the queries of the transaction
+// that persists the change the message describes, so the message exists if and
+// only if the change was committed. The tenant is published as a message header.
+// Messages of the same aggregate, usually the order they describe, are relayed
+// in the order they were enqueued.
+func Enqueue(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, aggregateID string, msg proto.Message) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode outbox message")
+	}
+
+	if _, err := q.EnqueueOutboxMessage(ctx, &db.EnqueueOutboxMessageParams{
+		MessageType: string(msg.ProtoReflect().Descriptor().FullName()),
+		Payload:     b,
+		TenantID:    tenantID,
+		AggregateID: aggregateID,
+	}); err != nil {
+		return errors.Wrap(err, "failed to enqueue outbox message")
+	}
+
+	return nil
+}
+
+type Config struct {
+	// Maximum number of messages relayed per batch
+	BatchSize int32
+	// Number of failed publishes after which a message is parked
+	MaxAttempts int32
+}
+
+// Relay publishes outbox messages in order per aggregate and marks them sent.
+// A message is only marked sent after the broker accepted it, so delivery is
+// at-least-once: a crash between the two republishes the message on the next
+// poll. A message that keeps failing is parked after MaxAttempts so it stops
+// holding back the rest of its aggregate.
+type Relay struct {
+	db        db.Conn
+	queries   *db.Queries
+	publisher rabbitmq.MQ
+	cfg       Config
+}
+
+func NewRelay(d db.Conn, publisher rabbitmq.MQ, cfg Config) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+
+	return &Relay{
+		db:        d,
+		queries:   db.New(d),
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// Run drains the outbox every interval until ctx is done. It runs on its own
+// rather than as a scheduled job so polling doesn't record a run every tick.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Drain(ctx); err != nil && ctx.Err() == nil {
+			log.Errorf("outbox: failed to relay messages: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain relays batches until the outbox is empty and returns how many
+// messages were sent.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		n, err := r.RelayBatch(ctx)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		// keep draining while full batches are coming back
+		if n < int(r.cfg.BatchSize) {
+			return sent, nil
+		}
+	}
+}
+
+// RelayBatch publishes the next batch of unsent messages and returns how many
+// were sent. Only one replica relays at a time, the others find the outbox
+// lock taken and return straight away, so an aggregate's messages can't be
+// published out of order by two replicas. A failed message
+// holds back the later messages of its aggregate for the rest of the batch;
+// other aggregates carry on. The first publish error is returned.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := r.queries.WithTx(tx)
+
+	// held until the batch's transaction ends
+	locked, err := q.TryOutboxLock(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to acquire outbox lock")
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	msgs, err := q.ListUnsentOutboxMessages(ctx, r.cfg.BatchSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch outbox messages")
+	}
+
+	sent := 0
+	var publishErr error
+	blocked := make(map[string]bool)
+	for _, m := range msgs {
+		// keep the messages of an aggregate in order
+		if blocked[m.AggregateID] {
+			continue
+		}
+
+		if err := r.publish(ctx, m); err != nil {
+			if publishErr == nil {
+				publishErr = err
+			}
+			if err := r.fail(ctx, q, m, err); err != nil {
+				return 0, err
+			}
+			if m.Attempts+1 < r.cfg.MaxAttempts {
+				blocked[m.AggregateID] = true
+			}
+			continue
+		}
+
+		if err := q.MarkOutboxMessageSent(ctx, m.ID); err != nil {
+			return 0, errors.Wrap(err, "failed to mark outbox message sent")
+		}
+		sent++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit outbox batch")
+	}
+
+	if publishErr != nil {
+		return sent, publishErr
+	}
+
+	return sent, nil
+}
+
+// fail records a failed publish, parking the message once it has used up its
+// attempts.
+func (r *Relay) fail(ctx context.Context, q *db.Queries, m *db.OutboxMessage, publishErr error) error {
+	lastError := pgtype.Text{String: publishErr.Error(), Valid: true}
+
+	if m.Attempts+1 >= r.cfg.MaxAttempts {
+		log.Errorf("Parking outbox message %d after %d attempts: %v", m.ID, m.Attempts+1, publishErr)
+		return errors.Wrap(q.ParkOutboxMessage(ctx, &db.ParkOutboxMessageParams{
+			ID:        m.ID,
+			LastError: lastError,
+		}), "failed to park outbox message")
+	}
+
+	return errors.Wrap(q.RecordOutboxMessageFailure(ctx, &db.RecordOutboxMessageFailureParams{
+		ID:        m.ID,
+		LastError: lastError,
+	}), "failed to record outbox failure")
+}
+
+func (r *Relay) publish(ctx context.Context, m *db.OutboxMessage) error {
+	msg, err := Decode(m.MessageType, m.Payload)
+	if err != nil {
+		return err
+	}
+
+	if m.TenantID.Valid {
+		ctx = tenant.NewContext(ctx, m.TenantID)
+	}
+
+	// a row published again, e.g. when marking it sent failed, keeps its id so
+	// consumers handle it once
+	if err := r.publisher.Publish(ctx, msg, rabbitmq.MessageID(fmt.Sprintf("outbox:%d", m.ID))); err != nil {
+		return errors.Wrapf(err, "failed to publish outbox message %d", m.ID)
+	}
+
+	return nil
+}
+
+// Decode looks up the message type in the proto registry and decodes payload
+// into a new message of that type.
+func Decode(messageType string, payload []byte) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(messageType))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown outbox message type %s", messageType)
+	}
+
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", messageType)
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,560 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/orders"
+	"github.com/ponty96/simple-web-app/internal/outbox"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready")
+)
+
+// Number of exports generated per client and transaction
+const exportBatchSize = 10
+
+// Represents who asked for a data export
+type ExportRequest struct {
+	RequestedBy string `json:"requested_by"`
+}
+
+// Represents a subject access request. The archive itself is downloaded
+// separately once the export is completed.
+type Export struct {
+	ExportID    string     `json:"export_id"`
+	UserID      string     `json:"user_id"`
+	RequestedBy string     `json:"requested_by"`
+	Status      string     `json:"status"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// Represents everything held for a user
+type Archive struct {
+	UserID        string                `json:"user_id"`
+	GeneratedAt   time.Time             `json:"generated_at"`
+	Orders        []ArchiveOrder        `json:"orders"`
+	Erasures      []Erasure             `json:"erasures"`
+	Notifications []ArchiveNotification `json:"notifications"`
+}
+
+// Represents an order in an archive
+type ArchiveOrder struct {
+	OrderID         string          `json:"order_id"`
+	Status          string          `json:"status"`
+	TotalAmount     float64         `json:"total_amount"`
+	FraudScore      int32           `json:"fraud_score"`
+	FraudReasons    []string        `json:"fraud_reasons"`
+	ShippingAddress *ArchiveAddress `json:"shipping_address"`
+	BillingAddress  *ArchiveAddress `json:"billing_address"`
+	Items           []ArchiveItem   `json:"items"`
+	StatusHistory   []StatusChange  `json:"status_history"`
+	Returns         []ArchiveReturn `json:"returns"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// Represents a message sent about the user or one of their orders, from the
+// outbox. SentAt is nil while the message hasn't been relayed yet.
+type ArchiveNotification struct {
+	MessageType string          `json:"message_type"`
+	Message     json.RawMessage `json:"message"`
+	CreatedAt   time.Time       `json:"created_at"`
+	SentAt      *time.Time      `json:"sent_at,omitempty"`
+}
+
+// Represents an address in an archive
+type ArchiveAddress struct {
+	Line1      string  `json:"line1"`
+	Line2      *string `json:"line2"`
+	City       string  `json:"city"`
+	State      string  `json:"state"`
+	PostalCode string  `json:"postal_code"`
+	Country    string  `json:"country"`
+}
+
+// Represents an order item in an archive
+type ArchiveItem struct {
+	OrderItemID string  `json:"order_item_id"`
+	ProductID   string  `json:"product_id"`
+	Quantity    int32   `json:"quantity"`
+	Price       float64 `json:"price"`
+	TotalPrice  float64 `json:"total_price"`
+}
+
+// Represents a status the order moved to. From is empty for the status the
+// order was created with.
+type StatusChange struct {
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to"`
+	Reason     string    `json:"reason,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Represents a return in an archive
+type ArchiveReturn struct {
+	ReturnID        string              `json:"return_id"`
+	Status          string              `json:"status"`
+	Reason          string              `json:"reason"`
+	RejectionReason *string             `json:"rejection_reason,omitempty"`
+	RefundAmount    float64             `json:"refund_amount"`
+	Items           []ArchiveReturnItem `json:"items"`
+	CreatedAt       time.Time           `json:"created_at"`
+}
+
+// Represents a returned item in an archive
+type ArchiveReturnItem struct {
+	OrderItemID      string  `json:"order_item_id"`
+	Quantity         int32   `json:"quantity"`
+	AcceptedQuantity *int32  `json:"accepted_quantity,omitempty"`
+	RefundAmount     float64 `json:"refund_amount"`
+}
+
+// RequestExport queues the generation of the user's archive. It is generated
+// by ProcessExports; poll GetExport until it's completed.
+func (p *processor) RequestExport(ctx context.Context, ID string, req ExportRequest) (*Export, error) {
+	var userID pgtype.UUID
+	if err := userID.Scan(ID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	e, err := p.queries.WithTx(tx).CreateDataExport(ctx, &db.CreateDataExportParams{
+		TenantID:    tenantID,
+		UserID:      userID,
+		RequestedBy: req.RequestedBy,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create export")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to commit export")
+	}
+
+	out := toExport(e)
+	return &out, nil
+}
+
+func (p *processor) GetExport(ctx context.Context, ID string) (*Export, error) {
+	e, err := p.getExport(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := toExport(e)
+	return &out, nil
+}
+
+// DownloadExport returns the JSON archive of a completed export.
+func (p *processor) DownloadExport(ctx context.Context, ID string) ([]byte, error) {
+	e, err := p.getExport(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Status != db.DataExportStatusCompleted || e.Archive == nil {
+		return nil, errors.Wrapf(ErrExportNotReady, "export is %s", e.Status)
+	}
+
+	return e.Archive, nil
+}
+
+func (p *processor) getExport(ctx context.Context, ID string) (*db.DataExport, error) {
+	var exportID pgtype.UUID
+	if err := exportID.Scan(ID); err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// read-only, rolled back once done
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	e, err := p.queries.WithTx(tx).GetDataExport(ctx, &db.GetDataExportParams{ID: exportID, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrExportNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch export")
+	}
+
+	return e, nil
+}
+
+// ProcessExports generates the pending exports of every client and deletes
+// the archives past their retention. It returns how many exports were
+// generated. Exports locked by another replica are skipped until the next run.
+func (p *processor) ProcessExports(ctx context.Context, retention time.Duration) (int, error) {
+	clients, err := p.queries.ListClients(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list clients")
+	}
+
+	n := 0
+	for _, c := range clients {
+		for {
+			generated, err := p.processExports(ctx, c.ID, retention)
+			n += generated
+			if err != nil {
+				return n, err
+			}
+			if generated < exportBatchSize {
+				break
+			}
+		}
+	}
+
+	if n > 0 {
+		log.Infof("Generated %d data exports", n)
+	}
+
+	return n, nil
+}
+
+func (p *processor) processExports(ctx context.Context, tenantID pgtype.UUID, retention time.Duration) (int, error) {
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	if _, err := q.DeleteExpiredDataExports(ctx, &db.DeleteExpiredDataExportsParams{
+		TenantID:  tenantID,
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}); err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired exports")
+	}
+
+	pending, err := q.ListPendingDataExports(ctx, &db.ListPendingDataExportsParams{TenantID: tenantID, Limit: exportBatchSize})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch pending exports")
+	}
+
+	for _, e := range pending {
+		archive, err := p.archive(ctx, tx, tenantID, e.UserID)
+		if err != nil {
+			log.Errorf("privacy: failed to generate export %v: %v", e.ID, err)
+			if _, err := q.FailDataExport(ctx, &db.FailDataExportParams{
+				ID:       e.ID,
+				Error:    pgtype.Text{String: err.Error(), Valid: true},
+				TenantID: tenantID,
+			}); err != nil {
+				return 0, errors.Wrapf(err, "failed to mark export %v failed", e.ID)
+			}
+			continue
+		}
+
+		if _, err := q.CompleteDataExport(ctx, &db.CompleteDataExportParams{
+			ID:        e.ID,
+			Archive:   archive,
+			ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(retention), Valid: true},
+			TenantID:  tenantID,
+		}); err != nil {
+			return 0, errors.Wrapf(err, "failed to complete export %v", e.ID)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit exports")
+	}
+
+	return len(pending), nil
+}
+
+// archive builds the user's archive in a savepoint, so a failure doesn't
+// abort the transaction the export is marked failed in.
+func (p *processor) archive(ctx context.Context, tx pgx.Tx, tenantID pgtype.UUID, userID pgtype.UUID) ([]byte, error) {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin savepoint")
+	}
+	defer sp.Rollback(ctx)
+
+	a, err := p.buildArchive(ctx, p.queries.WithTx(sp), tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode archive")
+	}
+
+	return b, nil
+}
+
+func (p *processor) buildArchive(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, userID pgtype.UUID) (*Archive, error) {
+	os, err := q.ListOrders(ctx, &db.ListOrdersParams{UserID: userID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch orders")
+	}
+
+	a := &Archive{
+		UserID:        userID.String(),
+		GeneratedAt:   time.Now(),
+		Orders:        make([]ArchiveOrder, 0, len(os)),
+		Erasures:      []Erasure{},
+		Notifications: []ArchiveNotification{},
+	}
+
+	// messages are enqueued per order, except UserErased which is per user
+	aggregateIDs := []string{userID.String()}
+	for _, o := range os {
+		aggregateIDs = append(aggregateIDs, o.ID.String())
+	}
+
+	for _, o := range os {
+		order, err := p.archiveOrder(ctx, q, o)
+		if err != nil {
+			return nil, errors.Wrapf(err, "order %v", o.ID)
+		}
+		a.Orders = append(a.Orders, *order)
+	}
+
+	erasures, err := q.ListUserErasures(ctx, &db.ListUserErasuresParams{UserID: userID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch erasures")
+	}
+	for _, e := range erasures {
+		a.Erasures = append(a.Erasures, toErasure(e))
+	}
+
+	msgs, err := q.ListAggregateOutboxMessages(ctx, &db.ListAggregateOutboxMessagesParams{TenantID: tenantID, AggregateIds: aggregateIDs})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notifications")
+	}
+	for _, m := range msgs {
+		n, err := archiveNotification(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "notification %d", m.ID)
+		}
+		a.Notifications = append(a.Notifications, *n)
+	}
+
+	return a, nil
+}
+
+func archiveNotification(m *db.OutboxMessage) (*ArchiveNotification, error) {
+	msg, err := outbox.Decode(m.MessageType, m.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode %s", m.MessageType)
+	}
+
+	out := &ArchiveNotification{
+		MessageType: m.MessageType,
+		Message:     b,
+		CreatedAt:   m.CreatedAt.Time,
+	}
+	if m.SentAt.Valid {
+		out.SentAt = &m.SentAt.Time
+	}
+	return out, nil
+}
+
+func (p *processor) archiveOrder(ctx context.Context, q *db.Queries, o *db.Order) (*ArchiveOrder, error) {
+	total, _ := o.TotalAmount.Float64Value()
+
+	out := &ArchiveOrder{
+		OrderID:       o.ID.String(),
+		Status:        string(o.Status),
+		TotalAmount:   total.Float64,
+		FraudScore:    o.FraudScore,
+		FraudReasons:  o.FraudReasons,
+		Items:         []ArchiveItem{},
+		StatusHistory: []StatusChange{},
+		Returns:       []ArchiveReturn{},
+		CreatedAt:     o.CreatedAt.Time,
+		UpdatedAt:     o.UpdatedAt.Time,
+	}
+
+	var err error
+	if out.ShippingAddress, err = p.archiveAddress(ctx, q, o.TenantID, o.ShippingAddressID); err != nil {
+		return nil, err
+	}
+	if out.BillingAddress, err = p.archiveAddress(ctx, q, o.TenantID, o.BillingAddressID); err != nil {
+		return nil, err
+	}
+
+	items, err := q.ListOrderItems(ctx, &db.ListOrderItemsParams{OrderID: o.ID, TenantID: o.TenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order items")
+	}
+	for _, i := range items {
+		price, _ := i.Price.Float64Value()
+		totalPrice, _ := i.TotalPrice.Float64Value()
+		out.Items = append(out.Items, ArchiveItem{
+			OrderItemID: i.ID.String(),
+			ProductID:   i.ProductID.String(),
+			Quantity:    i.Quantity,
+			Price:       price.Float64,
+			TotalPrice:  totalPrice.Float64,
+		})
+	}
+
+	if out.StatusHistory, err = statusHistory(ctx, q, o); err != nil {
+		return nil, err
+	}
+
+	rets, err := q.ListOrderReturns(ctx, &db.ListOrderReturnsParams{OrderID: o.ID, TenantID: o.TenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch returns")
+	}
+	for _, r := range rets {
+		ret, err := archiveReturn(ctx, q, r)
+		if err != nil {
+			return nil, err
+		}
+		out.Returns = append(out.Returns, *ret)
+	}
+
+	return out, nil
+}
+
+func (p *processor) archiveAddress(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, ID pgtype.UUID) (*ArchiveAddress, error) {
+	if !ID.Valid {
+		return nil, nil
+	}
+
+	a, err := q.GetAddress(ctx, &db.GetAddressParams{ID: ID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch address")
+	}
+
+	if err := p.keys.OpenAddress(a); err != nil {
+		return nil, err
+	}
+
+	out := &ArchiveAddress{
+		Line1:      a.Line1,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+	if a.Line2.Valid {
+		out.Line2 = &a.Line2.String
+	}
+	return out, nil
+}
+
+// statusHistory reads the statuses the order went through from its events
+func statusHistory(ctx context.Context, q *db.Queries, o *db.Order) ([]StatusChange, error) {
+	evs, err := q.ListOrderEvents(ctx, &db.ListOrderEventsParams{OrderID: o.ID, TenantID: o.TenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order events")
+	}
+
+	history := []StatusChange{}
+	for _, e := range evs {
+		switch e.EventType {
+		case db.OrderEventTypeOrderCreated:
+			var c orders.OrderCreated
+			if err := json.Unmarshal(e.Payload, &c); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode event %d", e.ID)
+			}
+			history = append(history, StatusChange{To: c.Status, OccurredAt: e.OccurredAt.Time})
+		case db.OrderEventTypeStatusChanged:
+			var c orders.StatusChanged
+			if err := json.Unmarshal(e.Payload, &c); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode event %d", e.ID)
+			}
+			history = append(history, StatusChange{From: c.From, To: c.To, Reason: c.Reason, OccurredAt: e.OccurredAt.Time})
+		}
+	}
+
+	return history, nil
+}
+
+func archiveReturn(ctx context.Context, q *db.Queries, r *db.Return) (*ArchiveReturn, error) {
+	refund, _ := r.RefundAmount.Float64Value()
+
+	out := &ArchiveReturn{
+		ReturnID:     r.ID.String(),
+		Status:       string(r.Status),
+		Reason:       r.Reason,
+		RefundAmount: refund.Float64,
+		Items:        []ArchiveReturnItem{},
+		CreatedAt:    r.CreatedAt.Time,
+	}
+	if r.RejectionReason.Valid {
+		out.RejectionReason = &r.RejectionReason.String
+	}
+
+	items, err := q.ListReturnItems(ctx, &db.ListReturnItemsParams{ReturnID: r.ID, TenantID: r.TenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch return items")
+	}
+	for _, i := range items {
+		itemRefund, _ := i.RefundAmount.Float64Value()
+		item := ArchiveReturnItem{
+			OrderItemID:  i.OrderItemID.String(),
+			Quantity:     i.Quantity,
+			RefundAmount: itemRefund.Float64,
+		}
+		if i.AcceptedQuantity.Valid {
+			item.AcceptedQuantity = &i.AcceptedQuantity.Int32
+		}
+		out.Items = append(out.Items, item)
+	}
+
+	return out, nil
+}
+
+func toExport(e *db.DataExport) Export {
+	out := Export{
+		ExportID:    e.ID.String(),
+		UserID:      e.UserID.String(),
+		RequestedBy: e.RequestedBy,
+		Status:      string(e.Status),
+		CreatedAt:   e.CreatedAt.Time,
+	}
+	if e.Error.Valid {
+		out.Error = &e.Error.String
+	}
+	if e.CompletedAt.Valid {
+		out.CompletedAt = &e.CompletedAt.Time
+	}
+	if e.ExpiresAt.Valid {
+		out.ExpiresAt = &e.ExpiresAt.Time
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+)
+
+func Test_Export(t *testing.T) {
+	conn := SetupTestDb(t)
+	ctx := context.Background()
+	defer conn.Close(ctx)
+
+	p := NewProcessor(conn, nil)
+
+	userId := pgtype.UUID{Bytes: [16]byte{12}, Valid: true}
+	ctx, tenantID := newUserOrder(t, ctx, conn, userId)
+
+	e, err := p.RequestExport(ctx, userId.String(), ExportRequest{RequestedBy: "support"})
+	if err != nil {
+		t.Fatalf("Expected export to be requested %s", err)
+	}
+
+	if e.Status != "pending" {
+		t.Errorf("Expected a pending export, got %s", e.Status)
+	}
+
+	if _, err := p.DownloadExport(ctx, e.ExportID); !errors.Is(err, ErrExportNotReady) {
+		t.Errorf("Expected ErrExportNotReady before the export is generated, got %v", err)
+	}
+
+	if n, err := p.processExports(ctx, tenantID, time.Hour); err != nil || n != 1 {
+		t.Fatalf("Expected one export to be generated, got %d %v", n, err)
+	}
+
+	e, err = p.GetExport(ctx, e.ExportID)
+	if err != nil || e.Status != "completed" || e.ExpiresAt == nil {
+		t.Fatalf("Expected a completed export with an expiry, got %+v %v", e, err)
+	}
+
+	b, err := p.DownloadExport(ctx, e.ExportID)
+	if err != nil {
+		t.Fatalf("Expected archive to be downloaded %s", err)
+	}
+
+	var a Archive
+	if err := json.Unmarshal(b, &a); err != nil {
+		t.Fatalf("Failed to decode archive %s", err)
+	}
+
+	if a.UserID != userId.String() || len(a.Orders) != 1 {
+		t.Fatalf("Expected the user's order in the archive, got %+v", a)
+	}
+
+	o := a.Orders[0]
+	if o.ShippingAddress == nil || o.ShippingAddress.PostalCode != "WC2N 5HR" {
+		t.Errorf("Expected the shipping address in the archive, got %+v", o.ShippingAddress)
+	}
+
+	if len(o.StatusHistory) != 1 || o.StatusHistory[0].To != "pending" {
+		t.Errorf("Expected the initial status in the history, got %+v", o.StatusHistory)
+	}
+
+	if len(a.Notifications) != 1 || a.Notifications[0].MessageType != "simplewebapp.events.OrderPersisted" {
+		t.Errorf("Expected the OrderPersisted notification in the archive, got %+v", a.Notifications)
+	}
+
+	// erasing the user deletes the archive holding their data
+	erasure, err := p.EraseUser(ctx, userId.String(), ErasureRequest{RequestedBy: "dpo"})
+	if err != nil {
+		t.Fatalf("Expected user to be erased %s", err)
+	}
+
+	if erasure.ExportsCount != 1 {
+		t.Errorf("Expected 1 export to be deleted, got %d", erasure.ExportsCount)
+	}
+
+	if _, err := p.GetExport(ctx, e.ExportID); !errors.Is(err, ErrExportNotFound) {
+		t.Errorf("Expected ErrExportNotFound after the erasure, got %v", err)
+	}
+}
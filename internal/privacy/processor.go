@@ -0,0 +1,253 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/encryption"
+	"github.com/ponty96/simple-web-app/internal/events"
+	"github.com/ponty96/simple-web-app/internal/outbox"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+var ErrNotFound = errors.New("no orders found for user")
+
+// Placeholder personal data is replaced with
+const Erased = "[erased]"
+
+// Address fields an erasure scrubs. State and country are kept, as are all
+// financial fields of the orders, which have to be retained for accounting.
+var erasedFields = []string{"line1", "line2", "city", "postal_code"}
+
+// Event payload keys holding an address, see orders.OrderCreated and
+// orders.AddressCorrected
+var addressKeys = []string{"shipping_address", "billing_address", "address"}
+
+type Processor interface {
+	EraseUser(context.Context, string, ErasureRequest) (*Erasure, error)
+	RequestExport(context.Context, string, ExportRequest) (*Export, error)
+	GetExport(context.Context, string) (*Export, error)
+	DownloadExport(context.Context, string) ([]byte, error)
+}
+
+type processor struct {
+	db      db.Conn
+	queries *db.Queries
+	keys    *encryption.Keyring
+}
+
+// NewProcessor returns a privacy processor. k decrypts the addresses put in
+// exports; it may be nil if addresses aren't encrypted.
+func NewProcessor(d db.Conn, k *encryption.Keyring) *processor {
+	return &processor{
+		db:      d,
+		queries: db.New(d),
+		keys:    k,
+	}
+}
+
+// Represents who asked for an erasure and why
+type ErasureRequest struct {
+	RequestedBy string `json:"requested_by"`
+	Reason      string `json:"reason"`
+}
+
+// Represents the audit record of an erasure
+type Erasure struct {
+	ErasureID      string    `json:"erasure_id"`
+	UserID         string    `json:"user_id"`
+	RequestedBy    string    `json:"requested_by"`
+	Reason         *string   `json:"reason,omitempty"`
+	OrdersCount    int32     `json:"orders_count"`
+	AddressesCount int32     `json:"addresses_count"`
+	EventsCount    int32     `json:"events_count"`
+	ExportsCount   int32     `json:"exports_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// EraseUser anonymizes the addresses of every order of the user, in the
+// addresses table and in the payloads of older order events that recorded
+// them in full so a rebuild doesn't bring them back, and deletes the user's
+// data exports. The erasure is audited and a UserErased message is enqueued
+// so downstream copies are scrubbed too. Erasing a user twice is harmless.
+func (p *processor) EraseUser(ctx context.Context, ID string, req ErasureRequest) (*Erasure, error) {
+	var userID pgtype.UUID
+	if err := userID.Scan(ID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	orders, err := q.ListOrders(ctx, &db.ListOrdersParams{UserID: userID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch orders")
+	}
+	if len(orders) == 0 {
+		return nil, ErrNotFound
+	}
+
+	addresses, err := q.AnonymizeUserAddresses(ctx, &db.AnonymizeUserAddressesParams{
+		UserID:   userID,
+		TenantID: tenantID,
+		Line1:    Erased,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to anonymize addresses")
+	}
+
+	// lets this transaction redact the otherwise append-only order events
+	if err := q.EnableErasure(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to enable erasure")
+	}
+
+	var redacted int32
+	orderIDs := make([]string, 0, len(orders))
+	for _, o := range orders {
+		orderIDs = append(orderIDs, o.ID.String())
+
+		evs, err := q.ListOrderEvents(ctx, &db.ListOrderEventsParams{OrderID: o.ID, TenantID: tenantID})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch order events")
+		}
+
+		for _, e := range evs {
+			payload, changed, err := redactAddresses(e.Payload)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to redact event %d", e.ID)
+			}
+			if !changed {
+				continue
+			}
+
+			if err := q.RedactOrderEventPayload(ctx, &db.RedactOrderEventPayloadParams{
+				ID:       e.ID,
+				Payload:  payload,
+				TenantID: tenantID,
+			}); err != nil {
+				return nil, errors.Wrapf(err, "failed to redact event %d", e.ID)
+			}
+			redacted++
+		}
+	}
+
+	// the archives hold the data being erased
+	exports, err := q.DeleteUserDataExports(ctx, &db.DeleteUserDataExportsParams{UserID: userID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete data exports")
+	}
+
+	erasure, err := q.CreateErasure(ctx, &db.CreateErasureParams{
+		TenantID:       tenantID,
+		UserID:         userID,
+		RequestedBy:    req.RequestedBy,
+		Reason:         pgtype.Text{String: req.Reason, Valid: req.Reason != ""},
+		OrdersCount:    int32(len(orders)),
+		AddressesCount: int32(addresses),
+		EventsCount:    redacted,
+		ExportsCount:   int32(exports),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to record erasure")
+	}
+
+	if err := outbox.Enqueue(ctx, q, tenantID, userID.String(), &events.UserErased{
+		ErasureId:    erasure.ID.String(),
+		UserId:       userID.String(),
+		OrderIds:     orderIDs,
+		ErasedFields: erasedFields,
+		OccurredAt:   timestamppb.Now(),
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to commit erasure")
+	}
+
+	out := toErasure(erasure)
+	return &out, nil
+}
+
+// redactAddresses replaces the erased fields of every address in an order
+// event payload. Other fields, including ones it doesn't know about, are kept
+// as they are. Addresses recorded by id only hold nothing to erase. It
+// reports whether the payload held an address.
+func redactAddresses(payload []byte) ([]byte, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for _, key := range addressKeys {
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+
+		var address map[string]interface{}
+		if err := json.Unmarshal(raw, &address); err != nil {
+			return nil, false, err
+		}
+		// a null address, or one recorded by id only
+		if _, ok := address["line1"]; !ok {
+			continue
+		}
+
+		for _, f := range erasedFields {
+			if f == "line2" {
+				address[f] = nil
+				continue
+			}
+			address[f] = Erased
+		}
+
+		b, err := json.Marshal(address)
+		if err != nil {
+			return nil, false, err
+		}
+		fields[key] = b
+		changed = true
+	}
+
+	if !changed {
+		return payload, false, nil
+	}
+
+	b, err := json.Marshal(fields)
+	return b, true, err
+}
+
+func toErasure(e *db.Erasure) Erasure {
+	out := Erasure{
+		ErasureID:      e.ID.String(),
+		UserID:         e.UserID.String(),
+		RequestedBy:    e.RequestedBy,
+		OrdersCount:    e.OrdersCount,
+		AddressesCount: e.AddressesCount,
+		EventsCount:    e.EventsCount,
+		ExportsCount:   e.ExportsCount,
+		CreatedAt:      e.CreatedAt.Time,
+	}
+	if e.Reason.Valid {
+		out.Reason = &e.Reason.String
+	}
+	return out
+}
@@ -0,0 +1,346 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// What the in-memory and Postgres brokers share: they behave like RabbitMQ as
+// far as RabbitMQ uses it, so they're driven through the same Dialer.
+
+// bindingMatches reports whether an exchange of kind routes a message
+// published with key to a queue bound with pattern
+func bindingMatches(kind, pattern, key string) bool {
+	switch kind {
+	case ExchangeFanout:
+		return true
+	case ExchangeTopic:
+		return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+	}
+	return pattern == key
+}
+
+// matchWords matches the words of a key against a topic pattern, where * is
+// exactly one word and # zero or more
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	}
+	return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+}
+
+// deathHeaders returns headers with the x-death entry RabbitMQ adds to a
+// message it dead-letters from queue, reason being rejected or expired
+func deathHeaders(headers amqp.Table, queue, reason, exchange, key string) amqp.Table {
+	h := amqp.Table{}
+	for k, v := range headers {
+		h[k] = v
+	}
+
+	death := amqp.Table{
+		"queue":        queue,
+		"reason":       reason,
+		"exchange":     exchange,
+		"routing-keys": []interface{}{key},
+		"count":        int64(1),
+		"time":         time.Now().UTC(),
+	}
+	deaths, _ := h["x-death"].([]interface{})
+	h["x-death"] = append([]interface{}{death}, deaths...)
+
+	if _, ok := h["x-first-death-reason"]; !ok {
+		h["x-first-death-reason"] = reason
+		h["x-first-death-queue"] = queue
+		h["x-first-death-exchange"] = exchange
+	}
+	return h
+}
+
+// deadLetterTarget returns where a queue declared with args dead-letters its
+// messages, and whether it does
+func deadLetterTarget(args amqp.Table, key string) (exchange, routingKey string, ok bool) {
+	exchange, ok = args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return "", "", false
+	}
+	routingKey = key
+	if k, found := args["x-dead-letter-routing-key"].(string); found {
+		routingKey = k
+	}
+	return exchange, routingKey, true
+}
+
+// messageTTL returns the x-message-ttl of a queue declared with args
+func messageTTL(args amqp.Table) time.Duration {
+	switch v := args["x-message-ttl"].(type) {
+	case int:
+		return time.Duration(v) * time.Millisecond
+	case int32:
+		return time.Duration(v) * time.Millisecond
+	case int64:
+		return time.Duration(v) * time.Millisecond
+	case float64:
+		return time.Duration(v) * time.Millisecond
+	}
+	return 0
+}
+
+// connection is a connection to the in-memory or Postgres broker: a set of
+// channels closed with it
+type connection struct {
+	open func() (Channel, error)
+
+	mu       sync.Mutex
+	closed   bool
+	channels []Channel
+	notify   []chan *amqp.Error
+}
+
+func (c *connection) Channel() (Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	ch, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *connection) NotifyClose(ch chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = append(c.notify, ch)
+	return ch
+}
+
+func (c *connection) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Close closes the channels of the connection, so their unacked messages are
+// redelivered
+func (c *connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	c.closed = true
+	channels := c.channels
+	notify := c.notify
+	c.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.Close()
+	}
+	for _, n := range notify {
+		close(n)
+	}
+	return nil
+}
+
+// sameArguments compares the arguments of two queue declarations, whatever
+// the integer types of their values
+func sameArguments(a, b amqp.Table) bool {
+	return fmt.Sprint(normalizeTable(a)) == fmt.Sprint(normalizeTable(b))
+}
+
+func normalizeTable(t amqp.Table) map[string]interface{} {
+	n := make(map[string]interface{}, len(t))
+	for k, v := range t {
+		switch i := v.(type) {
+		case int:
+			n[k] = int64(i)
+		case int16:
+			n[k] = int64(i)
+		case int32:
+			n[k] = int64(i)
+		case float64:
+			if i == float64(int64(i)) {
+				n[k] = int64(i)
+			} else {
+				n[k] = i
+			}
+		default:
+			n[k] = v
+		}
+	}
+	return n
+}
+
+// confirmer sends the publisher confirms and returns of a channel
+type confirmer struct {
+	mu       sync.Mutex
+	confirm  bool
+	tag      uint64
+	confirms []chan amqp.Confirmation
+	returns  []chan amqp.Return
+}
+
+func (c *confirmer) Confirm(noWait bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirm = true
+	return nil
+}
+
+func (c *confirmer) NotifyPublish(ch chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirms = append(c.confirms, ch)
+	return ch
+}
+
+func (c *confirmer) NotifyReturn(ch chan amqp.Return) chan amqp.Return {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.returns = append(c.returns, ch)
+	return ch
+}
+
+// published returns a mandatory message no queue got, then confirms it
+func (c *confirmer) published(exchange, key string, mandatory, routed bool, msg amqp.Publishing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mandatory && !routed {
+		for _, ch := range c.returns {
+			ch <- amqp.Return{
+				ReplyCode:     amqp.NoRoute,
+				ReplyText:     "NO_ROUTE",
+				Exchange:      exchange,
+				RoutingKey:    key,
+				ContentType:   msg.ContentType,
+				MessageId:     msg.MessageId,
+				CorrelationId: msg.CorrelationId,
+				Timestamp:     msg.Timestamp,
+				Headers:       msg.Headers,
+				Body:          msg.Body,
+			}
+		}
+	}
+
+	if c.confirm {
+		c.tag++
+		for _, ch := range c.confirms {
+			ch <- amqp.Confirmation{DeliveryTag: c.tag, Ack: true}
+		}
+	}
+}
+
+// close closes the notification channels, the way a closed amqp channel does
+func (c *confirmer) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.confirms {
+		close(ch)
+	}
+	for _, ch := range c.returns {
+		close(ch)
+	}
+	c.confirms, c.returns = nil, nil
+}
+
+// unacked tracks the deliveries of a channel waiting to be settled, by tag
+type unacked[T any] struct {
+	tag     uint64
+	pending map[uint64]T
+}
+
+func (u *unacked[T]) add(v T) uint64 {
+	if u.pending == nil {
+		u.pending = make(map[uint64]T)
+	}
+	u.tag++
+	u.pending[u.tag] = v
+	return u.tag
+}
+
+// take removes the delivery tag, or every one up to tag with multiple, and
+// returns them in delivery order
+func (u *unacked[T]) take(tag uint64, multiple bool) ([]T, error) {
+	if !multiple {
+		v, ok := u.pending[tag]
+		if !ok {
+			return nil, unknownTag(tag)
+		}
+		delete(u.pending, tag)
+		return []T{v}, nil
+	}
+
+	var tags []uint64
+	for t := range u.pending {
+		if t <= tag {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) == 0 && tag != 0 {
+		return nil, unknownTag(tag)
+	}
+	return u.remove(tags), nil
+}
+
+// all removes every delivery and returns them in delivery order
+func (u *unacked[T]) all() []T {
+	tags := make([]uint64, 0, len(u.pending))
+	for t := range u.pending {
+		tags = append(tags, t)
+	}
+	return u.remove(tags)
+}
+
+func (u *unacked[T]) remove(tags []uint64) []T {
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	vs := make([]T, 0, len(tags))
+	for _, t := range tags {
+		vs = append(vs, u.pending[t])
+		delete(u.pending, t)
+	}
+	return vs
+}
+
+func (u *unacked[T]) len() int {
+	return len(u.pending)
+}
+
+func unknownTag(tag uint64) error {
+	return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag)}
+}
+
+func notFound(reason string) error {
+	return &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - " + reason}
+}
+
+func preconditionFailed(reason string) error {
+	return &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - " + reason}
+}
+
+func validExchangeKind(kind string) error {
+	if _, err := ParseExchangeKind(kind); err != nil {
+		return &amqp.Error{Code: amqp.CommandInvalid, Reason: "COMMAND_INVALID - invalid exchange type '" + kind + "'"}
+	}
+	return nil
+}
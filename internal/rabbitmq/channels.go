@@ -0,0 +1,139 @@
+package rabbitmq
+
+import (
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publishChannel is a channel in confirm mode with the notifications of the
+// messages published on it. Only one message is in flight on it at a time, so
+// the next confirmation (and return) is always for that message.
+type publishChannel struct {
+	ch       Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// channelPool keeps the publish channels of a connection open between
+// publishes and remembers which exchanges were declared on it. It goes away
+// with its connection; exchanges are declared again on the next one in case
+// the broker lost them.
+type channelPool struct {
+	conn Connection
+	// nil when pooling is disabled
+	idle chan *publishChannel
+
+	mu       sync.Mutex
+	closed   bool
+	declared map[string]bool
+}
+
+func newChannelPool(conn Connection, size int) *channelPool {
+	p := &channelPool{
+		conn:     conn,
+		declared: make(map[string]bool),
+	}
+	if size > 0 {
+		p.idle = make(chan *publishChannel, size)
+	}
+	return p
+}
+
+// get returns an idle channel, or opens a new one when there is none.
+func (p *channelPool) get() (*publishChannel, error) {
+	for {
+		select {
+		case c := <-p.idle:
+			if c.ch.IsClosed() {
+				continue
+			}
+			return c, nil
+		default:
+			return p.open()
+		}
+	}
+}
+
+func (p *channelPool) open() (*publishChannel, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	// buffered so a confirmation that's no longer waited for (the publish
+	// timed out) doesn't block the connection
+	return &publishChannel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// put returns c to the pool once its message was confirmed. It's closed when
+// the pool is full or closed.
+func (p *channelPool) put(c *publishChannel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		select {
+		case p.idle <- c:
+			return
+		default:
+		}
+	}
+	c.ch.Close()
+}
+
+// discard closes c, which can't be reused: it has an unconfirmed message or
+// the broker closed it.
+func (p *channelPool) discard(c *publishChannel) {
+	c.ch.Close()
+}
+
+// declare declares the exchange on c, unless it already was on this
+// connection.
+func (p *channelPool) declare(c *publishChannel, exchange, kind string) error {
+	if p.idle == nil {
+		return declareExchange(c.ch, exchange, kind)
+	}
+
+	p.mu.Lock()
+	declared := p.declared[exchange]
+	p.mu.Unlock()
+	if declared {
+		return nil
+	}
+
+	if err := declareExchange(c.ch, exchange, kind); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.declared[exchange] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// close closes the idle channels. Channels in use are closed when they're put
+// back.
+func (p *channelPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for {
+		select {
+		case c := <-p.idle:
+			c.ch.Close()
+		default:
+			return
+		}
+	}
+}
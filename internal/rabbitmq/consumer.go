@@ -0,0 +1,211 @@
+package rabbitmq
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type consumeOptions struct {
+	workers  int
+	prefetch int
+	key      func(proto.Message) string
+}
+
+// ConsumeOption changes how a subscription consumes its queue
+type ConsumeOption func(*consumeOptions)
+
+// Workers handles up to n messages of the subscription at a time, 1 by
+// default.
+func Workers(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.workers = n
+	}
+}
+
+// Prefetch lets the broker push up to n unacked messages to the subscription,
+// as many as it has workers by default.
+func Prefetch(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.prefetch = n
+	}
+}
+
+// OrderedBy handles the messages with the same key one at a time, in the
+// order they're delivered, while messages with different keys are handled
+// concurrently. Messages that don't decode have an empty key.
+func OrderedBy(key func(proto.Message) string) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.key = key
+	}
+}
+
+// Consumers reports what the subscriptions of a RabbitMQ are doing
+type Consumers interface {
+	ConsumerStats() []ConsumerStats
+}
+
+// Represents the activity of a subscription since the process started
+type ConsumerStats struct {
+	Queue       string `json:"queue"`
+	Workers     int    `json:"workers"`
+	Prefetch    int    `json:"prefetch"`
+	Ordered     bool   `json:"ordered"`
+	InFlight    int64  `json:"in_flight"`
+	Processed   int64  `json:"processed"`
+	Acked       int64  `json:"acked"`
+	Retried     int64  `json:"retried"`
+	Rejected    int64  `json:"rejected"`
+	Quarantined int64  `json:"quarantined"`
+}
+
+// subscription is a router registered with ConsumeRouter, it outlives the
+// connections it consumes on
+type subscription struct {
+	queue  string
+	router *Router
+	opts   consumeOptions
+
+	inFlight atomic.Int64
+	outcomes [OutcomeQuarantine + 1]atomic.Int64
+}
+
+func (r *RabbitMQ) subscribe(queue string, rt *Router, opts []ConsumeOption) *subscription {
+	o := consumeOptions{workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.workers < 1 {
+		o.workers = 1
+	}
+	if o.prefetch < 1 {
+		o.prefetch = o.workers
+	}
+
+	sub := &subscription{queue: queue, router: rt, opts: o}
+
+	r.Register(queue, rt)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs = append(r.subs, sub)
+	return sub
+}
+
+// ConsumerStats returns the activity of every subscription, by queue
+func (r *RabbitMQ) ConsumerStats() []ConsumerStats {
+	r.mu.Lock()
+	subs := append([]*subscription(nil), r.subs...)
+	r.mu.Unlock()
+
+	stats := make([]ConsumerStats, 0, len(subs))
+	for _, sub := range subs {
+		s := ConsumerStats{
+			Queue:       sub.queue,
+			Workers:     sub.opts.workers,
+			Prefetch:    sub.opts.prefetch,
+			Ordered:     sub.opts.key != nil,
+			InFlight:    sub.inFlight.Load(),
+			Acked:       sub.outcomes[OutcomeAck].Load(),
+			Retried:     sub.outcomes[OutcomeRetry].Load(),
+			Rejected:    sub.outcomes[OutcomeReject].Load(),
+			Quarantined: sub.outcomes[OutcomeQuarantine].Load(),
+		}
+		s.Processed = s.Acked + s.Retried + s.Rejected + s.Quarantined
+		stats = append(stats, s)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+	return stats
+}
+
+// workerPool handles the deliveries of a subscription on one channel
+type workerPool struct {
+	sub *subscription
+	// a single queue shared by the workers, or one per worker when messages
+	// are ordered by key
+	queues []chan amqp.Delivery
+	wg     sync.WaitGroup
+}
+
+// startWorkers starts the workers of sub. They finish the deliveries they're
+// given even once ctx is done, which only stops the intake.
+func (r *RabbitMQ) startWorkers(ctx context.Context, sub *subscription) *workerPool {
+	p := &workerPool{sub: sub}
+	handlerCtx := context.WithoutCancel(ctx)
+
+	n := 1
+	if sub.opts.key != nil {
+		n = sub.opts.workers
+	}
+	for i := 0; i < n; i++ {
+		p.queues = append(p.queues, make(chan amqp.Delivery))
+	}
+
+	for i := 0; i < sub.opts.workers; i++ {
+		q := p.queues[i%len(p.queues)]
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for d := range q {
+				sub.inFlight.Add(1)
+				outcome := r.deliver(handlerCtx, sub.queue, d, sub.router)
+				sub.inFlight.Add(-1)
+				sub.outcomes[outcome].Add(1)
+			}
+		}()
+	}
+
+	return p
+}
+
+// dispatch hands d to a worker, waiting for one to be free. It returns false
+// when ctx is done or RabbitMQ stops first; d is then requeued unhandled.
+func (p *workerPool) dispatch(ctx context.Context, stopping <-chan struct{}, d amqp.Delivery) bool {
+	q := p.queues[0]
+	if len(p.queues) > 1 {
+		q = p.queues[p.worker(d)]
+	}
+
+	select {
+	case q <- d:
+		return true
+	case <-ctx.Done():
+	case <-stopping:
+	}
+	requeue(d)
+	return false
+}
+
+// requeue hands d back to the broker for another consumer to handle
+func requeue(d amqp.Delivery) {
+	if err := d.Nack(false, true); err != nil {
+		log.Errorf("EventConsumer: failed to requeue delivery %d: %s", d.DeliveryTag, err)
+	}
+}
+
+// worker returns the worker handling the key of d
+func (p *workerPool) worker(d amqp.Delivery) int {
+	var key string
+	if msg, _, err := p.sub.router.decode(d); err == nil {
+		key = p.sub.opts.key(msg)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// stop waits for the workers to be done with the deliveries they were given
+func (p *workerPool) stop() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,143 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func Test_WorkerPool(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	r.Consume(ctx, &schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}, Workers(4), Prefetch(8))
+
+	ack := newStubAcknowledger()
+	for i := 1; i <= 4; i++ {
+		conn.deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i), Body: encodeOrder(t, "order")}
+	}
+
+	// all 4 are handled at the same time
+	for i := 0; i < 4; i++ {
+		select {
+		case <-started:
+		case <-ctx.Done():
+			t.Fatalf("Expected 4 messages to be handled concurrently, %d were", i)
+		}
+	}
+
+	stats := r.ConsumerStats()
+	if len(stats) != 1 || stats[0].InFlight != 4 || stats[0].Workers != 4 || stats[0].Prefetch != 8 {
+		t.Errorf("Expected 4 messages in flight with 4 workers, got %+v", stats)
+	}
+	conn.mu.Lock()
+	if conn.prefetch != 8 {
+		t.Errorf("Expected a prefetch count of 8, got %d", conn.prefetch)
+	}
+	conn.mu.Unlock()
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		<-ack.settled
+	}
+
+	eventually(t, func() bool {
+		s := r.ConsumerStats()[0]
+		return s.InFlight == 0 && s.Processed == 4 && s.Acked == 4
+	})
+}
+
+func Test_OrderedBy(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	handled := map[string][]int32{}
+	busy := map[string]bool{}
+
+	r.Consume(ctx, &schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+		order := o.(*schemas.Order)
+
+		mu.Lock()
+		if busy[order.OrderId] {
+			t.Errorf("Expected %s to be handled one message at a time", order.OrderId)
+		}
+		busy[order.OrderId] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		busy[order.OrderId] = false
+		handled[order.OrderId] = append(handled[order.OrderId], order.UpdatedAt.GetNanos())
+		mu.Unlock()
+		return nil
+	}, Workers(4), OrderedBy(func(o proto.Message) string {
+		return o.(*schemas.Order).OrderId
+	}))
+
+	ack := newStubAcknowledger()
+	ack.settled = make(chan string, 40)
+	keys := []string{"order-a", "order-b", "order-c"}
+	for i := 0; i < 30; i++ {
+		b, err := proto.Marshal(&schemas.Order{OrderId: keys[i%len(keys)], UpdatedAt: &timestamppb.Timestamp{Nanos: int32(i)}})
+		if err != nil {
+			t.Fatalf("Failed to encode order %s", err)
+		}
+		conn.deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), Body: b}
+	}
+	for i := 0; i < 30; i++ {
+		<-ack.settled
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range keys {
+		seq := handled[key]
+		if len(seq) != 10 {
+			t.Errorf("Expected 10 messages for %s, got %d", key, len(seq))
+		}
+		for i := 1; i < len(seq); i++ {
+			if seq[i] < seq[i-1] {
+				t.Errorf("Expected the messages of %s in order, got %v", key, seq)
+				break
+			}
+		}
+	}
+}
+
+func Test_DispatchWhileStopping(t *testing.T) {
+	// no worker is free
+	p := &workerPool{queues: []chan amqp.Delivery{make(chan amqp.Delivery)}}
+	stopping := make(chan struct{})
+	close(stopping)
+
+	ack := newStubAcknowledger()
+	if p.dispatch(context.Background(), stopping, amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}) {
+		t.Fatal("Expected the delivery not to be dispatched while stopping")
+	}
+	if got := <-ack.settled; got != "nack 1 requeue" {
+		t.Errorf("Expected the delivery to be requeued, got %s", got)
+	}
+}
@@ -0,0 +1,319 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrUnknownQueue = errors.New("unknown queue")
+
+// DeadLetterExchange receives the messages consumers give up on, routed by the
+// name of the queue they came from to that queue's dead letter queue.
+const DeadLetterExchange = "dead-letter"
+
+// Headers a dead-lettered message carries on top of its own
+const (
+	HeaderFailureReason      = "x-failure-reason"
+	HeaderFailedAt           = "x-failed-at"
+	HeaderOriginalExchange   = "x-original-exchange"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+	HeaderOriginalQueue      = "x-original-queue"
+)
+
+// DeadLetterQueue returns the name of the dead letter queue of queue
+func DeadLetterQueue(queue string) string {
+	return queue + ".dead-letter"
+}
+
+// DeadLetters inspects and replays the messages consumers gave up on
+type DeadLetters interface {
+	ListDeadLetters(ctx context.Context) ([]DeadLetterStats, error)
+	PeekDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error)
+	PurgeDeadLetters(ctx context.Context, queue string) (int, error)
+}
+
+// Represents the dead letter queue of a consumed queue
+type DeadLetterStats struct {
+	Queue           string `json:"queue"`
+	DeadLetterQueue string `json:"dead_letter_queue"`
+	Messages        int    `json:"messages"`
+}
+
+// Represents a dead-lettered message
+type DeadLetter struct {
+	Reason     string     `json:"reason"`
+	FailedAt   string     `json:"failed_at,omitempty"`
+	Exchange   string     `json:"exchange"`
+	RoutingKey string     `json:"routing_key"`
+	Headers    amqp.Table `json:"headers"`
+	// the decoded message, Body when it can't be decoded
+	Message     json.RawMessage `json:"message,omitempty"`
+	Body        []byte          `json:"body,omitempty"`
+	DecodeError string          `json:"decode_error,omitempty"`
+}
+
+// declareQueue declares queue with its bindings, with the queue dead-lettering
+// to its own dead letter queue.
+func declareQueue(ch Channel, queue string, bindings []binding, p RetryPolicy) error {
+	return declare(ch, queueTopology(queue, bindings, p))
+}
+
+// DeclareQueue declares the queue messages of in's type are consumed from,
+// with its dead letter queue, and makes its dead letters available without a
+// consumer.
+func (r *RabbitMQ) DeclareQueue(ctx context.Context, in proto.Message) error {
+	rt := NewRouter()
+	if err := rt.Handle(in, nil); err != nil {
+		return err
+	}
+	queue := messageMeta(in).msgType
+
+	r.mu.Lock()
+	if _, ok := r.queues[queue]; !ok {
+		r.queues[queue] = rt
+	}
+	r.mu.Unlock()
+
+	return r.withChannel(ctx, func(ch Channel) error {
+		return declareQueue(ch, queue, r.bindings(rt), r.retryPolicy(queue))
+	})
+}
+
+// withChannel calls f with a channel of its own, closed afterwards
+func (r *RabbitMQ) withChannel(ctx context.Context, f func(ch Channel) error) error {
+	sess, err := r.connection(ctx, r.cfg.FailFast)
+	if err != nil {
+		return err
+	}
+
+	ch, err := sess.conn.Channel()
+	if err != nil {
+		return errors.Wrap(err, "failed to open a channel")
+	}
+	defer ch.Close()
+
+	return f(ch)
+}
+
+// deadLetter publishes a copy of d to the dead letter queue of queue with the
+// reason it failed. d must be acked once it's done.
+func (r *RabbitMQ) deadLetter(ctx context.Context, queue string, d amqp.Delivery, reason error) error {
+	return r.publish(ctx, DeadLetterExchange, "direct", queue, true, copyOf(d, failureHeaders(queue, d, reason)))
+}
+
+// failureHeaders returns the headers of d with why and where it failed
+func failureHeaders(queue string, d amqp.Delivery, reason error) amqp.Table {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderFailureReason] = reason.Error()
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	// retried messages already carry where they came from
+	if _, ok := headers[HeaderOriginalExchange]; !ok {
+		headers[HeaderOriginalExchange] = d.Exchange
+		headers[HeaderOriginalRoutingKey] = d.RoutingKey
+	}
+	headers[HeaderOriginalQueue] = queue
+	return headers
+}
+
+// origin returns where a dead letter was first published to. Messages the
+// broker dead-lettered itself only have its x-death header.
+func origin(d amqp.Delivery) (exchange, key string) {
+	if ex, ok := d.Headers[HeaderOriginalExchange].(string); ok {
+		key, _ := d.Headers[HeaderOriginalRoutingKey].(string)
+		return ex, key
+	}
+
+	if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[len(deaths)-1].(amqp.Table); ok {
+			exchange, _ = death["exchange"].(string)
+			if keys, ok := death["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+				key, _ = keys[0].(string)
+			}
+		}
+	}
+	return exchange, key
+}
+
+func (r *RabbitMQ) router(queue string) (*Router, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt, ok := r.queues[queue]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownQueue, queue)
+	}
+	return rt, nil
+}
+
+// ListDeadLetters returns how many messages every known queue dead-lettered
+func (r *RabbitMQ) ListDeadLetters(ctx context.Context) ([]DeadLetterStats, error) {
+	r.mu.Lock()
+	queues := make([]string, 0, len(r.queues))
+	for q := range r.queues {
+		queues = append(queues, q)
+	}
+	r.mu.Unlock()
+	sort.Strings(queues)
+
+	stats := []DeadLetterStats{}
+	for _, q := range queues {
+		s := DeadLetterStats{Queue: q, DeadLetterQueue: DeadLetterQueue(q)}
+		// a failed passive declaration closes the channel, so each gets its own
+		err := r.withChannel(ctx, func(ch Channel) error {
+			dlq, err := ch.QueueDeclarePassive(s.DeadLetterQueue, true, false, false, false, nil)
+			s.Messages = dlq.Messages
+			return err
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to inspect %s", s.DeadLetterQueue)
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// PeekDeadLetters returns up to limit dead letters of queue, oldest first,
+// leaving them in the queue.
+func (r *RabbitMQ) PeekDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	rt, err := r.router(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	err = r.withChannel(ctx, func(ch Channel) error {
+		var last amqp.Delivery
+		for len(letters) < limit {
+			d, ok, err := ch.Get(DeadLetterQueue(queue), false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			last = d
+			letters = append(letters, toDeadLetter(d, rt))
+		}
+
+		if len(letters) == 0 {
+			return nil
+		}
+		// put them all back where they were
+		return last.Nack(true, true)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to peek %s", DeadLetterQueue(queue))
+	}
+	return letters, nil
+}
+
+func toDeadLetter(d amqp.Delivery, rt *Router) DeadLetter {
+	dl := DeadLetter{Headers: d.Headers}
+	dl.Exchange, dl.RoutingKey = origin(d)
+	dl.Reason, _ = d.Headers[HeaderFailureReason].(string)
+	if dl.Reason == "" {
+		dl.Reason, _ = d.Headers["x-first-death-reason"].(string)
+	}
+	dl.FailedAt, _ = d.Headers[HeaderFailedAt].(string)
+
+	msg, _, err := rt.decode(d)
+	if err == nil {
+		var b []byte
+		if b, err = protojson.Marshal(msg); err == nil {
+			dl.Message = b
+			return dl
+		}
+	}
+	dl.Body = d.Body
+	dl.DecodeError = err.Error()
+	return dl
+}
+
+// ReplayDeadLetters publishes up to limit (all when 0) dead letters of queue
+// back to the exchange they were first published to, and removes them from
+// the dead letter queue. It stops at the first one that fails to publish.
+func (r *RabbitMQ) ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error) {
+	if _, err := r.router(queue); err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	err := r.withChannel(ctx, func(ch Channel) error {
+		// only what's there now: replayed messages failing again come back
+		dlq, err := ch.QueueDeclarePassive(DeadLetterQueue(queue), true, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		n := dlq.Messages
+		if limit > 0 && limit < n {
+			n = limit
+		}
+
+		for replayed < n {
+			d, ok, err := ch.Get(dlq.Name, false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+
+			exchange, key := origin(d)
+			if err := r.publish(ctx, exchange, "", key, true, copyOf(d, replayHeaders(d.Headers))); err != nil {
+				d.Nack(false, true)
+				return errors.Wrapf(err, "failed to replay to %s", exchange)
+			}
+
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+			replayed++
+		}
+		return nil
+	})
+
+	return replayed, errors.Wrapf(err, "failed to replay %s", DeadLetterQueue(queue))
+}
+
+// replayHeaders returns the headers of a dead letter without the ones added
+// when it was dead-lettered
+func replayHeaders(h amqp.Table) amqp.Table {
+	headers := amqp.Table{}
+	for k, v := range h {
+		switch k {
+		case HeaderFailureReason, HeaderFailedAt, HeaderOriginalExchange, HeaderOriginalRoutingKey, HeaderOriginalQueue, HeaderAttempts,
+			"x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason",
+			"x-last-death-exchange", "x-last-death-queue", "x-last-death-reason":
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// PurgeDeadLetters drops the dead letters of queue
+func (r *RabbitMQ) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	if _, err := r.router(queue); err != nil {
+		return 0, err
+	}
+
+	var n int
+	err := r.withChannel(ctx, func(ch Channel) error {
+		var err error
+		n, err = ch.QueuePurge(DeadLetterQueue(queue), false)
+		return err
+	})
+	return n, errors.Wrapf(err, "failed to purge %s", DeadLetterQueue(queue))
+}
@@ -0,0 +1,155 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/correlation"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Outcome is what happens to a delivery once it was handled
+type Outcome int
+
+const (
+	// the message was handled
+	OutcomeAck Outcome = iota
+	// the handler failed with ErrRetryable, the message is tried again later
+	OutcomeRetry
+	// the handler failed, the message is dead-lettered
+	OutcomeReject
+	// the message can't be handled at all (it doesn't decode, has no route or
+	// the handler panics), it's set aside with its raw bytes
+	OutcomeQuarantine
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeAck:
+		return "ack"
+	case OutcomeRetry:
+		return "retry"
+	case OutcomeReject:
+		return "reject"
+	case OutcomeQuarantine:
+		return "quarantine"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// QuarantineQueue returns the name of the queue the poison messages of queue
+// are set aside in
+func QuarantineQueue(queue string) string {
+	return queue + ".quarantine"
+}
+
+// How long settling a delivery (publishing its retry, dead letter or
+// quarantine copy) may take once the handler returned
+const settleTimeout = 10 * time.Second
+
+// deliver handles d and settles it according to the outcome
+func (r *RabbitMQ) deliver(ctx context.Context, queue string, d amqp.Delivery, rt *Router) Outcome {
+	handlerCtx, cancel := context.WithTimeout(ctx, r.cfg.HandlerTimeout)
+	defer cancel()
+
+	outcome, err := handle(handlerCtx, d, rt)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"message_id":     d.MessageId,
+			"correlation_id": d.CorrelationId,
+		}).Errorf("EventConsumer: %s: %s", outcome, err)
+	}
+
+	// a handler running out of time still has its message retried or
+	// dead-lettered, which its deadline would otherwise prevent
+	settleCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settleTimeout)
+	defer cancel()
+
+	if err := r.settle(settleCtx, queue, d, outcome, err); err != nil {
+		log.Errorf("EventConsumer: failed to settle delivery %d: %s", d.DeliveryTag, err)
+	}
+	return outcome
+}
+
+// handle decodes d and calls the handler rt routes it to on behalf of the
+// tenant it carries
+func handle(ctx context.Context, d amqp.Delivery, rt *Router) (outcome Outcome, err error) {
+	event, f, err := rt.decode(d)
+	if err != nil {
+		return OutcomeQuarantine, errors.Wrap(err, "failed to decode message")
+	}
+
+	ctx = newMessageInfoContext(ctx, d)
+	// what the handler publishes is correlated with what it handles
+	if d.CorrelationId != "" {
+		ctx = correlation.NewContext(ctx, d.CorrelationId)
+	}
+
+	if v, ok := d.Headers[tenant.Header].(string); ok {
+		id, err := tenant.Parse(v)
+		if err != nil {
+			return OutcomeQuarantine, err
+		}
+		ctx = tenant.NewContext(ctx, id)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			outcome, err = OutcomeQuarantine, errors.Errorf("handler panicked: %v", p)
+		}
+	}()
+
+	if err := f(ctx, event); err != nil {
+		if errors.Is(err, ErrRetryable) {
+			return OutcomeRetry, err
+		}
+		return OutcomeReject, err
+	}
+	return OutcomeAck, nil
+}
+
+// settle acks or nacks d exactly once. Retried, rejected and quarantined
+// messages are only acked once their copy is confirmed; when that fails the
+// broker is left to requeue or dead-letter them.
+func (r *RabbitMQ) settle(ctx context.Context, queue string, d amqp.Delivery, outcome Outcome, reason error) error {
+	var err error
+	switch outcome {
+	case OutcomeAck:
+		return d.Ack(false)
+	case OutcomeRetry:
+		if err = r.retry(ctx, queue, d, reason); err != nil {
+			return multiErr(err, d.Nack(false, true))
+		}
+	case OutcomeReject:
+		if err = r.deadLetter(ctx, queue, d, reason); err != nil {
+			return multiErr(err, d.Nack(false, false))
+		}
+	case OutcomeQuarantine:
+		if err = r.quarantine(ctx, queue, d, reason); err != nil {
+			return multiErr(err, d.Nack(false, false))
+		}
+	default:
+		return multiErr(errors.Errorf("unknown outcome %s", outcome), d.Nack(false, true))
+	}
+	return d.Ack(false)
+}
+
+func multiErr(err, nackErr error) error {
+	if nackErr != nil {
+		return errors.Wrapf(err, "and failed to nack: %s", nackErr)
+	}
+	return err
+}
+
+// quarantine publishes a copy of d to the quarantine queue of queue, body
+// untouched, with the reason it couldn't be handled. d must be acked once
+// it's done.
+func (r *RabbitMQ) quarantine(ctx context.Context, queue string, d amqp.Delivery, reason error) error {
+	return r.publish(ctx, "", "", QuarantineQueue(queue), true, copyOf(d, failureHeaders(queue, d, reason)))
+}
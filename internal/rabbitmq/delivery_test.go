@@ -0,0 +1,193 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ponty96/simple-web-app/internal/tenant"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func encodeOrder(t *testing.T, id string) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&schemas.Order{OrderId: id})
+	if err != nil {
+		t.Fatalf("Failed to encode order %s", err)
+	}
+	return b
+}
+
+func Test_Handle(t *testing.T) {
+	tenantID := "3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10"
+	order := encodeOrder(t, "order-1")
+
+	tests := []struct {
+		name    string
+		d       amqp.Delivery
+		err     error
+		panics  bool
+		outcome Outcome
+		called  bool
+	}{
+		{name: "handled", d: amqp.Delivery{Body: order, Headers: amqp.Table{tenant.Header: tenantID}}, outcome: OutcomeAck, called: true},
+		{name: "undecodable", d: amqp.Delivery{Body: []byte{0xff}}, outcome: OutcomeQuarantine},
+		{name: "unknown type", d: amqp.Delivery{Body: order, Headers: amqp.Table{HeaderMessageType: "schemas.Refund"}}, outcome: OutcomeQuarantine},
+		{name: "invalid tenant", d: amqp.Delivery{Body: order, Headers: amqp.Table{tenant.Header: "nope"}}, outcome: OutcomeQuarantine},
+		{name: "retryable", d: amqp.Delivery{Body: order}, err: errors.Wrap(ErrRetryable, "database is down"), outcome: OutcomeRetry, called: true},
+		{name: "failed", d: amqp.Delivery{Body: order}, err: errors.New("invalid order"), outcome: OutcomeReject, called: true},
+		{name: "panics", d: amqp.Delivery{Body: order}, panics: true, outcome: OutcomeQuarantine, called: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			rt := NewRouter()
+			rt.Handle(&schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+				called = true
+				if o.(*schemas.Order).OrderId != "order-1" {
+					t.Errorf("Expected order-1 to be decoded, got %v", o)
+				}
+				if _, ok := tenant.FromContext(ctx); !ok && tt.d.Headers[tenant.Header] != nil {
+					t.Error("Expected the tenant in the context")
+				}
+				if tt.panics {
+					panic("nil pointer")
+				}
+				return tt.err
+			})
+			outcome, err := handle(context.Background(), tt.d, rt)
+
+			if outcome != tt.outcome {
+				t.Errorf("Expected outcome %s, got %s (%v)", tt.outcome, outcome, err)
+			}
+			if called != tt.called {
+				t.Errorf("Expected the handler to be called: %t, got %t", tt.called, called)
+			}
+			if (outcome == OutcomeAck) != (err == nil) {
+				t.Errorf("Expected an error with every outcome but ack, got %v", err)
+			}
+		})
+	}
+}
+
+// Test_Settle feeds deliveries straight to deliver and checks every one of
+// them is settled exactly once.
+func Test_Settle(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.DeclareQueue(ctx, &schemas.Order{}); err != nil {
+		t.Fatalf("Failed to declare queue %s", err)
+	}
+
+	order := encodeOrder(t, "order-1")
+
+	tests := []struct {
+		name       string
+		body       []byte
+		err        error
+		unroutable bool
+		settled    string
+		queue      string
+	}{
+		{name: "handled", body: order, settled: "ack 1"},
+		{name: "undecodable", body: []byte{0xff}, settled: "ack 2", queue: QuarantineQueue("entity")},
+		{name: "retryable", body: order, err: ErrRetryable, settled: "ack 3", queue: RetryQueue("entity", time.Second)},
+		{name: "failed", body: order, err: errors.New("invalid order"), settled: "ack 4", queue: DeadLetterQueue("entity")},
+		{name: "retry not published", body: order, err: ErrRetryable, unroutable: true, settled: "nack 5 requeue"},
+		{name: "dead letter not published", body: order, err: errors.New("invalid order"), unroutable: true, settled: "nack 6"},
+		{name: "quarantine not published", body: []byte{0xff}, unroutable: true, settled: "nack 7"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.mu.Lock()
+			conn.unroutable = tt.unroutable
+			conn.mu.Unlock()
+
+			ack := newStubAcknowledger()
+			d := amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), Exchange: "events", RoutingKey: "orders", Body: tt.body}
+
+			var before int
+			if tt.queue != "" {
+				before = len(conn.Queue(tt.queue))
+			}
+
+			rt := NewRouter()
+			rt.Handle(&schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+				return tt.err
+			})
+			r.deliver(ctx, "entity", d, rt)
+
+			close(ack.settled)
+			var settled []string
+			for s := range ack.settled {
+				settled = append(settled, s)
+			}
+			if len(settled) != 1 || settled[0] != tt.settled {
+				t.Errorf("Expected the delivery to be settled once with %q, got %v", tt.settled, settled)
+			}
+
+			if tt.queue != "" {
+				q := conn.Queue(tt.queue)
+				if len(q) != before+1 {
+					t.Fatalf("Expected the message in %s, got %d messages", tt.queue, len(q))
+				}
+				if string(q[len(q)-1].Body) != string(tt.body) {
+					t.Errorf("Expected the raw body to be kept, got %v", q[len(q)-1].Body)
+				}
+			}
+		})
+	}
+
+	if reason, _ := conn.Queue(QuarantineQueue("entity"))[0].Headers[HeaderFailureReason].(string); reason == "" {
+		t.Error("Expected the quarantined message to carry the reason")
+	}
+}
+
+// Test_SettlePastHandlerDeadline checks a handler running out of time still
+// has its message retried: settling doesn't share the handler's deadline.
+func Test_SettlePastHandlerDeadline(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial, HandlerTimeout: 50 * time.Millisecond})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.DeclareQueue(ctx, &schemas.Order{}); err != nil {
+		t.Fatalf("Failed to declare queue %s", err)
+	}
+
+	rt := NewRouter()
+	rt.Handle(&schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+		<-ctx.Done()
+		return errors.Wrap(ErrRetryable, ctx.Err().Error())
+	})
+
+	ack := newStubAcknowledger()
+	d := amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Exchange: "events", RoutingKey: "orders", Body: encodeOrder(t, "order-1")}
+	if outcome := r.deliver(ctx, "entity", d, rt); outcome != OutcomeRetry {
+		t.Fatalf("Expected a retry, got %s", outcome)
+	}
+
+	close(ack.settled)
+	var settled []string
+	for s := range ack.settled {
+		settled = append(settled, s)
+	}
+	if len(settled) != 1 || settled[0] != "ack 1" {
+		t.Errorf("Expected the delivery to be acked once its retry is published, got %v", settled)
+	}
+	if q := conn.Queue(RetryQueue("entity", time.Second)); len(q) != 1 {
+		t.Errorf("Expected the message in the retry queue, got %d messages", len(q))
+	}
+}
@@ -0,0 +1,502 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is a broker kept in memory, for tests and local development.
+// Its Dial is a Dialer: connections come and go, but exchanges, queues and
+// messages stay until the process exits. It routes through fanout, direct and
+// topic exchanges, confirms every publish, returns unroutable mandatory
+// messages, redelivers the unacked messages of a closed channel and
+// dead-letters rejected and expired (x-message-ttl) messages like RabbitMQ.
+type MemoryBroker struct {
+	mu sync.Mutex
+	// signalled whenever messages become ready or consumers can take more
+	ready *sync.Cond
+
+	exchanges map[string]string
+	bindings  map[string][]BindingSpec
+	queues    map[string]*memoryQueue
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		exchanges: make(map[string]string),
+		bindings:  make(map[string][]BindingSpec),
+		queues:    make(map[string]*memoryQueue),
+	}
+	b.ready = sync.NewCond(&b.mu)
+	return b
+}
+
+type memoryQueue struct {
+	name      string
+	args      amqp.Table
+	ttl       time.Duration
+	messages  []memoryMessage
+	consumers int
+}
+
+type memoryMessage struct {
+	exchange    string
+	key         string
+	msg         amqp.Publishing
+	redelivered bool
+	// zero when the queue has no ttl
+	expires time.Time
+}
+
+// Dial connects to the broker, whatever the url
+func (b *MemoryBroker) Dial(url string) (Connection, error) {
+	return &connection{open: func() (Channel, error) {
+		return &memoryChannel{broker: b}, nil
+	}}, nil
+}
+
+// enqueue adds m to q, which dead-letters it once the ttl of q is up. It's
+// called with the lock held.
+func (b *MemoryBroker) enqueue(q *memoryQueue, m memoryMessage) {
+	if q.ttl > 0 {
+		m.expires = time.Now().Add(q.ttl)
+		time.AfterFunc(q.ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expire(q)
+		})
+	}
+	q.messages = append(q.messages, m)
+	b.ready.Broadcast()
+}
+
+// expire dead-letters the expired messages at the head of q
+func (b *MemoryBroker) expire(q *memoryQueue) {
+	now := time.Now()
+	for len(q.messages) > 0 && !q.messages[0].expires.IsZero() && !q.messages[0].expires.After(now) {
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		b.deadLetter(q, m, "expired")
+	}
+}
+
+// deadLetter routes m to the dead letter exchange of q, if it has one
+func (b *MemoryBroker) deadLetter(q *memoryQueue, m memoryMessage, reason string) {
+	exchange, key, ok := deadLetterTarget(q.args, m.key)
+	if !ok {
+		return
+	}
+
+	msg := m.msg
+	msg.Headers = deathHeaders(m.msg.Headers, q.name, reason, m.exchange, m.key)
+	for _, target := range b.route(exchange, key) {
+		b.enqueue(target, memoryMessage{exchange: exchange, key: key, msg: msg})
+	}
+}
+
+// route returns the queues a message published to exchange with key goes to
+func (b *MemoryBroker) route(exchange, key string) []*memoryQueue {
+	if exchange == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*memoryQueue{q}
+		}
+		return nil
+	}
+
+	var queues []*memoryQueue
+	seen := map[string]bool{}
+	for _, binding := range b.bindings[exchange] {
+		if !seen[binding.Queue] && bindingMatches(b.exchanges[exchange], binding.Key, key) {
+			seen[binding.Queue] = true
+			queues = append(queues, b.queues[binding.Queue])
+		}
+	}
+	return queues
+}
+
+// pop takes the next message of q that hasn't expired
+func (b *MemoryBroker) pop(q *memoryQueue) (memoryMessage, bool) {
+	b.expire(q)
+	if len(q.messages) == 0 {
+		return memoryMessage{}, false
+	}
+	m := q.messages[0]
+	q.messages = q.messages[1:]
+	return m, true
+}
+
+// requeue puts the messages back at the head of their queues, in order
+func (b *MemoryBroker) requeue(deliveries []memoryDelivery) {
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := deliveries[i]
+		d.message.redelivered = true
+		d.queue.messages = append([]memoryMessage{d.message}, d.queue.messages...)
+	}
+	b.ready.Broadcast()
+}
+
+type memoryDelivery struct {
+	queue   *memoryQueue
+	message memoryMessage
+}
+
+type memoryChannel struct {
+	confirmer
+	broker *MemoryBroker
+
+	// guarded by the broker's lock
+	closed    bool
+	prefetch  int
+	unacked   unacked[memoryDelivery]
+	consumers []*memoryConsumer
+}
+
+type memoryConsumer struct {
+	queue      *memoryQueue
+	autoAck    bool
+	deliveries chan amqp.Delivery
+	cancelled  chan struct{}
+}
+
+// fail closes the channel the way the broker does when a method fails, and
+// returns err. It's called with the lock held.
+func (c *memoryChannel) fail(err error) error {
+	c.close()
+	return err
+}
+
+func (c *memoryChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	if err := validExchangeKind(kind); err != nil {
+		return c.fail(err)
+	}
+	if k, ok := b.exchanges[name]; ok && k != kind {
+		return c.fail(preconditionFailed(fmt.Sprintf("inequivalent arg 'type' for exchange '%s': received '%s' but current is '%s'", name, kind, k)))
+	}
+	b.exchanges[name] = kind
+	return nil
+}
+
+func (c *memoryChannel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	if _, ok := b.exchanges[name]; !ok {
+		return c.fail(notFound(fmt.Sprintf("no exchange '%s'", name)))
+	}
+	return nil
+}
+
+func (c *memoryChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{name: name, args: args, ttl: messageTTL(args)}
+		b.queues[name] = q
+	} else if !sameArguments(q.args, args) {
+		return amqp.Queue{}, c.fail(preconditionFailed(fmt.Sprintf("inequivalent arguments for queue '%s'", name)))
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: q.consumers}, nil
+}
+
+func (c *memoryChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[name]
+	if !ok {
+		return amqp.Queue{}, c.fail(notFound(fmt.Sprintf("no queue '%s'", name)))
+	}
+	b.expire(q)
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: q.consumers}, nil
+}
+
+func (c *memoryChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	if _, ok := b.queues[name]; !ok {
+		return c.fail(notFound(fmt.Sprintf("no queue '%s'", name)))
+	}
+	if _, ok := b.exchanges[exchange]; !ok {
+		return c.fail(notFound(fmt.Sprintf("no exchange '%s'", exchange)))
+	}
+
+	binding := BindingSpec{Queue: name, Exchange: exchange, Key: key}
+	for _, existing := range b.bindings[exchange] {
+		if existing == binding {
+			return nil
+		}
+	}
+	b.bindings[exchange] = append(b.bindings[exchange], binding)
+	return nil
+}
+
+func (c *memoryChannel) QueuePurge(name string, noWait bool) (int, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return 0, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[name]
+	if !ok {
+		return 0, c.fail(notFound(fmt.Sprintf("no queue '%s'", name)))
+	}
+	n := len(q.messages)
+	q.messages = nil
+	return n, nil
+}
+
+func (c *memoryChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return amqp.Delivery{}, false, c.fail(notFound(fmt.Sprintf("no queue '%s'", queue)))
+	}
+	m, ok := b.pop(q)
+	if !ok {
+		return amqp.Delivery{}, false, nil
+	}
+
+	d := c.delivery(q, m, autoAck)
+	d.MessageCount = uint32(len(q.messages))
+	return d, true, nil
+}
+
+// delivery returns m delivered on the channel, tracked until it's settled
+// unless it's auto-acked
+func (c *memoryChannel) delivery(q *memoryQueue, m memoryMessage, autoAck bool) amqp.Delivery {
+	var tag uint64
+	if autoAck {
+		// tags are per channel, auto-acked deliveries take one too
+		c.unacked.tag++
+		tag = c.unacked.tag
+	} else {
+		tag = c.unacked.add(memoryDelivery{queue: q, message: m})
+	}
+
+	return amqp.Delivery{
+		Acknowledger:  c,
+		ContentType:   m.msg.ContentType,
+		MessageId:     m.msg.MessageId,
+		CorrelationId: m.msg.CorrelationId,
+		Timestamp:     m.msg.Timestamp,
+		Headers:       m.msg.Headers,
+		DeliveryTag:   tag,
+		Redelivered:   m.redelivered,
+		Exchange:      m.exchange,
+		RoutingKey:    m.key,
+		Body:          m.msg.Body,
+	}
+}
+
+func (c *memoryChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	c.prefetch = prefetchCount
+	b.ready.Broadcast()
+	return nil
+}
+
+func (c *memoryChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil, c.fail(notFound(fmt.Sprintf("no queue '%s'", queue)))
+	}
+
+	cons := &memoryConsumer{
+		queue:      q,
+		autoAck:    autoAck,
+		deliveries: make(chan amqp.Delivery),
+		cancelled:  make(chan struct{}),
+	}
+	q.consumers++
+	c.consumers = append(c.consumers, cons)
+	go c.consume(cons)
+	return cons.deliveries, nil
+}
+
+// consume hands the messages of the consumer's queue to it, as many at a time
+// as the prefetch count allows, until the channel is closed
+func (c *memoryChannel) consume(cons *memoryConsumer) {
+	defer close(cons.deliveries)
+	b := c.broker
+
+	for {
+		b.mu.Lock()
+		var m memoryMessage
+		ok := false
+		for !c.closed {
+			if c.prefetch == 0 || c.unacked.len() < c.prefetch || cons.autoAck {
+				if m, ok = b.pop(cons.queue); ok {
+					break
+				}
+			}
+			b.ready.Wait()
+		}
+		if c.closed {
+			b.mu.Unlock()
+			return
+		}
+		d := c.delivery(cons.queue, m, cons.autoAck)
+		b.mu.Unlock()
+
+		// once the channel is closed the delivery is requeued with the rest
+		// of its unacked messages
+		select {
+		case cons.deliveries <- d:
+		case <-cons.cancelled:
+			return
+		}
+	}
+}
+
+func (c *memoryChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	if c.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	if _, ok := b.exchanges[exchange]; !ok && exchange != "" {
+		err := c.fail(notFound(fmt.Sprintf("no exchange '%s'", exchange)))
+		b.mu.Unlock()
+		return err
+	}
+
+	queues := b.route(exchange, key)
+	for _, q := range queues {
+		b.enqueue(q, memoryMessage{exchange: exchange, key: key, msg: msg})
+	}
+	b.mu.Unlock()
+
+	c.published(exchange, key, mandatory, len(queues) > 0, msg)
+	return nil
+}
+
+// Ack, Nack and Reject settle the deliveries of the channel
+
+func (c *memoryChannel) Ack(tag uint64, multiple bool) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	if _, err := c.unacked.take(tag, multiple); err != nil {
+		return c.fail(err)
+	}
+	b.ready.Broadcast()
+	return nil
+}
+
+func (c *memoryChannel) Nack(tag uint64, multiple, requeue bool) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	deliveries, err := c.unacked.take(tag, multiple)
+	if err != nil {
+		return c.fail(err)
+	}
+	if requeue {
+		b.requeue(deliveries)
+		return nil
+	}
+	for _, d := range deliveries {
+		b.deadLetter(d.queue, d.message, "rejected")
+	}
+	b.ready.Broadcast()
+	return nil
+}
+
+func (c *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func (c *memoryChannel) IsClosed() bool {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return c.closed
+}
+
+func (c *memoryChannel) Close() error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.close()
+	return nil
+}
+
+// close cancels the consumers of the channel and requeues its unacked
+// messages. It's called with the lock held.
+func (c *memoryChannel) close() {
+	c.closed = true
+	for _, cons := range c.consumers {
+		cons.queue.consumers--
+		close(cons.cancelled)
+	}
+	c.consumers = nil
+	c.broker.requeue(c.unacked.all())
+	c.confirmer.close()
+}
@@ -0,0 +1,91 @@
+package rabbitmq
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ContentType of the messages Publish publishes
+const ContentType = "application/x-protobuf"
+
+// HeaderSchemaVersion carries the version of the schemas module the message
+// was encoded with
+const HeaderSchemaVersion = "x-schema-version"
+
+const schemasModule = "github.com/ponty96/my-proto-schemas"
+
+var schemaVersion = sync.OnceValue(func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range info.Deps {
+			if m.Path == schemasModule {
+				if m.Replace != nil {
+					m = m.Replace
+				}
+				return m.Version
+			}
+		}
+	}
+	return "unknown"
+})
+
+// Represents the properties of the message a handler is called with
+type MessageInfo struct {
+	ID            string
+	CorrelationID string
+	// full protobuf name of the message
+	Type          string
+	SchemaVersion string
+	ContentType   string
+	// when the message was first published
+	Timestamp   time.Time
+	Redelivered bool
+	// failed attempts at handling it so far
+	Attempts int
+}
+
+type messageInfoKey struct{}
+
+// MessageInfoFromContext returns the properties of the message the handler
+// ctx was passed to is handling.
+func MessageInfoFromContext(ctx context.Context) (MessageInfo, bool) {
+	info, ok := ctx.Value(messageInfoKey{}).(MessageInfo)
+	return info, ok
+}
+
+// NewMessageInfoContext returns ctx as a handler is called with for a message
+// with info, e.g. to call it in tests.
+func NewMessageInfoContext(ctx context.Context, info MessageInfo) context.Context {
+	return context.WithValue(ctx, messageInfoKey{}, info)
+}
+
+func newMessageInfoContext(ctx context.Context, d amqp.Delivery) context.Context {
+	info := MessageInfo{
+		ID:            d.MessageId,
+		CorrelationID: d.CorrelationId,
+		ContentType:   d.ContentType,
+		Timestamp:     d.Timestamp,
+		Redelivered:   d.Redelivered,
+		Attempts:      attempts(d),
+	}
+	info.Type, _ = d.Headers[HeaderMessageType].(string)
+	info.SchemaVersion, _ = d.Headers[HeaderSchemaVersion].(string)
+	return NewMessageInfoContext(ctx, info)
+}
+
+// copyOf returns a publishing of d as it was published, with headers. Retried,
+// dead-lettered and quarantined messages keep their id, correlation id and
+// timestamp.
+func copyOf(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		ContentType:   d.ContentType,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Headers:       headers,
+		Body:          d.Body,
+	}
+}
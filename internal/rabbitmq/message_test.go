@@ -0,0 +1,127 @@
+package rabbitmq
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ponty96/simple-web-app/internal/correlation"
+)
+
+func Test_MessageProperties(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.DeclareQueue(ctx, &schemas.Order{}); err != nil {
+		t.Fatalf("Failed to declare queue %s", err)
+	}
+
+	if err := r.Publish(correlation.NewContext(ctx, "request-1"), &schemas.Order{OrderId: "order-1"}); err != nil {
+		t.Fatalf("Failed to publish %s", err)
+	}
+	if err := r.Publish(ctx, &schemas.Order{OrderId: "order-2"}); err != nil {
+		t.Fatalf("Failed to publish %s", err)
+	}
+
+	q := conn.Queue("entity")
+	if len(q) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(q))
+	}
+	d := q[0]
+	if d.ContentType != ContentType || d.MessageId == "" || d.CorrelationId != "request-1" || d.Timestamp.IsZero() {
+		t.Errorf("Expected the message properties to be set, got %+v", d)
+	}
+	if d.Headers[HeaderMessageType] != "ayoprotoschemas.Order" || d.Headers[HeaderSchemaVersion] == "" {
+		t.Errorf("Expected the schema headers, got %v", d.Headers)
+	}
+	if q[1].MessageId == d.MessageId || q[1].CorrelationId != q[1].MessageId {
+		t.Errorf("Expected a message without correlation id to start its own, got %+v", q[1])
+	}
+
+	// handlers see them, and what they publish is correlated
+	var info MessageInfo
+	rt := NewRouter()
+	rt.Handle(&schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+		info, _ = MessageInfoFromContext(ctx)
+		if id, _ := correlation.FromContext(ctx); id != "request-1" {
+			t.Errorf("Expected the correlation id in the context, got %q", id)
+		}
+		return errors.Wrap(ErrRetryable, "database is down")
+	})
+	d.Acknowledger = newStubAcknowledger()
+	if outcome := r.deliver(ctx, "entity", d, rt); outcome != OutcomeRetry {
+		t.Fatalf("Expected a retry, got %s", outcome)
+	}
+
+	if info.ID != d.MessageId || info.CorrelationID != "request-1" || info.Type != "ayoprotoschemas.Order" ||
+		info.ContentType != ContentType || !info.Timestamp.Equal(d.Timestamp) || info.Attempts != 0 {
+		t.Errorf("Expected the message info in the context, got %+v", info)
+	}
+
+	// the retried copy is the same message
+	retried := conn.Queue(RetryQueue("entity", time.Second))
+	if len(retried) != 1 || retried[0].MessageId != d.MessageId || retried[0].CorrelationId != "request-1" || !retried[0].Timestamp.Equal(d.Timestamp) {
+		t.Errorf("Expected the retry to keep the message properties, got %+v", retried)
+	}
+}
+
+func Test_PublishMessageID(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.DeclareQueue(ctx, &schemas.Order{}); err != nil {
+		t.Fatalf("Failed to declare queue %s", err)
+	}
+
+	// the same message published twice is delivered twice with one id
+	for i := 0; i < 2; i++ {
+		if err := r.Publish(ctx, &schemas.Order{OrderId: "order-1"}, MessageID("outbox:1")); err != nil {
+			t.Fatalf("Failed to publish %s", err)
+		}
+	}
+
+	q := conn.Queue("entity")
+	if len(q) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(q))
+	}
+	for _, d := range q {
+		if d.MessageId != "outbox:1" || d.CorrelationId != "outbox:1" {
+			t.Errorf("Expected the message id to be kept, got %+v", d)
+		}
+	}
+}
+
+func Test_PublishUnencodable(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// proto3 strings must be valid UTF-8
+	if err := r.Publish(ctx, &schemas.Order{OrderId: "\xff"}); err == nil {
+		t.Error("Expected an error for a message that doesn't encode")
+	}
+	if len(conn.published) != 0 {
+		t.Errorf("Expected nothing to be published, got %d messages", len(conn.published))
+	}
+}
+
+func Test_SchemaVersion(t *testing.T) {
+	// the test binary has the build info of its dependencies
+	if v := schemaVersion(); !strings.HasPrefix(v, "v") {
+		t.Errorf("Expected the version of the schemas module, got %q", v)
+	}
+}
@@ -0,0 +1,736 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/ponty96/simple-web-app/internal/correlation"
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+type PostgresConfig struct {
+	// How often consumers look for messages published by other processes, and
+	// expired messages are dead-lettered
+	PollInterval time.Duration
+	// How long a delivered message stays locked to its channel if the channel
+	// stops renewing it, e.g. because its process died
+	Lease time.Duration
+}
+
+// PostgresBroker is a broker kept in the mq_ tables, for deployments that don't
+// run RabbitMQ. Its Dial is a Dialer behaving like the MemoryBroker's, but its
+// queues are shared by every process using the database: a delivery locks its
+// message with FOR UPDATE SKIP LOCKED, acking deletes it, and the messages of
+// a channel closed or lost are delivered again.
+type PostgresBroker struct {
+	db      db.Conn
+	queries *db.Queries
+	cfg     PostgresConfig
+
+	mu sync.Mutex
+	// closed when a message is published in this process, so its consumers
+	// don't wait for the next poll
+	published chan struct{}
+}
+
+func NewPostgresBroker(d db.Conn, cfg PostgresConfig) *PostgresBroker {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 30 * time.Second
+	}
+
+	return &PostgresBroker{
+		db:        d,
+		queries:   db.New(d),
+		cfg:       cfg,
+		published: make(chan struct{}),
+	}
+}
+
+// Dial connects to the broker, whatever the url
+func (b *PostgresBroker) Dial(url string) (Connection, error) {
+	return &connection{open: func() (Channel, error) {
+		c := &postgresChannel{
+			broker:  b,
+			id:      pgtype.Text{String: correlation.NewID(), Valid: true},
+			settled: make(chan struct{}, 1),
+			done:    make(chan struct{}),
+		}
+		go c.maintain()
+		return c, nil
+	}}, nil
+}
+
+// wait returns a channel closed on the next publish
+func (b *PostgresBroker) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published
+}
+
+func (b *PostgresBroker) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.published)
+	b.published = make(chan struct{})
+}
+
+// inTx runs f in a transaction
+func (b *PostgresBroker) inTx(ctx context.Context, f func(q *db.Queries) error) error {
+	tx, err := b.db.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := f(b.queries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// publish adds msg to the queues exchange routes key to, and returns how many
+// there were
+func (b *PostgresBroker) publish(ctx context.Context, q *db.Queries, exchange, key string, msg amqp.Publishing) (int, error) {
+	var queues []string
+	if exchange == "" {
+		if _, err := q.GetMqQueue(ctx, key); err == nil {
+			queues = append(queues, key)
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, errors.Wrap(err, "failed to get queue")
+		}
+	} else {
+		e, err := q.GetMqExchange(ctx, exchange)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, notFound(fmt.Sprintf("no exchange '%s'", exchange))
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get exchange")
+		}
+
+		bindings, err := q.ListMqBindings(ctx, exchange)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to list bindings")
+		}
+		seen := map[string]bool{}
+		for _, binding := range bindings {
+			if !seen[binding.Queue] && bindingMatches(e.Kind, binding.Key, key) {
+				seen[binding.Queue] = true
+				queues = append(queues, binding.Queue)
+			}
+		}
+	}
+
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to encode headers")
+	}
+
+	for _, name := range queues {
+		queue, err := q.GetMqQueue(ctx, name)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get queue")
+		}
+		ttl := messageTTL(decodeTable(queue.Arguments))
+
+		if err := q.EnqueueMqMessage(ctx, &db.EnqueueMqMessageParams{
+			Queue:         name,
+			Exchange:      exchange,
+			RoutingKey:    key,
+			ContentType:   msg.ContentType,
+			MessageID:     msg.MessageId,
+			CorrelationID: msg.CorrelationId,
+			PublishedAt:   pgtype.Timestamptz{Time: msg.Timestamp, Valid: !msg.Timestamp.IsZero()},
+			Headers:       headers,
+			Body:          msg.Body,
+			TtlMs:         pgtype.Int8{Int64: ttl.Milliseconds(), Valid: ttl > 0},
+		}); err != nil {
+			return 0, errors.Wrap(err, "failed to enqueue message")
+		}
+	}
+	return len(queues), nil
+}
+
+// deadLetter publishes m to the dead letter exchange of its queue, if it has
+// one. Messages dead-lettered to an exchange that doesn't exist are dropped.
+func (b *PostgresBroker) deadLetter(ctx context.Context, q *db.Queries, m *db.MqMessage, reason string) error {
+	queue, err := q.GetMqQueue(ctx, m.Queue)
+	if err != nil {
+		return errors.Wrap(err, "failed to get queue")
+	}
+	exchange, key, ok := deadLetterTarget(decodeTable(queue.Arguments), m.RoutingKey)
+	if !ok {
+		return nil
+	}
+
+	msg := publishing(m)
+	msg.Headers = deathHeaders(msg.Headers, m.Queue, reason, m.Exchange, m.RoutingKey)
+	if _, err := b.publish(ctx, q, exchange, key, msg); err != nil && !isAMQPError(err, amqp.NotFound) {
+		return err
+	}
+	return nil
+}
+
+// expire dead-letters the messages whose queue's ttl is up
+func (b *PostgresBroker) expire(ctx context.Context) error {
+	n := 0
+	err := b.inTx(ctx, func(q *db.Queries) error {
+		expired, err := q.ExpireMqMessages(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to expire messages")
+		}
+		for _, m := range expired {
+			if err := b.deadLetter(ctx, q, m, "expired"); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	if err == nil && n > 0 {
+		b.notify()
+	}
+	return err
+}
+
+func publishing(m *db.MqMessage) amqp.Publishing {
+	return amqp.Publishing{
+		ContentType:   m.ContentType,
+		MessageId:     m.MessageID,
+		CorrelationId: m.CorrelationID,
+		Timestamp:     m.PublishedAt.Time,
+		Headers:       decodeTable(m.Headers),
+		Body:          m.Body,
+	}
+}
+
+// decodeTable decodes a table stored as JSON. Numbers are decoded back into
+// int64 when they're integers, which is how amqp decodes them too; times come
+// back as RFC 3339 strings.
+func decodeTable(b []byte) amqp.Table {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var t map[string]interface{}
+	if err := d.Decode(&t); err != nil || t == nil {
+		return amqp.Table{}
+	}
+	return decodeValue(t).(amqp.Table)
+}
+
+func decodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		t := amqp.Table{}
+		for k, e := range v {
+			t[k] = decodeValue(e)
+		}
+		return t
+	case []interface{}:
+		for i, e := range v {
+			v[i] = decodeValue(e)
+		}
+		return v
+	}
+	return v
+}
+
+type postgresChannel struct {
+	confirmer
+	broker *PostgresBroker
+	// locks the messages delivered on the channel
+	id pgtype.Text
+	// signalled when deliveries are settled, so consumers can take more
+	settled chan struct{}
+	// closed with the channel
+	done chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	prefetch int
+	unacked  unacked[int64]
+}
+
+// maintain renews the lease of the messages delivered on the channel and
+// dead-letters expired messages until the channel is closed
+func (c *postgresChannel) maintain() {
+	b := c.broker
+	renew := time.NewTicker(b.cfg.Lease / 3)
+	defer renew.Stop()
+	expire := time.NewTicker(b.cfg.PollInterval)
+	defer expire.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-renew.C:
+			if err := b.queries.ExtendMqLeases(context.Background(), &db.ExtendMqLeasesParams{
+				LeaseMs:  b.cfg.Lease.Milliseconds(),
+				LockedBy: c.id,
+			}); err != nil {
+				log.Errorf("postgres: failed to renew leases: %v", err)
+			}
+		case <-expire.C:
+			if err := b.expire(context.Background()); err != nil {
+				log.Errorf("postgres: %v", err)
+			}
+		}
+	}
+}
+
+// fail closes the channel the way the broker does when a method fails, and
+// returns err. It's called with the lock held.
+func (c *postgresChannel) fail(err error) error {
+	c.close()
+	return err
+}
+
+// queue returns the queue name, failing the channel when it doesn't exist
+func (c *postgresChannel) queue(ctx context.Context, name string) (*db.MqQueue, error) {
+	q, err := c.broker.queries.GetMqQueue(ctx, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, c.fail(notFound(fmt.Sprintf("no queue '%s'", name)))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get queue")
+	}
+	return q, nil
+}
+
+func (c *postgresChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	if err := validExchangeKind(kind); err != nil {
+		return c.fail(err)
+	}
+	e, err := c.broker.queries.DeclareMqExchange(context.Background(), &db.DeclareMqExchangeParams{Name: name, Kind: kind})
+	if err != nil {
+		return errors.Wrap(err, "failed to declare exchange")
+	}
+	if e.Kind != kind {
+		return c.fail(preconditionFailed(fmt.Sprintf("inequivalent arg 'type' for exchange '%s': received '%s' but current is '%s'", name, kind, e.Kind)))
+	}
+	return nil
+}
+
+func (c *postgresChannel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	_, err := c.broker.queries.GetMqExchange(context.Background(), name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.fail(notFound(fmt.Sprintf("no exchange '%s'", name)))
+	}
+	return errors.Wrap(err, "failed to get exchange")
+}
+
+func (c *postgresChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+
+	ctx := context.Background()
+	b, err := json.Marshal(args)
+	if err != nil {
+		return amqp.Queue{}, errors.Wrap(err, "failed to encode arguments")
+	}
+	q, err := c.broker.queries.DeclareMqQueue(ctx, &db.DeclareMqQueueParams{Name: name, Arguments: b})
+	if err != nil {
+		return amqp.Queue{}, errors.Wrap(err, "failed to declare queue")
+	}
+	if !sameArguments(decodeTable(q.Arguments), decodeTable(b)) {
+		return amqp.Queue{}, c.fail(preconditionFailed(fmt.Sprintf("inequivalent arguments for queue '%s'", name)))
+	}
+	return c.inspect(ctx, name)
+}
+
+func (c *postgresChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+
+	ctx := context.Background()
+	if _, err := c.queue(ctx, name); err != nil {
+		return amqp.Queue{}, err
+	}
+	return c.inspect(ctx, name)
+}
+
+// inspect returns the queue name with the number of its messages. Consumers
+// aren't counted, they may be in other processes.
+func (c *postgresChannel) inspect(ctx context.Context, name string) (amqp.Queue, error) {
+	if err := c.broker.expire(ctx); err != nil {
+		return amqp.Queue{}, err
+	}
+	n, err := c.broker.queries.CountMqMessages(ctx, name)
+	if err != nil {
+		return amqp.Queue{}, errors.Wrap(err, "failed to count messages")
+	}
+	return amqp.Queue{Name: name, Messages: int(n)}, nil
+}
+
+func (c *postgresChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	ctx := context.Background()
+	if _, err := c.queue(ctx, name); err != nil {
+		return err
+	}
+	_, err := c.broker.queries.GetMqExchange(ctx, exchange)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.fail(notFound(fmt.Sprintf("no exchange '%s'", exchange)))
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get exchange")
+	}
+
+	return errors.Wrap(c.broker.queries.BindMqQueue(ctx, &db.BindMqQueueParams{
+		Queue:    name,
+		Exchange: exchange,
+		Key:      key,
+	}), "failed to bind queue")
+}
+
+func (c *postgresChannel) QueuePurge(name string, noWait bool) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, amqp.ErrClosed
+	}
+
+	ctx := context.Background()
+	if _, err := c.queue(ctx, name); err != nil {
+		return 0, err
+	}
+	n, err := c.broker.queries.PurgeMqQueue(ctx, name)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge queue")
+	}
+	return int(n), nil
+}
+
+func (c *postgresChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+
+	ctx := context.Background()
+	if _, err := c.queue(ctx, queue); err != nil {
+		return amqp.Delivery{}, false, err
+	}
+	if err := c.broker.expire(ctx); err != nil {
+		return amqp.Delivery{}, false, err
+	}
+	ds, err := c.claim(ctx, queue, 1, autoAck)
+	if err != nil || len(ds) == 0 {
+		return amqp.Delivery{}, false, err
+	}
+	return ds[0], true, nil
+}
+
+// claim locks up to max messages of queue to the channel and returns them as
+// deliveries, deleting them straight away with autoAck. It's called with the
+// lock held.
+func (c *postgresChannel) claim(ctx context.Context, queue string, max int, autoAck bool) ([]amqp.Delivery, error) {
+	q := c.broker.queries
+	messages, err := q.ClaimMqMessages(ctx, &db.ClaimMqMessagesParams{
+		LockedBy: c.id,
+		LeaseMs:  c.broker.cfg.Lease.Milliseconds(),
+		Queue:    queue,
+		Max:      int32(max),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to claim messages")
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	if autoAck && len(messages) > 0 {
+		ids := make([]int64, len(messages))
+		for i, m := range messages {
+			ids[i] = m.ID
+		}
+		if err := q.AckMqMessages(ctx, &db.AckMqMessagesParams{LockedBy: c.id, Ids: ids}); err != nil {
+			return nil, errors.Wrap(err, "failed to ack messages")
+		}
+	}
+
+	ds := make([]amqp.Delivery, len(messages))
+	for i, m := range messages {
+		var tag uint64
+		if autoAck {
+			c.unacked.tag++
+			tag = c.unacked.tag
+		} else {
+			tag = c.unacked.add(m.ID)
+		}
+
+		msg := publishing(m)
+		ds[i] = amqp.Delivery{
+			Acknowledger:  c,
+			ContentType:   msg.ContentType,
+			MessageId:     msg.MessageId,
+			CorrelationId: msg.CorrelationId,
+			Timestamp:     msg.Timestamp,
+			Headers:       msg.Headers,
+			DeliveryTag:   tag,
+			Redelivered:   m.Redelivered,
+			Exchange:      m.Exchange,
+			RoutingKey:    m.RoutingKey,
+			Body:          msg.Body,
+		}
+	}
+	return ds, nil
+}
+
+func (c *postgresChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	c.prefetch = prefetchCount
+	c.signal()
+	return nil
+}
+
+// signal wakes the consumers of the channel up
+func (c *postgresChannel) signal() {
+	select {
+	case c.settled <- struct{}{}:
+	default:
+	}
+}
+
+func (c *postgresChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	if _, err := c.queue(context.Background(), queue); err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan amqp.Delivery)
+	go c.consume(queue, autoAck, deliveries)
+	return deliveries, nil
+}
+
+// consume claims the messages of queue, as many at a time as the prefetch
+// count allows, and hands them to deliveries until the channel is closed
+func (c *postgresChannel) consume(queue string, autoAck bool, deliveries chan amqp.Delivery) {
+	defer close(deliveries)
+	b := c.broker
+
+	for {
+		// taken before claiming so a publish in between isn't missed
+		published := b.wait()
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		max := 10
+		if !autoAck && c.prefetch > 0 {
+			max = min(max, c.prefetch-c.unacked.len())
+		}
+		var ds []amqp.Delivery
+		var err error
+		if max > 0 {
+			ds, err = c.claim(context.Background(), queue, max, autoAck)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			log.Errorf("postgres: failed to consume %s: %v", queue, err)
+		}
+
+		// once the channel is closed the deliveries are released with the
+		// rest of its unacked messages
+		for _, d := range ds {
+			select {
+			case deliveries <- d:
+			case <-c.done:
+				return
+			}
+		}
+		if len(ds) > 0 {
+			continue
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-c.settled:
+		case <-published:
+		case <-time.After(b.cfg.PollInterval):
+		}
+	}
+}
+
+func (c *postgresChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	n := 0
+	err := c.broker.inTx(ctx, func(q *db.Queries) error {
+		var err error
+		n, err = c.broker.publish(ctx, q, exchange, key, msg)
+		return err
+	})
+	if isAMQPError(err, amqp.NotFound) {
+		err = c.fail(err)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		c.broker.notify()
+	}
+	c.published(exchange, key, mandatory, n > 0, msg)
+	return nil
+}
+
+// Ack, Nack and Reject settle the deliveries of the channel
+
+func (c *postgresChannel) Ack(tag uint64, multiple bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	ids, err := c.unacked.take(tag, multiple)
+	if err != nil {
+		return c.fail(err)
+	}
+	defer c.signal()
+	// a message whose lease ran out may have been delivered again, in which
+	// case it's no longer the channel's to delete
+	return errors.Wrap(c.broker.queries.AckMqMessages(context.Background(), &db.AckMqMessagesParams{
+		LockedBy: c.id,
+		Ids:      ids,
+	}), "failed to ack messages")
+}
+
+func (c *postgresChannel) Nack(tag uint64, multiple, requeue bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	ids, err := c.unacked.take(tag, multiple)
+	if err != nil {
+		return c.fail(err)
+	}
+	defer c.signal()
+
+	ctx := context.Background()
+	if requeue {
+		if err := c.broker.queries.ReleaseMqMessages(ctx, &db.ReleaseMqMessagesParams{LockedBy: c.id, Ids: ids}); err != nil {
+			return errors.Wrap(err, "failed to requeue messages")
+		}
+		c.broker.notify()
+		return nil
+	}
+
+	if err := c.broker.inTx(ctx, func(q *db.Queries) error {
+		rejected, err := q.RejectMqMessages(ctx, &db.RejectMqMessagesParams{LockedBy: c.id, Ids: ids})
+		if err != nil {
+			return errors.Wrap(err, "failed to reject messages")
+		}
+		sort.Slice(rejected, func(i, j int) bool { return rejected[i].ID < rejected[j].ID })
+		for _, m := range rejected {
+			if err := c.broker.deadLetter(ctx, q, m, "rejected"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	c.broker.notify()
+	return nil
+}
+
+func (c *postgresChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func (c *postgresChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *postgresChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.close()
+	return nil
+}
+
+// close stops the consumers of the channel and releases its unacked messages.
+// It's called with the lock held.
+func (c *postgresChannel) close() {
+	c.closed = true
+	close(c.done)
+	c.unacked.all()
+	if err := c.broker.queries.ReleaseMqChannel(context.Background(), c.id); err != nil {
+		// they're delivered again once their lease is up
+		log.Errorf("postgres: failed to release messages: %v", err)
+	}
+	c.broker.notify()
+	c.confirmer.close()
+}
@@ -2,7 +2,7 @@ package rabbitmq
 
 import (
 	"context"
-	"fmt"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,44 +10,270 @@ import (
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/ponty96/simple-web-app/internal/correlation"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-var ErrRetryable = errors.New("retryable error")
+var (
+	ErrRetryable    = errors.New("retryable error")
+	ErrNotConnected = errors.New("not connected to rabbitmq")
+	ErrClosed       = errors.New("rabbitmq is closed")
+	// the broker couldn't route a mandatory message to any queue
+	ErrUnroutable = errors.New("message is unroutable")
+	// the broker didn't take responsibility for the message
+	ErrNacked = errors.New("message was nacked by the broker")
+)
 
 type MQ interface {
 	Close() error
-	Publish(context.Context, proto.Message) error
+	Publish(context.Context, proto.Message, ...PublishOption) error
+}
+
+type publishOptions struct {
+	mandatory bool
+	messageID string
+}
+
+// PublishOption changes how a single message is published
+type PublishOption func(*publishOptions)
+
+// Mandatory makes Publish fail with ErrUnroutable when the message isn't
+// routed to any queue, instead of the broker silently dropping it.
+func Mandatory() PublishOption {
+	return func(o *publishOptions) {
+		o.mandatory = true
+	}
+}
+
+// MessageID publishes the message with id instead of a new one. Publishing the
+// same message again with the same id makes it a redelivery the consumers'
+// inbox skips, rather than another message.
+func MessageID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.messageID = id
+	}
 }
 
 type Config struct {
 	URL             string
 	ConnectionCount int
+
+	// Delay before the first reconnection attempt, doubled after every failed
+	// attempt up to MaxReconnectDelay
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+
+	// Publish fails with ErrNotConnected while the broker is unreachable
+	// instead of waiting for the connection to come back (or ctx to be done)
+	FailFast bool
+
+	// Number of idle publish channels kept open, defaults to 16. Negative
+	// disables pooling: every publish opens a channel and declares its exchange.
+	ChannelPoolSize int
+
+	// How long a handler may take with a message, defaults to 30s
+	HandlerTimeout time.Duration
+
+	// How messages failing with ErrRetryable are retried, by default and by
+	// message type (the msg_type of the message, which names its queue)
+	Retry         RetryPolicy
+	RetryPolicies map[string]RetryPolicy
+
+	// Kind of each exchange by name, fanout when missing. The broker refuses to
+	// redeclare an existing exchange with a different kind.
+	Exchanges map[string]string
+	// Routing key template by full protobuf name of the message, e.g.
+	// "order.created.{tenant}", overriding the msg_routing_key option
+	RoutingKeys map[string]string
+
+	// Defaults to DialAMQP
+	Dialer Dialer
 }
 
-// TODO: Consider reconnection logic and multiple connections.
+// NewRabbitMQ returns a RabbitMQ connecting to the broker in the background.
+// The connection is re-established whenever it's lost.
 func NewRabbitMQ(cfg Config) *RabbitMQ {
-	conn, err := amqp.Dial(cfg.URL)
-	failOnError(err, "Failed to connect to RabbitMQ")
-
-	return &RabbitMQ{
-		conn: conn,
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = time.Second
+	}
+	if cfg.MaxReconnectDelay < cfg.ReconnectDelay {
+		cfg.MaxReconnectDelay = 30 * time.Second
+	}
+	if cfg.HandlerTimeout <= 0 {
+		cfg.HandlerTimeout = 30 * time.Second
+	}
+	if cfg.ChannelPoolSize == 0 {
+		cfg.ChannelPoolSize = 16
+	}
+	if cfg.Dialer == nil {
+		cfg.Dialer = DialAMQP
 	}
+	cfg.Retry = cfg.Retry.withDefaults()
+
+	r := &RabbitMQ{
+		cfg:       cfg,
+		connected: make(chan struct{}),
+		queues:    make(map[string]*Router),
+		published: make(map[string]proto.Message),
+		stopping:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go r.run()
+
+	return r
 }
 
 type RabbitMQ struct {
+	cfg Config
+
+	mu sync.Mutex
 	// consider a different connect for publisher and consumer
-	conn *amqp.Connection
-	done chan struct{}
+	sess *session
+	// closed once sess is set, replaced when it's lost
+	connected chan struct{}
+
+	// router of the messages consumed from each queue, and messages published
+	// by type, which make up the topology
+	queues    map[string]*Router
+	published map[string]proto.Message
+	subs      []*subscription
+
+	// closed by Shutdown, the subscriptions stop taking deliveries
+	stopping  chan struct{}
+	stopOnce  sync.Once
+	consumers tracker
+	publishes tracker
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func (e *RabbitMQ) Close() error {
-	return e.conn.Close()
+func (r *RabbitMQ) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.sess != nil {
+			r.sess.channels.close()
+			err = r.sess.conn.Close()
+			r.sess = nil
+		}
+	})
+	return err
 }
 
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Panicf("%s: %s", msg, err)
+// run keeps the connection up until Close is called, reconnecting with
+// exponential backoff.
+func (r *RabbitMQ) run() {
+	delay := r.cfg.ReconnectDelay
+
+	for {
+		conn, err := r.cfg.Dialer(r.cfg.URL)
+		if err != nil {
+			log.Errorf("rabbitmq: failed to connect, retrying in %s: %v", delay, err)
+
+			select {
+			case <-r.done:
+				return
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > r.cfg.MaxReconnectDelay {
+				delay = r.cfg.MaxReconnectDelay
+			}
+			continue
+		}
+
+		delay = r.cfg.ReconnectDelay
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		if !r.setConnection(conn) {
+			conn.Close()
+			return
+		}
+		log.Info("rabbitmq: connected")
+
+		select {
+		case <-r.done:
+			return
+		case err := <-closed:
+			log.Warnf("rabbitmq: connection lost, reconnecting: %v", err)
+			r.clearConnection(conn)
+		}
+	}
+}
+
+// setConnection makes conn the current connection, unless RabbitMQ was closed
+// in the meantime.
+func (r *RabbitMQ) setConnection(conn Connection) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return false
+	default:
+	}
+
+	r.sess = &session{
+		conn:     conn,
+		channels: newChannelPool(conn, r.cfg.ChannelPoolSize),
+	}
+	close(r.connected)
+	return true
+}
+
+func (r *RabbitMQ) clearConnection(conn Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sess != nil && r.sess.conn == conn {
+		r.sess.channels.close()
+		r.sess = nil
+		r.connected = make(chan struct{})
+	}
+}
+
+// session is a connection with its publish channels
+type session struct {
+	conn     Connection
+	channels *channelPool
+}
+
+// connection returns the current session. While there is none it waits for
+// one, unless failFast is set.
+func (r *RabbitMQ) connection(ctx context.Context, failFast bool) (*session, error) {
+	for {
+		r.mu.Lock()
+		sess, connected := r.sess, r.connected
+		r.mu.Unlock()
+
+		if sess != nil {
+			return sess, nil
+		}
+
+		select {
+		case <-r.done:
+			return nil, ErrClosed
+		default:
+		}
+
+		if failFast {
+			return nil, ErrNotConnected
+		}
+
+		select {
+		case <-connected:
+		case <-r.done:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), ErrNotConnected.Error())
+		}
 	}
 }
 
@@ -57,7 +283,8 @@ type Meta struct {
 	msgExchange   string
 }
 
-func (e *RabbitMQ) GetMessageMeta(msg proto.Message) Meta {
+// messageMeta reads the msg_type, routing key and exchange options of msg's type
+func messageMeta(msg proto.Message) Meta {
 	descriptor := msg.ProtoReflect().Descriptor()
 	options := descriptor.Options()
 
@@ -66,138 +293,262 @@ func (e *RabbitMQ) GetMessageMeta(msg proto.Message) Meta {
 	msgRoutingKey := proto.GetExtension(options, schemas.E_MsgRoutingKey).(string)
 	msgExchange := proto.GetExtension(options, schemas.E_MsgExchange).(string)
 
-	// Now you can use these values
-	fmt.Printf("Message Type: %s\n", msgType)
-	fmt.Printf("Routing Key: %s\n", msgRoutingKey)
-	fmt.Printf("Exchange: %s\n", msgExchange)
 	return Meta{msgType, msgRoutingKey, msgExchange}
 }
 
-func (r *RabbitMQ) Publish(ctx context.Context, o proto.Message) error {
-	m := r.GetMessageMeta(o)
+// declareExchange declares an exchange. It's called before publishing and
+// consuming so the topology is back after the broker restarted.
+func declareExchange(ch Channel, name, kind string) error {
+	return ch.ExchangeDeclare(
+		name,  // name
+		kind,  // type
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
+	)
+}
+
+// Publish publishes o and waits (within ctx) for the broker to confirm it.
+func (r *RabbitMQ) Publish(ctx context.Context, o proto.Message, opts ...PublishOption) error {
+	var po publishOptions
+	for _, opt := range opts {
+		opt(&po)
+	}
+
+	m := messageMeta(o)
 
-	ch, err := r.conn.Channel()
+	b, err := proto.Marshal(o)
 	if err != nil {
-		return errors.Wrap(err, "publish: failed to open a channel")
+		return errors.Wrap(err, "failed to encode message")
 	}
-	defer ch.Close()
 
-	if err = ch.ExchangeDeclare(
-		m.msgExchange, // name
-		"fanout",      // type
-		true,          // durable
-		false,         // auto-deleted
-		false,         // internal
-		false,         // no-wait
-		nil,           // arguments
-	); err != nil {
-		return errors.Wrap(err, "publish: failed to declare an exchange")
+	// propagate the tenant so the consumer processes the message on its behalf
+	headers := amqp.Table{
+		HeaderMessageType:   messageType(o),
+		HeaderSchemaVersion: schemaVersion(),
+	}
+	if id, ok := tenant.FromContext(ctx); ok {
+		headers[tenant.Header] = id.String()
 	}
 
-	b, err := proto.Marshal(o)
+	id := po.messageID
+	if id == "" {
+		id = correlation.NewID()
+	}
+	// a message published outside of a request or a handler starts its own
+	// correlation
+	correlationID, ok := correlation.FromContext(ctx)
+	if !ok {
+		correlationID = id
+	}
 
+	key, err := routingKey(ctx, r.routingKeyTemplate(o, m), o)
 	if err != nil {
-		log.Panicf("failed to encode %s", err)
-		return errors.Wrap(err, "failed to encode order proto")
-	}
-	if err = ch.PublishWithContext(ctx,
-		m.msgExchange,   // exchange
-		m.msgRoutingKey, // routing key
-		false,           // mandatory
-		false,           // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(b),
-		}); err != nil {
-		return errors.Wrap(err, "failed to publish order")
+		return errors.Wrap(err, "publish")
 	}
-	return nil
+
+	return r.publish(ctx, m.msgExchange, r.exchangeKind(m.msgExchange), key, po.mandatory, amqp.Publishing{
+		ContentType:   ContentType,
+		MessageId:     id,
+		CorrelationId: correlationID,
+		Timestamp:     time.Now().UTC(),
+		Headers:       headers,
+		Body:          []byte(b),
+	})
 }
 
-func (r *RabbitMQ) Consume(ctx context.Context, in proto.Message, f func(ctx context.Context, o proto.Message) error) error {
-	m := r.GetMessageMeta(in)
+// publish publishes msg on a pooled channel and waits (within ctx) for the
+// broker to confirm it. The exchange is declared first unless kind is empty.
+func (r *RabbitMQ) publish(ctx context.Context, exchange, kind, key string, mandatory bool, msg amqp.Publishing) error {
+	r.publishes.add()
+	defer r.publishes.done()
 
-	ch, err := r.conn.Channel()
+	sess, err := r.connection(ctx, r.cfg.FailFast)
 	if err != nil {
-		return errors.Wrap(err, "consume: failed to open a channel")
+		return errors.Wrap(err, "publish")
 	}
 
-	q, err := ch.QueueDeclare(
-		m.msgType, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-
+	c, err := sess.channels.get()
 	if err != nil {
-		return errors.Wrap(err, "consume: failed to declare a queue")
+		return errors.Wrap(err, "publish: failed to open a channel")
 	}
 
-	err = ch.QueueBind(
-		q.Name,          // queue name
-		m.msgRoutingKey, // routing key
-		m.msgExchange,   // exchange
-		false,
-		nil,
-	)
+	if kind != "" {
+		if err = sess.channels.declare(c, exchange, kind); err != nil {
+			// the broker closes the channel when a declaration fails
+			sess.channels.discard(c)
+			return errors.Wrap(err, "publish: failed to declare an exchange")
+		}
+	}
 
-	if err != nil {
-		return errors.Wrap(err, "consume: failed to bind queue")
+	if err = c.ch.PublishWithContext(ctx,
+		exchange,  // exchange
+		key,       // routing key
+		mandatory, // mandatory
+		false,     // immediate
+		msg); err != nil {
+		sess.channels.discard(c)
+		return errors.Wrap(err, "failed to publish order")
 	}
+	select {
+	case confirm, ok := <-c.confirms:
+		if !ok {
+			sess.channels.discard(c)
+			return errors.Wrap(ErrNotConnected, "publish: channel closed before the message was confirmed")
+		}
+		// the broker returns an unroutable message before confirming it
+		var ret *amqp.Return
+		select {
+		case r := <-c.returns:
+			ret = &r
+		default:
+		}
+		sess.channels.put(c)
 
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+		if !confirm.Ack {
+			return ErrNacked
+		}
+		if ret != nil {
+			return errors.Wrapf(ErrUnroutable, "%s (%d) on exchange %s with routing key %s",
+				ret.ReplyText, ret.ReplyCode, ret.Exchange, ret.RoutingKey)
+		}
+		return nil
+	case <-ctx.Done():
+		// the confirmation may still come, so the channel can't be reused
+		sess.channels.discard(c)
+		return errors.Wrap(ctx.Err(), "publish: waiting for the broker to confirm")
+	case <-r.done:
+		sess.channels.discard(c)
+		return errors.Wrap(ErrClosed, "publish: waiting for the broker to confirm")
+	}
+}
 
-	if err != nil {
-		return errors.Wrap(err, "consume: failed to register a consumer")
+// Consume registers f for messages of in's type until ctx is done. They're
+// consumed from the queue named after the msg_type of in.
+func (r *RabbitMQ) Consume(ctx context.Context, in proto.Message, f func(ctx context.Context, o proto.Message) error, opts ...ConsumeOption) error {
+	rt := NewRouter()
+	if err := rt.Handle(in, f); err != nil {
+		return err
+	}
+	return r.ConsumeRouter(ctx, messageMeta(in).msgType, rt, opts...)
+}
+
+// ConsumeRouter consumes queue until ctx is done, dispatching every message to
+// the handler rt routes its type to. The consumer is registered again on every
+// new connection, so it survives broker restarts.
+func (r *RabbitMQ) ConsumeRouter(ctx context.Context, queue string, rt *Router, opts ...ConsumeOption) error {
+	if len(rt.names) == 0 {
+		return errors.New("consume: the router has no routes")
+	}
+
+	select {
+	case <-r.stopping:
+		return ErrClosed
+	default:
 	}
 
+	sub := r.subscribe(queue, rt, opts)
+
+	r.consumers.add()
 	go func() {
-		defer ch.Close()
+		defer r.consumers.done()
 
 		for {
+			sess, err := r.connection(ctx, false)
+			if err != nil {
+				// ctx is done or RabbitMQ closed
+				return
+			}
+
+			if err := r.consume(ctx, sess.conn, sub); err != nil {
+				log.Errorf("EventConsumer: %s, retrying in %s", err, r.cfg.ReconnectDelay)
+			}
+
 			select {
 			case <-ctx.Done():
 				return
+			case <-r.stopping:
+				return
 			case <-r.done:
 				return
-			case d, ok := <-msgs:
-				if !ok {
-					log.Print("I got NO message from the consumer")
-					return
-				}
-				log.Print("I got a message from the consumer")
-				event := proto.Clone(in)
-
-				if err := proto.Unmarshal(d.Body, event); err != nil {
-					log.Errorf("failed to decode %+v with err %s", d.Body, err)
-				}
-				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-				defer cancel()
-
-				if err := f(ctx, event); err != nil {
-					log.Errorf("EventConsumer: %s", err)
-					// Decide whether to requeue based on error type
-					if errors.Is(err, ErrRetryable) {
-						d.Nack(false, true) // requeue
-					} else {
-						d.Nack(false, false) // don't requeue
-					}
-				}
-
-				d.Ack(false)
+			case <-time.After(r.cfg.ReconnectDelay):
 			}
 		}
 	}()
 
 	return nil
 }
+
+// consume declares the queue of the subscription on conn and hands its
+// deliveries to its workers until the channel or connection is closed, ctx is
+// done or RabbitMQ is closed. It returns once the workers are done with the
+// deliveries they were given.
+func (r *RabbitMQ) consume(ctx context.Context, conn Connection, sub *subscription) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return errors.Wrap(err, "consume: failed to open a channel")
+	}
+	defer ch.Close()
+
+	if err := declareQueue(ch, sub.queue, r.bindings(sub.router), r.retryPolicy(sub.queue)); err != nil {
+		return errors.Wrap(err, "consume")
+	}
+
+	// the broker only pushes as many unacked messages as the workers can use
+	if err := ch.Qos(sub.opts.prefetch, 0, false); err != nil {
+		return errors.Wrap(err, "consume: failed to set the prefetch count")
+	}
+
+	msgs, err := ch.Consume(
+		sub.queue, // queue
+		"",        // consumer
+		false,     // auto-ack
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
+	)
+
+	if err != nil {
+		return errors.Wrap(err, "consume: failed to register a consumer")
+	}
+
+	workers := r.startWorkers(ctx, sub)
+	defer workers.stop()
+
+	for {
+		// a select picks at random between ready cases, so check stopping
+		// first for no delivery to be taken once it's closed
+		select {
+		case <-r.stopping:
+			return nil
+		default:
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.stopping:
+			return nil
+		case <-r.done:
+			return nil
+		case d, ok := <-msgs:
+			if !ok {
+				return errors.New("consume: deliveries channel closed")
+			}
+			// stopping may have closed between the check and the select
+			// taking d, which then goes back to the queue unhandled
+			select {
+			case <-r.stopping:
+				requeue(d)
+				return nil
+			default:
+			}
+			if !workers.dispatch(ctx, r.stopping, d) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderAttempts counts the failed attempts at handling a message
+const HeaderAttempts = "x-attempts"
+
+// Represents how a consumer retries messages failing with ErrRetryable. The
+// n-th retry waits InitialDelay * 2^(n-1), at most MaxDelay. A message is
+// dead-lettered once it failed MaxAttempts times.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = time.Second
+	}
+	if p.MaxDelay < p.InitialDelay {
+		p.MaxDelay = 5 * time.Minute
+		if p.MaxDelay < p.InitialDelay {
+			p.MaxDelay = p.InitialDelay
+		}
+	}
+	return p
+}
+
+// delay returns how long to wait after the given number of failed attempts
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < attempts && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// delays returns the distinct delays of the policy, shortest first
+func (p RetryPolicy) delays() []time.Duration {
+	var delays []time.Duration
+	for a := 1; a < p.MaxAttempts; a++ {
+		d := p.delay(a)
+		if len(delays) > 0 && delays[len(delays)-1] == d {
+			break
+		}
+		delays = append(delays, d)
+	}
+	return delays
+}
+
+// ParseRetryPolicy parses a policy written as <max attempts>/<initial delay>/<max delay>,
+// e.g. 5/1s/5m.
+func ParseRetryPolicy(s string) (RetryPolicy, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return RetryPolicy{}, errors.Errorf("invalid retry policy %q, expected <max attempts>/<initial delay>/<max delay>", s)
+	}
+
+	attempts, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return RetryPolicy{}, errors.Wrapf(err, "invalid max attempts in retry policy %q", s)
+	}
+	initial, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return RetryPolicy{}, errors.Wrapf(err, "invalid initial delay in retry policy %q", s)
+	}
+	max, err := time.ParseDuration(parts[2])
+	if err != nil {
+		return RetryPolicy{}, errors.Wrapf(err, "invalid max delay in retry policy %q", s)
+	}
+
+	return RetryPolicy{MaxAttempts: attempts, InitialDelay: initial, MaxDelay: max}, nil
+}
+
+// RetryQueue returns the name of the queue messages of queue wait delay in
+// before being retried
+func RetryQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+// retryPolicy returns the policy of the messages consumed from queue
+func (r *RabbitMQ) retryPolicy(queue string) RetryPolicy {
+	if p, ok := r.cfg.RetryPolicies[queue]; ok {
+		return p.withDefaults()
+	}
+	return r.cfg.Retry
+}
+
+// attempts returns the number of failed attempts at handling d
+func attempts(d amqp.Delivery) int {
+	switch v := d.Headers[HeaderAttempts].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// retry schedules d, which failed with reason, to be handled again after the
+// delay of its attempt, or dead-letters it once it used up its attempts. d
+// must be acked once it's done.
+func (r *RabbitMQ) retry(ctx context.Context, queue string, d amqp.Delivery, reason error) error {
+	p := r.retryPolicy(queue)
+	n := attempts(d) + 1
+
+	if n >= p.MaxAttempts {
+		return r.deadLetter(ctx, queue, d, errors.Wrapf(reason, "failed %d times", n))
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderAttempts] = int32(n)
+	// the retried message comes back through the default exchange
+	if _, ok := headers[HeaderOriginalExchange]; !ok {
+		headers[HeaderOriginalExchange] = d.Exchange
+		headers[HeaderOriginalRoutingKey] = d.RoutingKey
+	}
+
+	return r.publish(ctx, "", "", RetryQueue(queue, p.delay(n)), true, copyOf(d, headers))
+}
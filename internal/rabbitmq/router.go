@@ -0,0 +1,97 @@
+package rabbitmq
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderMessageType carries the full protobuf name of a published message, for
+// consumers to decode it into the right type
+const HeaderMessageType = "x-msg-type"
+
+var (
+	ErrDuplicateMessageType = errors.New("message type is already routed")
+	ErrUnknownMessageType   = errors.New("no route for message type")
+)
+
+type route struct {
+	in   proto.Message
+	meta Meta
+	f    func(ctx context.Context, o proto.Message) error
+	// binding keys of the queue, its routing key by default
+	keys []string
+}
+
+// RouteOption changes how the messages of a route get to the queue
+type RouteOption func(*route)
+
+// BindingKeys binds the queue to the exchange of the route with the given
+// keys instead of its routing key, e.g. "order.*.eu" or "order.#" on a topic
+// exchange.
+func BindingKeys(keys ...string) RouteOption {
+	return func(r *route) {
+		r.keys = keys
+	}
+}
+
+// Router dispatches the messages consumed from one queue to a handler by type.
+// Types are told apart by their x-msg-type header rather than their msg_type
+// option, which several schemas share ("entity").
+type Router struct {
+	routes map[string]route
+	// in registration order, so the queue is bound the same way every time
+	names []string
+}
+
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]route)}
+}
+
+// Handle routes the messages of in's type to f. The queue a router consumes is
+// bound to the exchange of every type routed, so routes must be registered
+// before the router is consumed.
+func (rt *Router) Handle(in proto.Message, f func(ctx context.Context, o proto.Message) error, opts ...RouteOption) error {
+	name := messageType(in)
+	if _, ok := rt.routes[name]; ok {
+		return errors.Wrap(ErrDuplicateMessageType, name)
+	}
+
+	r := route{in: in, meta: messageMeta(in), f: f}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	rt.routes[name] = r
+	rt.names = append(rt.names, name)
+	return nil
+}
+
+// decode decodes d into a new message of the type it carries. Messages
+// published without the header are decoded into the only type routed, if the
+// router has a single one.
+func (rt *Router) decode(d amqp.Delivery) (proto.Message, func(ctx context.Context, o proto.Message) error, error) {
+	name, ok := d.Headers[HeaderMessageType].(string)
+	if !ok && len(rt.names) == 1 {
+		name = rt.names[0]
+	}
+
+	r, ok := rt.routes[name]
+	if !ok {
+		return nil, nil, errors.Wrapf(ErrUnknownMessageType, "%q", name)
+	}
+
+	msg := proto.Clone(r.in)
+	proto.Reset(msg)
+	if err := proto.Unmarshal(d.Body, msg); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to decode %s", name)
+	}
+	return msg, r.f, nil
+}
+
+// messageType returns the name messages of msg's type are published under
+func messageType(msg proto.Message) string {
+	return string(msg.ProtoReflect().Descriptor().FullName())
+}
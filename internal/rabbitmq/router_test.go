@@ -0,0 +1,112 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+	"google.golang.org/protobuf/proto"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func Test_Router(t *testing.T) {
+	noop := func(ctx context.Context, o proto.Message) error { return nil }
+
+	rt := NewRouter()
+	if err := rt.Handle(&schemas.Order{}, noop); err != nil {
+		t.Fatalf("Failed to route orders %s", err)
+	}
+	if err := rt.Handle(&schemas.Order{}, noop); !errors.Is(err, ErrDuplicateMessageType) {
+		t.Errorf("Expected ErrDuplicateMessageType, got %v", err)
+	}
+
+	order := encodeOrder(t, "order-1")
+	// published before the header existed
+	msg, _, err := rt.decode(amqp.Delivery{Body: order})
+	if err != nil || msg.(*schemas.Order).OrderId != "order-1" {
+		t.Errorf("Expected a message without header to be decoded as the only type, got %v, %v", msg, err)
+	}
+
+	if err := rt.Handle(&schemas.Profile{}, noop); err != nil {
+		t.Fatalf("Failed to route profiles %s", err)
+	}
+	if _, _, err := rt.decode(amqp.Delivery{Body: order}); !errors.Is(err, ErrUnknownMessageType) {
+		t.Errorf("Expected a message without header to be unknown once there are several types, got %v", err)
+	}
+
+	// both types have the msg_type entity, only the header tells them apart
+	if b := (&RabbitMQ{}).bindings(rt); len(b) != 2 || b[0].key != "orders" || b[1].key != "profiles" {
+		t.Errorf("Expected the queue to be bound for orders and profiles, got %+v", b)
+	}
+}
+
+func Test_ConsumeRouter(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	handled := make(chan proto.Message, 2)
+	rt := NewRouter()
+	rt.Handle(&schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+		handled <- o
+		return nil
+	})
+	rt.Handle(&schemas.Profile{}, func(ctx context.Context, o proto.Message) error {
+		handled <- o
+		return nil
+	})
+	if err := r.ConsumeRouter(ctx, "entities", rt); err != nil {
+		t.Fatalf("Failed to consume %s", err)
+	}
+
+	eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.bindings["events"]) == 2
+	})
+
+	if err := r.Publish(ctx, &schemas.Order{OrderId: "order-1"}); err != nil {
+		t.Fatalf("Failed to publish order %s", err)
+	}
+	if err := r.Publish(ctx, &schemas.Profile{Email: "ada@example.com"}); err != nil {
+		t.Fatalf("Failed to publish profile %s", err)
+	}
+
+	q := conn.Queue("entities")
+	if len(q) != 2 || q[0].Headers[HeaderMessageType] != messageType(&schemas.Order{}) {
+		t.Fatalf("Expected both messages in the queue once, got %d", len(q))
+	}
+
+	ack := newStubAcknowledger()
+	for i, d := range q {
+		d.Acknowledger, d.DeliveryTag = ack, uint64(i+1)
+		conn.deliveries <- d
+	}
+
+	for _, want := range []proto.Message{&schemas.Order{OrderId: "order-1"}, &schemas.Profile{Email: "ada@example.com"}} {
+		select {
+		case got := <-handled:
+			if !proto.Equal(got, want) {
+				t.Errorf("Expected %v to be handled, got %v", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Expected %v to be handled", want)
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		if s := <-ack.settled; s != fmt.Sprintf("ack %d", i) {
+			t.Errorf("Expected ack %d, got %s", i, s)
+		}
+	}
+
+	if s := r.ConsumerStats(); len(s) != 1 || s[0].Queue != "entities" {
+		t.Errorf("Expected stats for the entities queue, got %+v", s)
+	}
+}
@@ -0,0 +1,155 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+// Kinds of exchanges messages are published to
+const (
+	ExchangeFanout = "fanout"
+	ExchangeDirect = "direct"
+	ExchangeTopic  = "topic"
+)
+
+var ErrRoutingKey = errors.New("invalid routing key")
+
+// a {field} of a routing key template: a field of the message or the tenant
+var templateField = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Field of a routing key template standing for the tenant of the message
+const tenantField = "tenant"
+
+// ParseExchangeKind checks kind is one of fanout, direct or topic
+func ParseExchangeKind(kind string) (string, error) {
+	switch kind {
+	case ExchangeFanout, ExchangeDirect, ExchangeTopic:
+		return kind, nil
+	}
+	return "", errors.Errorf("unknown exchange kind %q, expected fanout, direct or topic", kind)
+}
+
+// ParseRoutingKey checks the syntax of a routing key template such as
+// "order.created.{tenant}", whose fields are named after fields of the message
+// or are {tenant}.
+func ParseRoutingKey(tmpl string) (string, error) {
+	rest := templateField.ReplaceAllStringFunc(tmpl, func(f string) string {
+		if protoreflect.Name(f[1 : len(f)-1]).IsValid() {
+			return ""
+		}
+		return f
+	})
+	if strings.ContainsAny(rest, "{}") {
+		return "", errors.Wrapf(ErrRoutingKey, "%q", tmpl)
+	}
+	return tmpl, nil
+}
+
+// Represents a queue binding: the queue gets the messages published to
+// exchange whose routing key matches key
+type binding struct {
+	exchange string
+	kind     string
+	key      string
+}
+
+// exchangeKind returns the kind of the exchange name, fanout unless configured
+func (r *RabbitMQ) exchangeKind(name string) string {
+	if kind, ok := r.cfg.Exchanges[name]; ok {
+		return kind
+	}
+	return ExchangeFanout
+}
+
+// routingKeyTemplate returns the routing key of messages of msg's type: the
+// configured template, or else their msg_routing_key option
+func (r *RabbitMQ) routingKeyTemplate(msg proto.Message, m Meta) string {
+	if tmpl, ok := r.cfg.RoutingKeys[messageType(msg)]; ok {
+		return tmpl
+	}
+	return m.msgRoutingKey
+}
+
+// routingKey fills the fields of tmpl in with the values of msg's fields, and
+// {tenant} with the tenant of ctx. Dots in values are replaced, so every field
+// is a single word of the key.
+func routingKey(ctx context.Context, tmpl string, msg proto.Message) (string, error) {
+	var err error
+	key := templateField.ReplaceAllStringFunc(tmpl, func(f string) string {
+		name := f[1 : len(f)-1]
+		v, fieldErr := fieldValue(ctx, name, msg)
+		if fieldErr != nil && err == nil {
+			err = errors.Wrapf(ErrRoutingKey, "%q: %s", tmpl, fieldErr)
+		}
+		return strings.ReplaceAll(v, ".", "_")
+	})
+	return key, err
+}
+
+func fieldValue(ctx context.Context, name string, msg proto.Message) (string, error) {
+	if name == tenantField {
+		id, err := tenant.ID(ctx)
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+
+	m := msg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		return "", errors.Errorf("%s has no field %s", m.Descriptor().FullName(), name)
+	}
+	if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+		return "", errors.Errorf("field %s isn't a scalar", name)
+	}
+
+	v := m.Get(fd)
+	if fd.Enum() != nil {
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), nil
+		}
+	}
+	return fmt.Sprint(v.Interface()), nil
+}
+
+// bindingKey returns the pattern matching the keys of tmpl: on a topic exchange
+// every field is a wildcard word
+func bindingKey(tmpl, kind string) string {
+	if kind != ExchangeTopic {
+		return tmpl
+	}
+	return templateField.ReplaceAllString(tmpl, "*")
+}
+
+// bindings returns how the queue rt consumes is bound: to the exchange of
+// every routed type, with the binding keys of the route or its routing key.
+func (r *RabbitMQ) bindings(rt *Router) []binding {
+	var bs []binding
+	seen := map[binding]bool{}
+	for _, name := range rt.names {
+		route := rt.routes[name]
+		kind := r.exchangeKind(route.meta.msgExchange)
+
+		keys := route.keys
+		if len(keys) == 0 {
+			keys = []string{bindingKey(r.routingKeyTemplate(route.in, route.meta), kind)}
+		}
+		for _, key := range keys {
+			b := binding{exchange: route.meta.msgExchange, kind: kind, key: key}
+			if !seen[b] {
+				seen[b] = true
+				bs = append(bs, b)
+			}
+		}
+	}
+	return bs
+}
@@ -0,0 +1,116 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+func Test_RoutingKey(t *testing.T) {
+	id, _ := tenant.Parse("3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10")
+	ctx := tenant.NewContext(context.Background(), id)
+	order := &schemas.Order{OrderId: "order.1", Status: schemas.OrderStatus_SHIPPED}
+
+	tests := []struct {
+		tmpl string
+		ctx  context.Context
+		key  string
+		err  bool
+	}{
+		{tmpl: "orders", ctx: ctx, key: "orders"},
+		{tmpl: "order.created.{tenant}", ctx: ctx, key: "order.created.3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10"},
+		{tmpl: "order.{status}.{order_id}", ctx: ctx, key: "order.SHIPPED.order_1"},
+		{tmpl: "order.created.{tenant}", ctx: context.Background(), err: true},
+		{tmpl: "order.{nope}", ctx: ctx, err: true},
+		{tmpl: "order.{items}", ctx: ctx, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			key, err := routingKey(tt.ctx, tt.tmpl, order)
+			if tt.err {
+				if !errors.Is(err, ErrRoutingKey) {
+					t.Errorf("Expected ErrRoutingKey, got %q, %v", key, err)
+				}
+				return
+			}
+			if err != nil || key != tt.key {
+				t.Errorf("Expected %q, got %q, %v", tt.key, key, err)
+			}
+		})
+	}
+
+	for _, tmpl := range []string{"order.{", "order.{}", "order.{status"} {
+		if _, err := ParseRoutingKey(tmpl); err == nil {
+			t.Errorf("Expected %q to be invalid", tmpl)
+		}
+	}
+	if _, err := ParseExchangeKind("headers"); err == nil {
+		t.Error("Expected headers exchanges to be unsupported")
+	}
+}
+
+func Test_TopicRouting(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{
+		Dialer:      (&stubDialer{conns: []*stubConnection{conn}}).Dial,
+		Exchanges:   map[string]string{"events": ExchangeTopic},
+		RoutingKeys: map[string]string{"ayoprotoschemas.Order": "order.{status}.{tenant}"},
+	})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	noop := func(ctx context.Context, o proto.Message) error { return nil }
+	all := NewRouter()
+	all.Handle(&schemas.Order{}, noop)
+	shipped := NewRouter()
+	shipped.Handle(&schemas.Order{}, noop, BindingKeys("order.SHIPPED.*"))
+	r.ConsumeRouter(ctx, "orders", all)
+	r.ConsumeRouter(ctx, "shipped-orders", shipped)
+
+	eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.bindings["events"]) == 2
+	})
+	conn.mu.Lock()
+	if conn.kinds["events"] != ExchangeTopic {
+		t.Errorf("Expected a topic exchange, got %s", conn.kinds["events"])
+	}
+	bound := map[[2]string]bool{}
+	for _, b := range conn.bindings["events"] {
+		bound[b] = true
+	}
+	if !bound[[2]string{"orders", "order.*.*"}] || !bound[[2]string{"shipped-orders", "order.SHIPPED.*"}] {
+		t.Errorf("Expected the fields of the routing key to be wildcards, got %v", conn.bindings["events"])
+	}
+	conn.mu.Unlock()
+
+	id, _ := tenant.Parse("3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10")
+	pubCtx := tenant.NewContext(ctx, id)
+	for _, status := range []schemas.OrderStatus{schemas.OrderStatus_PENDING, schemas.OrderStatus_SHIPPED} {
+		if err := r.Publish(pubCtx, &schemas.Order{OrderId: "order-1", Status: status}); err != nil {
+			t.Fatalf("Failed to publish %s", err)
+		}
+	}
+
+	if n := len(conn.Queue("orders")); n != 2 {
+		t.Errorf("Expected every order in orders, got %d", n)
+	}
+	q := conn.Queue("shipped-orders")
+	if len(q) != 1 || q[0].RoutingKey != "order.SHIPPED.3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10" {
+		t.Errorf("Expected the shipped order only, got %+v", q)
+	}
+
+	if err := r.Publish(ctx, &schemas.Order{OrderId: "order-2"}); !errors.Is(err, ErrRoutingKey) {
+		t.Errorf("Expected a key missing its tenant to fail, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Shutdown stops RabbitMQ gracefully: the subscriptions stop taking
+// deliveries, the deliveries being handled are finished and settled, the
+// publishes waiting for a confirmation get it, then the connection is closed.
+// When ctx is done first the connection is closed anyway, leaving what's
+// unacked to be redelivered, and ctx's error is returned.
+func (r *RabbitMQ) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.stopping)
+	})
+
+	err := r.consumers.wait(ctx)
+	if err == nil {
+		// what's left is mostly the handlers' retries and dead letters
+		err = r.publishes.wait(ctx)
+	}
+
+	if cErr := r.Close(); err == nil {
+		err = cErr
+	}
+	return errors.Wrap(err, "rabbitmq shutdown")
+}
+
+// tracker counts running operations and lets Shutdown wait for them. Unlike a
+// sync.WaitGroup operations may start while it's waited for.
+type tracker struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+func (t *tracker) add() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.n == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.n++
+}
+
+func (t *tracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n--
+	if t.n == 0 {
+		close(t.idle)
+	}
+}
+
+// wait returns once nothing is running, or with ctx's error
+func (t *tracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
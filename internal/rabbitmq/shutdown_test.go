@@ -0,0 +1,107 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+	"google.golang.org/protobuf/proto"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func Test_Shutdown(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	r.Consume(ctx, &schemas.Order{}, func(ctx context.Context, o proto.Message) error {
+		started <- ctx
+		<-release
+		// dead-lettered while shutting down
+		return errors.New("invalid order")
+	})
+
+	ack := newStubAcknowledger()
+	conn.deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Body: encodeOrder(t, "order-1")}
+	handlerCtx := <-started
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelShutdown()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- r.Shutdown(shutdownCtx)
+	}()
+	<-r.stopping
+
+	// the consumer's own ctx being cancelled doesn't abort the handler either
+	cancel()
+
+	// no more deliveries are taken
+	select {
+	case conn.deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 2, Body: encodeOrder(t, "order-2")}:
+		t.Error("Expected no delivery to be taken while shutting down")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Expected shutdown to wait for the handler, returned %v", err)
+	default:
+	}
+	if conn.IsClosed() {
+		t.Fatal("Expected the connection to stay open while a delivery is handled")
+	}
+	if handlerCtx.Err() != nil {
+		t.Errorf("Expected the handler's ctx not to be cancelled, got %v", handlerCtx.Err())
+	}
+
+	close(release)
+
+	if err := <-stopped; err != nil {
+		t.Fatalf("Expected a graceful shutdown, got %v", err)
+	}
+	if got := <-ack.settled; got != "ack 1" {
+		t.Errorf("Expected the delivery to be settled before the connection closed, got %s", got)
+	}
+	if len(conn.Queue(DeadLetterQueue("entity"))) != 1 {
+		t.Error("Expected the dead letter to be published before the connection closed")
+	}
+	if !conn.IsClosed() {
+		t.Error("Expected the connection to be closed")
+	}
+
+	if err := r.Consume(context.Background(), &schemas.Order{}, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected Consume after Shutdown to return ErrClosed, got %v", err)
+	}
+}
+
+func Test_ShutdownDeadline(t *testing.T) {
+	conn := newStubConnection()
+	conn.noConfirm = true
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+
+	published := make(chan error, 1)
+	go func() {
+		published <- r.Publish(context.Background(), &schemas.Order{})
+	}()
+	eventually(t, func() bool { return len(conn.Published()) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected shutdown to give up waiting for the confirmation, got %v", err)
+	}
+	if !conn.IsClosed() {
+		t.Error("Expected the connection to be closed anyway")
+	}
+	if err := <-published; !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected the unconfirmed publish to fail with ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package rabbitmq
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Represents the exchanges, queues and bindings the app needs on the broker.
+// Everything is durable.
+type Topology struct {
+	Exchanges []ExchangeSpec `json:"exchanges"`
+	Queues    []QueueSpec    `json:"queues"`
+	Bindings  []BindingSpec  `json:"bindings"`
+}
+
+// Represents an exchange of the topology
+type ExchangeSpec struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// Represents a queue of the topology
+type QueueSpec struct {
+	Name      string     `json:"name"`
+	Arguments amqp.Table `json:"arguments,omitempty"`
+}
+
+// Represents the binding of a queue to an exchange
+type BindingSpec struct {
+	Queue    string `json:"queue"`
+	Exchange string `json:"exchange"`
+	Key      string `json:"key"`
+}
+
+// Represents a part of the topology the broker doesn't have as it should
+type TopologyDiff struct {
+	// exchange or queue
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// missing, or differs when it exists with another kind or arguments
+	Problem string `json:"problem"`
+}
+
+// queueTopology returns queue with its bindings, its dead letter queue and
+// its retry and quarantine queues, with the exchanges they need.
+func queueTopology(queue string, bindings []binding, p RetryPolicy) Topology {
+	var t Topology
+	for _, b := range bindings {
+		t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: b.exchange, Kind: b.kind})
+	}
+	t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: DeadLetterExchange, Kind: ExchangeDirect})
+
+	t.Queues = append(t.Queues, QueueSpec{Name: DeadLetterQueue(queue)})
+	t.Bindings = append(t.Bindings, BindingSpec{Queue: DeadLetterQueue(queue), Exchange: DeadLetterExchange, Key: queue})
+
+	// messages expire from a retry queue back to queue through the default
+	// exchange; having one queue per delay keeps short delays from waiting
+	// behind long ones
+	for _, d := range p.delays() {
+		t.Queues = append(t.Queues, QueueSpec{Name: RetryQueue(queue, d), Arguments: amqp.Table{
+			"x-message-ttl":             d.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		}})
+	}
+	t.Queues = append(t.Queues, QueueSpec{Name: QuarantineQueue(queue)})
+
+	t.Queues = append(t.Queues, QueueSpec{Name: queue, Arguments: amqp.Table{
+		"x-dead-letter-exchange":    DeadLetterExchange,
+		"x-dead-letter-routing-key": queue,
+	}})
+	for _, b := range bindings {
+		t.Bindings = append(t.Bindings, BindingSpec{Queue: queue, Exchange: b.exchange, Key: b.key})
+	}
+	return t
+}
+
+// merge adds what o has that t doesn't yet
+func (t *Topology) merge(o Topology) {
+	exchanges := map[string]bool{}
+	for _, e := range t.Exchanges {
+		exchanges[e.Name] = true
+	}
+	for _, e := range o.Exchanges {
+		if !exchanges[e.Name] {
+			exchanges[e.Name] = true
+			t.Exchanges = append(t.Exchanges, e)
+		}
+	}
+
+	queues := map[string]bool{}
+	for _, q := range t.Queues {
+		queues[q.Name] = true
+	}
+	for _, q := range o.Queues {
+		if !queues[q.Name] {
+			queues[q.Name] = true
+			t.Queues = append(t.Queues, q)
+		}
+	}
+
+	bindings := map[BindingSpec]bool{}
+	for _, b := range t.Bindings {
+		bindings[b] = true
+	}
+	for _, b := range o.Bindings {
+		if !bindings[b] {
+			bindings[b] = true
+			t.Bindings = append(t.Bindings, b)
+		}
+	}
+}
+
+// declare declares the exchanges, then the queues, then the bindings of t.
+// Declaring what already exists the same way does nothing.
+func declare(ch Channel, t Topology) error {
+	for _, e := range t.Exchanges {
+		if err := declareExchange(ch, e.Name, e.Kind); err != nil {
+			return errors.Wrapf(err, "failed to declare exchange %s", e.Name)
+		}
+	}
+
+	for _, q := range t.Queues {
+		if _, err := ch.QueueDeclare(
+			q.Name,      // name
+			true,        // durable
+			false,       // delete when unused
+			false,       // exclusive
+			false,       // no-wait
+			q.Arguments, // arguments
+		); err != nil {
+			return errors.Wrapf(err, "failed to declare queue %s", q.Name)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if err := ch.QueueBind(b.Queue, b.Key, b.Exchange, false, nil); err != nil {
+			return errors.Wrapf(err, "failed to bind queue %s to %s", b.Queue, b.Exchange)
+		}
+	}
+	return nil
+}
+
+// Register makes rt's queue part of the topology without consuming it, e.g.
+// to declare it or handle its dead letters. Consuming a router registers it.
+func (r *RabbitMQ) Register(queue string, rt *Router) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queues[queue] = rt
+}
+
+// RegisterPublished makes the exchanges messages of msgs' types are published
+// to part of the topology.
+func (r *RabbitMQ) RegisterPublished(msgs ...proto.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.published[messageType(msg)] = msg
+	}
+}
+
+// Topology returns the topology of the registered queues and published
+// messages
+func (r *RabbitMQ) Topology() Topology {
+	r.mu.Lock()
+	queues := make(map[string]*Router, len(r.queues))
+	for q, rt := range r.queues {
+		queues[q] = rt
+	}
+	var published []proto.Message
+	for _, msg := range r.published {
+		published = append(published, msg)
+	}
+	r.mu.Unlock()
+
+	names := make([]string, 0, len(queues))
+	for q := range queues {
+		names = append(names, q)
+	}
+	sort.Strings(names)
+
+	var t Topology
+	sort.Slice(published, func(i, j int) bool { return messageType(published[i]) < messageType(published[j]) })
+	for _, msg := range published {
+		name := messageMeta(msg).msgExchange
+		t.merge(Topology{Exchanges: []ExchangeSpec{{Name: name, Kind: r.exchangeKind(name)}}})
+	}
+	for _, q := range names {
+		t.merge(queueTopology(q, r.bindings(queues[q]), r.retryPolicy(q)))
+	}
+	return t
+}
+
+// ApplyTopology declares the whole topology, so it exists before any message
+// is published or consumed.
+func (r *RabbitMQ) ApplyTopology(ctx context.Context) error {
+	return r.withChannel(ctx, func(ch Channel) error {
+		return declare(ch, r.Topology())
+	})
+}
+
+// DiffTopology returns the exchanges and queues of the topology the broker is
+// missing, or has with another kind or other arguments. AMQP can't list the
+// bindings of a queue, so they aren't compared; applying the topology adds the
+// missing ones.
+func (r *RabbitMQ) DiffTopology(ctx context.Context) ([]TopologyDiff, error) {
+	t := r.Topology()
+	diffs := []TopologyDiff{}
+
+	for _, e := range t.Exchanges {
+		problem, err := r.diff(ctx, func(ch Channel) error {
+			return ch.ExchangeDeclarePassive(e.Name, e.Kind, true, false, false, false, nil)
+		}, func(ch Channel) error {
+			return declareExchange(ch, e.Name, e.Kind)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to inspect exchange %s", e.Name)
+		}
+		if problem != "" {
+			diffs = append(diffs, TopologyDiff{Kind: "exchange", Name: e.Name, Problem: problem})
+		}
+	}
+
+	for _, q := range t.Queues {
+		problem, err := r.diff(ctx, func(ch Channel) error {
+			_, err := ch.QueueDeclarePassive(q.Name, true, false, false, false, nil)
+			return err
+		}, func(ch Channel) error {
+			_, err := ch.QueueDeclare(q.Name, true, false, false, false, q.Arguments)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to inspect queue %s", q.Name)
+		}
+		if problem != "" {
+			diffs = append(diffs, TopologyDiff{Kind: "queue", Name: q.Name, Problem: problem})
+		}
+	}
+
+	return diffs, nil
+}
+
+// diff checks something exists with passive, then that declaring it as it
+// should be is accepted, which changes nothing when it already is. Failed
+// declarations close the channel, so each gets its own.
+func (r *RabbitMQ) diff(ctx context.Context, passive, active func(ch Channel) error) (string, error) {
+	err := r.withChannel(ctx, passive)
+	if isAMQPError(err, amqp.NotFound) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = r.withChannel(ctx, active)
+	if isAMQPError(err, amqp.PreconditionFailed) {
+		var amqpErr *amqp.Error
+		errors.As(err, &amqpErr)
+		return "differs: " + amqpErr.Reason, nil
+	}
+	return "", err
+}
+
+func isAMQPError(err error, code int) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == code
+}
@@ -0,0 +1,118 @@
+package rabbitmq
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ponty96/my-proto-schemas/output/schemas"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func Test_Topology(t *testing.T) {
+	r := NewRabbitMQ(Config{
+		Dialer:    (&stubDialer{}).Dial,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute},
+		Exchanges: map[string]string{"events": ExchangeTopic},
+	})
+	defer r.Close()
+
+	orders := NewRouter()
+	orders.Handle(&schemas.Order{}, nil)
+	entities := NewRouter()
+	entities.Handle(&schemas.Order{}, nil)
+	entities.Handle(&schemas.Profile{}, nil)
+	r.Register("orders", orders)
+	r.Register("entities", entities)
+	r.RegisterPublished(&schemas.Order{}, &schemas.Profile{})
+
+	top := r.Topology()
+
+	if len(top.Exchanges) != 2 || top.Exchanges[0] != (ExchangeSpec{Name: "events", Kind: ExchangeTopic}) ||
+		top.Exchanges[1] != (ExchangeSpec{Name: DeadLetterExchange, Kind: ExchangeDirect}) {
+		t.Errorf("Expected the events and dead letter exchanges once, got %+v", top.Exchanges)
+	}
+
+	queues := map[string]amqp.Table{}
+	for _, q := range top.Queues {
+		queues[q.Name] = q.Arguments
+	}
+	for _, q := range []string{"entities", "orders"} {
+		for _, name := range []string{q, DeadLetterQueue(q), QuarantineQueue(q), RetryQueue(q, time.Second), RetryQueue(q, 2*time.Second)} {
+			if _, ok := queues[name]; !ok {
+				t.Errorf("Expected queue %s, got %+v", name, top.Queues)
+			}
+		}
+		if queues[q]["x-dead-letter-routing-key"] != q {
+			t.Errorf("Expected %s to dead-letter to its own dead letter queue, got %v", q, queues[q])
+		}
+	}
+	if len(queues) != 10 {
+		t.Errorf("Expected 10 queues, got %d", len(queues))
+	}
+
+	bindings := map[BindingSpec]bool{}
+	for _, b := range top.Bindings {
+		bindings[b] = true
+	}
+	for _, b := range []BindingSpec{
+		{Queue: "entities", Exchange: "events", Key: "orders"},
+		{Queue: "entities", Exchange: "events", Key: "profiles"},
+		{Queue: "orders", Exchange: "events", Key: "orders"},
+		{Queue: DeadLetterQueue("orders"), Exchange: DeadLetterExchange, Key: "orders"},
+	} {
+		if !bindings[b] {
+			t.Errorf("Expected binding %+v, got %+v", b, top.Bindings)
+		}
+	}
+}
+
+func Test_ApplyTopology(t *testing.T) {
+	conn := newStubConnection()
+	r := NewRabbitMQ(Config{Dialer: (&stubDialer{conns: []*stubConnection{conn}}).Dial})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	rt := NewRouter()
+	rt.Handle(&schemas.Order{}, nil)
+	r.Register("entity", rt)
+
+	diffs, err := r.DiffTopology(ctx)
+	if err != nil {
+		t.Fatalf("Failed to diff %s", err)
+	}
+	if n := len(r.Topology().Exchanges) + len(r.Topology().Queues); len(diffs) != n {
+		t.Errorf("Expected everything to be missing, got %+v", diffs)
+	}
+	for _, d := range diffs {
+		if d.Problem != "missing" {
+			t.Errorf("Expected %s to be missing, got %s", d.Name, d.Problem)
+		}
+	}
+
+	if err := r.ApplyTopology(ctx); err != nil {
+		t.Fatalf("Failed to apply %s", err)
+	}
+	if diffs, err := r.DiffTopology(ctx); err != nil || len(diffs) != 0 {
+		t.Errorf("Expected no difference once applied, got %+v, %v", diffs, err)
+	}
+	conn.mu.Lock()
+	if len(conn.bindings["events"]) != 1 || len(conn.bindings[DeadLetterExchange]) != 1 {
+		t.Errorf("Expected the queues to be bound, got %v", conn.bindings)
+	}
+	// declared before dead-lettering
+	conn.args["entity"] = nil
+	conn.mu.Unlock()
+
+	diffs, err = r.DiffTopology(ctx)
+	if err != nil {
+		t.Fatalf("Failed to diff %s", err)
+	}
+	if len(diffs) != 1 || diffs[0].Kind != "queue" || diffs[0].Name != "entity" || !strings.HasPrefix(diffs[0].Problem, "differs") {
+		t.Errorf("Expected the entity queue to differ, got %+v", diffs)
+	}
+}
@@ -0,0 +1,572 @@
+package returns
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+	"github.com/ponty96/simple-web-app/internal/events"
+	"github.com/ponty96/simple-web-app/internal/orders"
+	"github.com/ponty96/simple-web-app/internal/outbox"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrNotReturnable     = errors.New("order is not returnable")
+	ErrInvalidItems      = errors.New("invalid return items")
+	ErrInvalidTransition = errors.New("invalid return status transition")
+)
+
+// transitions lists the statuses a return can move to from a given status.
+var transitions = map[db.ReturnStatus][]db.ReturnStatus{
+	db.ReturnStatusRequested: {db.ReturnStatusApproved, db.ReturnStatusRejected},
+	db.ReturnStatusApproved:  {db.ReturnStatusReceived},
+	db.ReturnStatusReceived:  {db.ReturnStatusInspected},
+}
+
+// returnableStatuses are the order statuses a return can be requested for.
+var returnableStatuses = map[db.OrderStatus]bool{
+	db.OrderStatusDelivered:         true,
+	db.OrderStatusPartiallyReturned: true,
+}
+
+type Processor interface {
+	RequestReturn(context.Context, string, Request) (*Return, error)
+	GetReturn(context.Context, string) (*Return, error)
+	ListOrderReturns(context.Context, string) ([]Return, error)
+	Approve(context.Context, string) (*Return, error)
+	Reject(context.Context, string, string) (*Return, error)
+	Receive(context.Context, string) (*Return, error)
+	Inspect(context.Context, string, []InspectedItem) (*Return, error)
+}
+
+type processor struct {
+	db      db.Conn
+	queries *db.Queries
+}
+
+func NewProcessor(d db.Conn) *processor {
+	return &processor{
+		db:      d,
+		queries: db.New(d),
+	}
+}
+
+// Represents a return request (RMA) against an order
+type Return struct {
+	ReturnID        string       `json:"return_id"`
+	OrderID         string       `json:"order_id"`
+	Status          string       `json:"status"`
+	Reason          string       `json:"reason"`
+	RejectionReason *string      `json:"rejection_reason,omitempty"`
+	RefundAmount    float64      `json:"refund_amount"`
+	Items           []ReturnItem `json:"items"`
+}
+
+// Represents a single order item within a return
+type ReturnItem struct {
+	OrderItemID      string  `json:"order_item_id"`
+	Quantity         int32   `json:"quantity"`
+	AcceptedQuantity *int32  `json:"accepted_quantity,omitempty"`
+	RefundAmount     float64 `json:"refund_amount"`
+}
+
+// Represents the payload of a customer's return request
+type Request struct {
+	Reason string        `json:"reason"`
+	Items  []RequestItem `json:"items"`
+}
+
+type RequestItem struct {
+	OrderItemID string `json:"order_item_id"`
+	Quantity    int32  `json:"quantity"`
+}
+
+// Represents the outcome of inspecting a returned order item. Items that are
+// not listed on inspection are accepted in full.
+type InspectedItem struct {
+	OrderItemID      string `json:"order_item_id"`
+	AcceptedQuantity int32  `json:"accepted_quantity"`
+}
+
+func (p *processor) RequestReturn(ctx context.Context, orderID string, req Request) (*Return, error) {
+	var oID pgtype.UUID
+	if err := oID.Scan(orderID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	if len(req.Items) == 0 {
+		return nil, errors.Wrap(ErrInvalidItems, "at least one item is required")
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	order, err := q.GetOrderForUpdate(ctx, &db.GetOrderForUpdateParams{ID: oID, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch order")
+	}
+
+	if !returnableStatuses[order.Status] {
+		return nil, errors.Wrapf(ErrNotReturnable, "order is %s", order.Status)
+	}
+
+	orderItems, err := q.ListOrderItems(ctx, &db.ListOrderItemsParams{OrderID: oID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order items")
+	}
+
+	returned, err := q.ListReturnedQuantities(ctx, &db.ListReturnedQuantitiesParams{OrderID: oID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch returned quantities")
+	}
+
+	available := make(map[string]int32)
+	prices := make(map[string]float64)
+	for _, item := range orderItems {
+		price, _ := item.Price.Float64Value()
+		available[item.ID.String()] = item.Quantity
+		prices[item.ID.String()] = price.Float64
+	}
+	// units refused at inspection go back to the customer and can be
+	// returned again
+	for _, r := range returned {
+		available[r.OrderItemID.String()] -= r.ReservedQuantity
+	}
+
+	var refund float64
+	for _, item := range req.Items {
+		left, ok := available[item.OrderItemID]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidItems, "%s is not an item of order %s", item.OrderItemID, orderID)
+		}
+		if item.Quantity <= 0 || item.Quantity > left {
+			return nil, errors.Wrapf(ErrInvalidItems, "quantity for %s must be between 1 and %d", item.OrderItemID, left)
+		}
+		available[item.OrderItemID] -= item.Quantity
+		refund += refundAmount(prices[item.OrderItemID], item.Quantity)
+	}
+
+	refundNumeric, err := toNumeric(refund)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := q.CreateReturn(ctx, &db.CreateReturnParams{
+		OrderID:      oID,
+		Reason:       req.Reason,
+		RefundAmount: refundNumeric,
+		TenantID:     tenantID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create return")
+	}
+
+	for _, item := range req.Items {
+		var itemID pgtype.UUID
+		if err := itemID.Scan(item.OrderItemID); err != nil {
+			return nil, errors.Wrap(err, "failed to parse UUID")
+		}
+
+		itemRefund, err := toNumeric(refundAmount(prices[item.OrderItemID], item.Quantity))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := q.CreateReturnItem(ctx, &db.CreateReturnItemParams{
+			ReturnID:     ret.ID,
+			OrderItemID:  itemID,
+			Quantity:     item.Quantity,
+			RefundAmount: itemRefund,
+			TenantID:     tenantID,
+		}); err != nil {
+			return nil, errors.Wrap(err, "failed to create return item")
+		}
+	}
+
+	r, err := loadReturn(ctx, q, ret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := outbox.Enqueue(ctx, q, tenantID, order.ID.String(), returnEvent("requested", order, r)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to commit return")
+	}
+
+	return r, nil
+}
+
+func (p *processor) GetReturn(ctx context.Context, ID string) (*Return, error) {
+	var id pgtype.UUID
+	if err := id.Scan(ID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	ret, err := q.GetReturn(ctx, &db.GetReturnParams{ID: id, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch return")
+	}
+
+	return loadReturn(ctx, q, ret)
+}
+
+func (p *processor) ListOrderReturns(ctx context.Context, orderID string) ([]Return, error) {
+	var oID pgtype.UUID
+	if err := oID.Scan(orderID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	rets, err := q.ListOrderReturns(ctx, &db.ListOrderReturnsParams{OrderID: oID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch returns")
+	}
+
+	rs := []Return{}
+	for _, ret := range rets {
+		r, err := loadReturn(ctx, q, ret)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, *r)
+	}
+
+	return rs, nil
+}
+
+func (p *processor) Approve(ctx context.Context, ID string) (*Return, error) {
+	return p.transition(ctx, ID, db.ReturnStatusApproved, func(q *db.Queries, ret *db.Return) (*db.Return, error) {
+		return q.UpdateReturnStatus(ctx, &db.UpdateReturnStatusParams{ID: ret.ID, Status: db.ReturnStatusApproved, TenantID: ret.TenantID})
+	})
+}
+
+func (p *processor) Reject(ctx context.Context, ID string, reason string) (*Return, error) {
+	return p.transition(ctx, ID, db.ReturnStatusRejected, func(q *db.Queries, ret *db.Return) (*db.Return, error) {
+		return q.RejectReturn(ctx, &db.RejectReturnParams{
+			ID:              ret.ID,
+			RejectionReason: pgtype.Text{String: reason, Valid: true},
+			TenantID:        ret.TenantID,
+		})
+	})
+}
+
+func (p *processor) Receive(ctx context.Context, ID string) (*Return, error) {
+	return p.transition(ctx, ID, db.ReturnStatusReceived, func(q *db.Queries, ret *db.Return) (*db.Return, error) {
+		return q.UpdateReturnStatus(ctx, &db.UpdateReturnStatusParams{ID: ret.ID, Status: db.ReturnStatusReceived, TenantID: ret.TenantID})
+	})
+}
+
+// Inspect records how much of each returned item was accepted, finalises the
+// refund amount and moves the order to returned or partially_returned.
+func (p *processor) Inspect(ctx context.Context, ID string, inspected []InspectedItem) (*Return, error) {
+	accepted := make(map[string]int32)
+	for _, item := range inspected {
+		accepted[item.OrderItemID] = item.AcceptedQuantity
+	}
+
+	return p.transition(ctx, ID, db.ReturnStatusInspected, func(q *db.Queries, ret *db.Return) (*db.Return, error) {
+		items, err := q.ListReturnItems(ctx, &db.ListReturnItemsParams{ReturnID: ret.ID, TenantID: ret.TenantID})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch return items")
+		}
+
+		orderItems, err := q.ListOrderItems(ctx, &db.ListOrderItemsParams{OrderID: ret.OrderID, TenantID: ret.TenantID})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch order items")
+		}
+
+		prices := make(map[string]float64)
+		for _, item := range orderItems {
+			price, _ := item.Price.Float64Value()
+			prices[item.ID.String()] = price.Float64
+		}
+
+		returned := make(map[string]bool)
+		for _, item := range items {
+			returned[item.OrderItemID.String()] = true
+		}
+		for id := range accepted {
+			if !returned[id] {
+				return nil, errors.Wrapf(ErrInvalidItems, "%s is not part of this return", id)
+			}
+		}
+
+		var refund float64
+		for _, item := range items {
+			qty, ok := accepted[item.OrderItemID.String()]
+			if !ok {
+				qty = item.Quantity
+			}
+			if qty < 0 || qty > item.Quantity {
+				return nil, errors.Wrapf(ErrInvalidItems, "accepted quantity for %s must be between 0 and %d", item.OrderItemID.String(), item.Quantity)
+			}
+
+			itemRefund := refundAmount(prices[item.OrderItemID.String()], qty)
+			refund += itemRefund
+
+			itemRefundNumeric, err := toNumeric(itemRefund)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := q.UpdateReturnItemInspection(ctx, &db.UpdateReturnItemInspectionParams{
+				ID:               item.ID,
+				AcceptedQuantity: pgtype.Int4{Int32: qty, Valid: true},
+				RefundAmount:     itemRefundNumeric,
+				TenantID:         ret.TenantID,
+			}); err != nil {
+				return nil, errors.Wrap(err, "failed to update return item")
+			}
+		}
+
+		refundNumeric, err := toNumeric(refund)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := q.InspectReturn(ctx, &db.InspectReturnParams{ID: ret.ID, RefundAmount: refundNumeric, TenantID: ret.TenantID})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to update return")
+		}
+
+		if err := updateOrderReturnStatus(ctx, q, ret.TenantID, ret.OrderID, orderItems); err != nil {
+			return nil, err
+		}
+
+		return updated, nil
+	})
+}
+
+// transition locks the return, checks that moving it to the given status is
+// allowed and applies the update in a single transaction.
+func (p *processor) transition(ctx context.Context, ID string, to db.ReturnStatus, apply func(*db.Queries, *db.Return) (*db.Return, error)) (*Return, error) {
+	var id pgtype.UUID
+	if err := id.Scan(ID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	tenantID, err := tenant.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTenant(ctx, p.db, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	q := p.queries.WithTx(tx)
+
+	ret, err := q.GetReturnForUpdate(ctx, &db.GetReturnForUpdateParams{ID: id, TenantID: tenantID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch return")
+	}
+
+	if !canTransition(ret.Status, to) {
+		return nil, errors.Wrapf(ErrInvalidTransition, "cannot move return from %s to %s", ret.Status, to)
+	}
+
+	updated, err := apply(q, ret)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := loadReturn(ctx, q, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := q.GetOrder(ctx, &db.GetOrderParams{ID: updated.OrderID, TenantID: tenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch order")
+	}
+
+	if err := outbox.Enqueue(ctx, q, tenantID, order.ID.String(), returnEvent(string(to), order, r)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to commit return")
+	}
+
+	return r, nil
+}
+
+// returnEvent builds the message enqueued on every change to a return.
+func returnEvent(eventType string, order *db.Order, r *Return) *events.ReturnEvent {
+	e := &events.ReturnEvent{
+		EventType:    eventType,
+		ReturnId:     r.ReturnID,
+		OrderId:      r.OrderID,
+		UserId:       order.UserID.String(),
+		ReturnStatus: r.Status,
+		OrderStatus:  string(order.Status),
+		Reason:       r.Reason,
+		RefundAmount: r.RefundAmount,
+		OccurredAt:   timestamppb.Now(),
+	}
+	if r.RejectionReason != nil {
+		e.RejectionReason = *r.RejectionReason
+	}
+	for _, item := range r.Items {
+		i := &events.ReturnItem{
+			OrderItemId:  item.OrderItemID,
+			Quantity:     item.Quantity,
+			RefundAmount: item.RefundAmount,
+		}
+		if item.AcceptedQuantity != nil {
+			i.AcceptedQuantity = *item.AcceptedQuantity
+		}
+		e.Items = append(e.Items, i)
+	}
+
+	return e
+}
+
+// updateOrderReturnStatus sets the order to returned when every unit of every
+// item was accepted back, and partially_returned when only some were. The
+// order keeps its current status while no unit has been accepted.
+func updateOrderReturnStatus(ctx context.Context, q *db.Queries, tenantID pgtype.UUID, orderID pgtype.UUID, orderItems []*db.OrderItem) error {
+	returned, err := q.ListReturnedQuantities(ctx, &db.ListReturnedQuantitiesParams{OrderID: orderID, TenantID: tenantID})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch returned quantities")
+	}
+
+	accepted := make(map[string]int32)
+	var total int32
+	for _, r := range returned {
+		accepted[r.OrderItemID.String()] = r.AcceptedQuantity
+		total += r.AcceptedQuantity
+	}
+	if total == 0 {
+		return nil
+	}
+
+	status := db.OrderStatusReturned
+	for _, item := range orderItems {
+		if accepted[item.ID.String()] < item.Quantity {
+			status = db.OrderStatusPartiallyReturned
+			break
+		}
+	}
+
+	if _, err := orders.ChangeStatus(ctx, q, tenantID, orderID, status, "return inspected"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func loadReturn(ctx context.Context, q *db.Queries, ret *db.Return) (*Return, error) {
+	items, err := q.ListReturnItems(ctx, &db.ListReturnItemsParams{ReturnID: ret.ID, TenantID: ret.TenantID})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to fetch return items for %v", ret.ID))
+	}
+
+	refund, _ := ret.RefundAmount.Float64Value()
+
+	r := &Return{
+		ReturnID:     ret.ID.String(),
+		OrderID:      ret.OrderID.String(),
+		Status:       string(ret.Status),
+		Reason:       ret.Reason,
+		RefundAmount: refund.Float64,
+		Items:        []ReturnItem{},
+	}
+	if ret.RejectionReason.Valid {
+		r.RejectionReason = &ret.RejectionReason.String
+	}
+
+	for _, item := range items {
+		itemRefund, _ := item.RefundAmount.Float64Value()
+		i := ReturnItem{
+			OrderItemID:  item.OrderItemID.String(),
+			Quantity:     item.Quantity,
+			RefundAmount: itemRefund.Float64,
+		}
+		if item.AcceptedQuantity.Valid {
+			i.AcceptedQuantity = &item.AcceptedQuantity.Int32
+		}
+		r.Items = append(r.Items, i)
+	}
+
+	return r, nil
+}
+
+func canTransition(from, to db.ReturnStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// refundAmount computes quantity * price rounded to the cent.
+func refundAmount(price float64, quantity int32) float64 {
+	return math.Round(price*100*float64(quantity)) / 100
+}
+
+func toNumeric(f float64) (pgtype.Numeric, error) {
+	var n pgtype.Numeric
+	if err := n.Scan(fmt.Sprintf("%.2f", f)); err != nil {
+		return n, errors.Wrap(err, "failed to convert amount to numeric")
+	}
+	return n, nil
+}
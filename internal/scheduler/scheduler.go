@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/db"
+)
+
+var ErrDuplicateJob = errors.New("job is already registered")
+
+// Number of past runs returned per job by Jobs
+const runHistory = 10
+
+// Represents a periodic job. Schedule is a standard five field cron expression
+// or a descriptor such as "@every 1m" or "@daily".
+type Job struct {
+	Name     string
+	Schedule string
+	Run      func(context.Context) error
+}
+
+type Scheduler interface {
+	Jobs(context.Context) ([]JobStatus, error)
+}
+
+type entry struct {
+	job      Job
+	schedule cron.Schedule
+}
+
+// scheduler runs the registered jobs on every replica, but a job only runs if
+// the replica gets its advisory lock, so each run happens on one replica.
+type scheduler struct {
+	db       db.Conn
+	queries  *db.Queries
+	cron     *cron.Cron
+	jobs     []entry
+	instance string
+}
+
+func NewScheduler(d db.Conn) *scheduler {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+
+	return &scheduler{
+		db:      d,
+		queries: db.New(d),
+		// a run still going when the job is next due is skipped rather than
+		// queued behind it
+		cron:     cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger))),
+		instance: instance,
+	}
+}
+
+// Represents a job and its most recent runs, newest first
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	Runs     []Run     `json:"runs"`
+}
+
+// Represents a single run of a job
+type Run struct {
+	ID         int64      `json:"id"`
+	Status     string     `json:"status"`
+	Instance   string     `json:"instance"`
+	Error      *string    `json:"error,omitempty"`
+	DurationMs *int64     `json:"duration_ms,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Register adds a job to the scheduler. Jobs must be registered before Start.
+func (s *scheduler) Register(j Job) error {
+	for _, e := range s.jobs {
+		if e.job.Name == j.Name {
+			return errors.Wrap(ErrDuplicateJob, j.Name)
+		}
+	}
+
+	schedule, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return errors.Wrapf(err, "invalid schedule %q for job %s", j.Schedule, j.Name)
+	}
+
+	s.jobs = append(s.jobs, entry{job: j, schedule: schedule})
+	return nil
+}
+
+// Start runs the registered jobs on their schedules until ctx is cancelled.
+// It does not block.
+func (s *scheduler) Start(ctx context.Context) {
+	for _, e := range s.jobs {
+		j := e.job
+		s.cron.Schedule(e.schedule, cron.FuncJob(func() {
+			if _, err := s.RunJob(ctx, j); err != nil {
+				log.Errorf("scheduler: job %s failed: %v", j.Name, err)
+			}
+		}))
+	}
+
+	s.cron.Start()
+
+	go func() {
+		<-ctx.Done()
+		// wait for running jobs to return
+		<-s.cron.Stop().Done()
+	}()
+}
+
+// Stop stops scheduling jobs and waits for the running ones to return, or for
+// ctx to be done.
+func (s *scheduler) Stop(ctx context.Context) error {
+	select {
+	case <-s.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "scheduler: jobs still running")
+	}
+}
+
+// RunJob runs j now if no other replica is running it and records the run.
+// It reports whether the job ran; the returned error is the job's own.
+func (s *scheduler) RunJob(ctx context.Context, j Job) (bool, error) {
+	// the lock is held by this transaction until it ends, which also releases
+	// it if the connection is lost mid run
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	locked, err := s.queries.WithTx(tx).TryJobLock(ctx, j.Name)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to acquire job lock")
+	}
+	if !locked {
+		log.Debugf("scheduler: job %s is running elsewhere", j.Name)
+		return false, nil
+	}
+
+	// recorded outside the lock's transaction so the run is visible while it's
+	// in progress
+	run, err := s.queries.StartJobRun(ctx, &db.StartJobRunParams{JobName: j.Name, Instance: s.instance})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record job run")
+	}
+
+	start := time.Now()
+	jobErr := runSafely(ctx, j)
+
+	params := &db.FinishJobRunParams{
+		ID:         run.ID,
+		Status:     db.JobRunStatusSucceeded,
+		DurationMs: pgtype.Int8{Int64: time.Since(start).Milliseconds(), Valid: true},
+	}
+	if jobErr != nil {
+		params.Status = db.JobRunStatusFailed
+		params.Error = pgtype.Text{String: jobErr.Error(), Valid: true}
+	}
+
+	if _, err := s.queries.FinishJobRun(ctx, params); err != nil {
+		log.Errorf("scheduler: failed to record the end of job %s: %v", j.Name, err)
+	}
+
+	return true, jobErr
+}
+
+// runSafely turns a panicking job into a failed run
+func runSafely(ctx context.Context, j Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+// Jobs returns the registered jobs with their most recent runs on any replica.
+func (s *scheduler) Jobs(ctx context.Context) ([]JobStatus, error) {
+	statuses := make([]JobStatus, 0, len(s.jobs))
+
+	for _, e := range s.jobs {
+		runs, err := s.queries.ListJobRuns(ctx, &db.ListJobRunsParams{JobName: e.job.Name, Limit: runHistory})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch runs of job %s", e.job.Name)
+		}
+
+		status := JobStatus{
+			Name:     e.job.Name,
+			Schedule: e.job.Schedule,
+			NextRun:  e.schedule.Next(time.Now()),
+			Runs:     make([]Run, 0, len(runs)),
+		}
+		for _, r := range runs {
+			status.Runs = append(status.Runs, toRun(r))
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// PruneRuns deletes the runs that finished before the retention period and
+// returns how many were deleted.
+func (s *scheduler) PruneRuns(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := s.queries.DeleteJobRunsBefore(ctx, pgtype.Timestamptz{Time: time.Now().Add(-retention), Valid: true})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to prune job runs")
+	}
+	return n, nil
+}
+
+func toRun(r *db.JobsRun) Run {
+	run := Run{
+		ID:        r.ID,
+		Status:    string(r.Status),
+		Instance:  r.Instance,
+		StartedAt: r.StartedAt.Time,
+	}
+	if r.Error.Valid {
+		run.Error = &r.Error.String
+	}
+	if r.DurationMs.Valid {
+		run.DurationMs = &r.DurationMs.Int64
+	}
+	if r.FinishedAt.Valid {
+		run.FinishedAt = &r.FinishedAt.Time
+	}
+	return run
+}
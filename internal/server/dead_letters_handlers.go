@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+)
+
+func (s *server) listConsumers(w http.ResponseWriter, r *http.Request) {
+	httpWriteJSON(w, Response{
+		Message: "List Consumers",
+		Code:    http.StatusOK,
+		Data:    s.Config.Consumers.ConsumerStats(),
+	})
+}
+
+func (s *server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	stats, err := s.Config.DeadLetters.ListDeadLetters(ctx)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "List Dead Letters",
+		Code:    http.StatusOK,
+		Data:    stats,
+	})
+}
+
+func (s *server) peekDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	limit, ok := readLimit(w, r, 10)
+	if !ok {
+		return
+	}
+
+	letters, err := s.Config.DeadLetters.PeekDeadLetters(ctx, mux.Vars(r)["queue"], limit)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Peek Dead Letters",
+		Code:    http.StatusOK,
+		Data:    letters,
+	})
+}
+
+func (s *server) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// 0 replays all of them
+	limit, ok := readLimit(w, r, 0)
+	if !ok {
+		return
+	}
+
+	n, err := s.Config.DeadLetters.ReplayDeadLetters(ctx, mux.Vars(r)["queue"], limit)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Dead Letters Replayed",
+		Code:    http.StatusOK,
+		Data:    map[string]int{"replayed": n},
+	})
+}
+
+func (s *server) purgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	n, err := s.Config.DeadLetters.PurgeDeadLetters(ctx, mux.Vars(r)["queue"])
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Dead Letters Purged",
+		Code:    http.StatusOK,
+		Data:    map[string]int{"purged": n},
+	})
+}
+
+// readLimit reads the limit query parameter, writing a 422 when it's invalid
+func readLimit(w http.ResponseWriter, r *http.Request, def int) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return def, true
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"limit": "must be a positive number"},
+		})
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rabbitmq.ErrUnknownQueue):
+		httpWriteJSON(w, Response{
+			Message: "not found",
+			Code:    http.StatusNotFound,
+		})
+	default:
+		log.Errorf("Failed to process dead letters %v", err)
+		httpWriteJSON(w, Response{
+			Message: "could not perform action",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+)
+
+// ---- rabbitmq.DeadLetters Mock for Testing --- //
+type DeadLettersMock struct {
+	Letters  []rabbitmq.DeadLetter
+	Replayed int
+	Limit    int
+}
+
+func (m *DeadLettersMock) ListDeadLetters(ctx context.Context) ([]rabbitmq.DeadLetterStats, error) {
+	return []rabbitmq.DeadLetterStats{{Queue: "entity", DeadLetterQueue: "entity.dead-letter", Messages: len(m.Letters)}}, nil
+}
+
+func (m *DeadLettersMock) PeekDeadLetters(ctx context.Context, queue string, limit int) ([]rabbitmq.DeadLetter, error) {
+	if queue != "entity" {
+		return nil, rabbitmq.ErrUnknownQueue
+	}
+	m.Limit = limit
+	return m.Letters, nil
+}
+
+func (m *DeadLettersMock) ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error) {
+	if queue != "entity" {
+		return 0, rabbitmq.ErrUnknownQueue
+	}
+	m.Limit = limit
+	m.Replayed = len(m.Letters)
+	m.Letters = nil
+	return m.Replayed, nil
+}
+
+func (m *DeadLettersMock) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	if queue != "entity" {
+		return 0, rabbitmq.ErrUnknownQueue
+	}
+	n := len(m.Letters)
+	m.Letters = nil
+	return n, nil
+}
+
+// --- End of rabbitmq.DeadLetters Mock ---- //
+
+// ---- rabbitmq.Consumers Mock for Testing --- //
+type ConsumersMock struct{}
+
+func (m *ConsumersMock) ConsumerStats() []rabbitmq.ConsumerStats {
+	return []rabbitmq.ConsumerStats{{Queue: "entity", Workers: 4, Prefetch: 4, InFlight: 2, Processed: 10, Acked: 10}}
+}
+
+// --- End of rabbitmq.Consumers Mock ---- //
+
+func Test_ListConsumers(t *testing.T) {
+	r := NewHTTP(&Config{Host: "localhost", Port: 4050, Consumers: &ConsumersMock{}, AdminToken: "t0ken"}).routes()
+
+	req := httptest.NewRequest("GET", "/admin/consumers", nil)
+	req.Header.Set(headerAdminToken, "t0ken")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected Ok status code, got %d", w.Code)
+	}
+
+	var resp struct {
+		Data []rabbitmq.ConsumerStats `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].InFlight != 2 || resp.Data[0].Processed != 10 {
+		t.Errorf("Expected the consumer stats, got %+v", resp.Data)
+	}
+}
+
+func Test_DeadLetters(t *testing.T) {
+	dl := &DeadLettersMock{Letters: []rabbitmq.DeadLetter{{Reason: "invalid order", Message: json.RawMessage(`{"orderId":"order-1"}`)}}}
+	r := NewHTTP(&Config{Host: "localhost", Port: 4050, DeadLetters: dl, AdminToken: "t0ken"}).routes()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(headerAdminToken, "t0ken")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/admin/dead-letters/entity?limit=5")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected Ok status code, got %d", w.Code)
+	}
+	var resp struct {
+		Data []rabbitmq.DeadLetter `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].Reason != "invalid order" || dl.Limit != 5 {
+		t.Errorf("Expected the dead letter with a limit of 5, got %+v (limit %d)", resp.Data, dl.Limit)
+	}
+
+	if w := do("GET", "/admin/dead-letters/entity?limit=-1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a negative limit, got %d", w.Code)
+	}
+
+	if w := do("GET", "/admin/dead-letters/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown queue, got %d", w.Code)
+	}
+
+	if w := do("POST", "/admin/dead-letters/entity/replay"); w.Code != http.StatusOK || dl.Replayed != 1 || dl.Limit != 0 {
+		t.Errorf("Expected all dead letters to be replayed, got status %d, %d replayed", w.Code, dl.Replayed)
+	}
+
+	if w := do("DELETE", "/admin/dead-letters/entity"); w.Code != http.StatusOK {
+		t.Errorf("Expected Ok status code for a purge, got %d", w.Code)
+	}
+}
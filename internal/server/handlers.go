@@ -13,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/ponty96/my-proto-schemas/output/schemas"
 	"github.com/ponty96/simple-web-app/internal/orders"
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
 )
 
 func (s *server) orderWebhookHandler(w http.ResponseWriter, r *http.Request) {
@@ -91,14 +92,18 @@ func (s *server) orderWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		OrderStatus: *order.Status,
 		TotalAmount: *order.TotalAmount,
 		ShippingAddress: &schemas.Address{
-			City:   order.ShippingAddress.City,
-			State:  order.ShippingAddress.State,
-			Street: order.ShippingAddress.Line1,
+			City:    order.ShippingAddress.City,
+			State:   order.ShippingAddress.State,
+			Street:  order.ShippingAddress.Line1,
+			Zip:     order.ShippingAddress.PostalCode,
+			Country: order.ShippingAddress.Country,
 		},
 		BillingAddress: &schemas.Address{
-			City:   order.BillingAddress.City,
-			State:  order.BillingAddress.State,
-			Street: order.BillingAddress.Line1,
+			City:    order.BillingAddress.City,
+			State:   order.BillingAddress.State,
+			Street:  order.BillingAddress.Line1,
+			Zip:     order.BillingAddress.PostalCode,
+			Country: order.BillingAddress.Country,
 		},
 	}
 
@@ -106,12 +111,9 @@ func (s *server) orderWebhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	// }
 
-	if err = s.Config.MQ.Publish(ctx, &o); err != nil {
-		log.Errorf("failed to publish %v", err)
-		httpWriteJSON(w, Response{
-			Message: "failed to process order",
-			Code:    http.StatusInternalServerError,
-		})
+	// only report the order as created once the broker has queued it
+	if err = s.Config.MQ.Publish(ctx, &o, rabbitmq.Mandatory()); err != nil {
+		writePublishError(w, err)
 	} else {
 		httpWriteJSON(w, Response{
 			Message: "Order Created",
@@ -153,3 +155,168 @@ func (s *server) listUserOrders(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+func (s *server) listOrderEvents(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	events, err := s.Config.Processor.ListOrderEvents(ctx, mux.Vars(r)["order_id"])
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "List Order Events",
+		Code:    http.StatusOK,
+		Data:    events,
+	})
+}
+
+// getOrderAt returns the order as it was at the `at` query parameter (RFC 3339),
+// defaulting to now.
+func (s *server) getOrderAt(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteJSON(w, Response{
+				Message: "validation failed",
+				Code:    http.StatusUnprocessableEntity,
+				Errs:    map[string]string{"at": "must be an RFC 3339 timestamp"},
+			})
+			return
+		}
+		at = t
+	}
+
+	order, err := s.Config.Processor.OrderAt(ctx, mux.Vars(r)["order_id"], at)
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Get Order Snapshot",
+		Code:    http.StatusOK,
+		Data:    order,
+	})
+}
+
+func (s *server) updateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Status == "" {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"status": "is required"},
+		})
+		return
+	}
+
+	if err := s.Config.Processor.UpdateStatus(ctx, mux.Vars(r)["order_id"], req.Status, req.Reason); err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Order Status Updated",
+		Code:    http.StatusOK,
+	})
+}
+
+func (s *server) correctOrderAddress(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var address orders.Address
+	if !readJSON(w, r, &address) {
+		return
+	}
+
+	v := make(map[string]string)
+	if address.Line1 == "" {
+		v["line1"] = "is required"
+	}
+	if address.City == "" {
+		v["city"] = "is required"
+	}
+	if address.Country == "" {
+		v["country"] = "is required"
+	}
+
+	if len(v) > 0 {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    v,
+		})
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := s.Config.Processor.CorrectAddress(ctx, vars["order_id"], vars["kind"], address); err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Order Address Corrected",
+		Code:    http.StatusOK,
+	})
+}
+
+func writePublishError(w http.ResponseWriter, err error) {
+	log.Errorf("failed to publish %v", err)
+	switch {
+	case errors.Is(err, rabbitmq.ErrUnroutable), errors.Is(err, rabbitmq.ErrNacked):
+		httpWriteJSON(w, Response{
+			Message: "order was not accepted by the broker",
+			Code:    http.StatusBadGateway,
+		})
+	case errors.Is(err, rabbitmq.ErrNotConnected):
+		httpWriteJSON(w, Response{
+			Message: "service unavailable",
+			Code:    http.StatusServiceUnavailable,
+		})
+	default:
+		httpWriteJSON(w, Response{
+			Message: "failed to process order",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
+
+func writeOrderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, orders.ErrNotFound):
+		httpWriteJSON(w, Response{
+			Message: "not found",
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, orders.ErrInvalidStatus), errors.Is(err, orders.ErrInvalidTransition), errors.Is(err, orders.ErrInvalidKind):
+		httpWriteJSON(w, Response{
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		})
+	default:
+		log.Errorf("Failed to process order %v", err)
+		httpWriteJSON(w, Response{
+			Message: "could not perform action",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
@@ -10,15 +10,24 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/ponty96/my-proto-schemas/output/schemas"
 	"google.golang.org/protobuf/proto"
+
+	"github.com/ponty96/simple-web-app/internal/correlation"
+	"github.com/ponty96/simple-web-app/internal/fraud"
+	"github.com/ponty96/simple-web-app/internal/orders"
+	"github.com/ponty96/simple-web-app/internal/rabbitmq"
 )
 
 // ---- rabbitmq.MQ Mock for Testing --- //
 type MQMock struct {
 	PublishedEvent []byte
+	Mandatory      bool
+	CorrelationID  string
 	Closed         string
+	Err            error
 }
 
 func (m *MQMock) Close() error {
@@ -26,7 +35,13 @@ func (m *MQMock) Close() error {
 	return nil
 }
 
-func (m *MQMock) Publish(ctx context.Context, o proto.Message) error {
+func (m *MQMock) Publish(ctx context.Context, o proto.Message, opts ...rabbitmq.PublishOption) error {
+	m.Mandatory = len(opts) > 0
+	m.CorrelationID, _ = correlation.FromContext(ctx)
+	if m.Err != nil {
+		return m.Err
+	}
+
 	b, err := proto.Marshal(o)
 
 	if err != nil {
@@ -167,6 +182,7 @@ func Test_OrderWebhookSuccessRequest(t *testing.T) {
     }`
 
 	req := httptest.NewRequest("POST", "/webhooks/orders", strings.NewReader(payload))
+	req = req.WithContext(correlation.NewContext(req.Context(), "request-1"))
 	w := httptest.NewRecorder()
 
 	s.orderWebhookHandler(w, req)
@@ -195,4 +211,117 @@ func Test_OrderWebhookSuccessRequest(t *testing.T) {
 	if err := proto.Unmarshal(mq.PublishedEvent, orderEvent); err != nil {
 		t.Errorf("failed to decode %s", err)
 	}
+
+	if !mq.Mandatory {
+		t.Error("Expected the order to be published as mandatory")
+	}
+
+	if mq.CorrelationID != "request-1" {
+		t.Errorf("Expected the order to be correlated with the request, got %q", mq.CorrelationID)
+	}
+}
+
+func Test_OrderWebhookUnroutable(t *testing.T) {
+	mq := &MQMock{Err: errors.Wrap(rabbitmq.ErrUnroutable, "NO_ROUTE (312)")}
+	cfg := &Config{Host: "localhost", Port: 4050, MQ: mq}
+
+	s := NewHTTP(cfg)
+
+	payload := `{
+        "order_id": "123e4567-e89b-12d3-a456-426614174000",
+        "user_id": "123e4567-e89b-12d3-a456-426614174001",
+        "shipping_address": {"line1": "123 Example St", "country": "US"},
+        "billing_address": {"line1": "456 Billing Ave", "country": "US"},
+        "total_amount": 39.98,
+        "status": "PENDING"
+    }`
+
+	req := httptest.NewRequest("POST", "/webhooks/orders", strings.NewReader(payload))
+	w := httptest.NewRecorder()
+
+	s.orderWebhookHandler(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected an unroutable order to return %d, got %d", http.StatusBadGateway, w.Code)
+	}
+
+	mq.Err = rabbitmq.ErrNotConnected
+	req = httptest.NewRequest("POST", "/webhooks/orders", strings.NewReader(payload))
+	w = httptest.NewRecorder()
+
+	s.orderWebhookHandler(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d while the broker is down, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func Test_OrderSnapshotInvalidTime(t *testing.T) {
+	cfg := &Config{Host: "localhost", Port: 4050}
+	s := NewHTTP(cfg)
+
+	req := httptest.NewRequest("GET", "/orders/order-1/snapshot?at=yesterday", nil)
+	w := httptest.NewRecorder()
+
+	s.getOrderAt(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", w.Code)
+	}
+
+	var r Response
+
+	if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+		t.Fatalf("Failed to decode response %v", err)
+	}
+
+	if _, ok := r.Errs["at"]; !ok {
+		t.Error("Expected validation error for at")
+	}
+}
+
+// ---- orders.Processor Mock for Testing --- //
+type OrdersMock struct {
+	orders.Processor
+	Err error
+}
+
+func (m *OrdersMock) UpdateStatus(ctx context.Context, id string, status string, reason string) error {
+	return m.Err
+}
+
+// --- End of orders.Processor Mock ---- //
+
+func Test_UpdateOrderStatusInvalidTransition(t *testing.T) {
+	err := errors.Wrap(orders.ErrInvalidTransition, "cannot move order from delivered to pending")
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Processor: &OrdersMock{Err: err}})
+
+	req := httptest.NewRequest("PUT", "/orders/order-1/status", strings.NewReader(`{"status": "pending"}`))
+	w := httptest.NewRecorder()
+
+	s.updateOrderStatus(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", w.Code)
+	}
+}
+
+func Test_UpdateOrderStatusWorkflowStatuses(t *testing.T) {
+	// rejected before the order is looked up
+	p := orders.NewProcessor(nil, fraud.New(fraud.DefaultConfig()), nil)
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Processor: p})
+
+	for _, status := range []string{"on_hold", "pending", "partially_returned", "returned"} {
+		t.Run(status, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/orders/3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10/status", strings.NewReader(`{"status": "`+status+`"}`))
+			req = mux.SetURLVars(req, map[string]string{"order_id": "3f1c6f0e-8a57-4c4b-9d47-1f1f9a3c2b10"})
+			w := httptest.NewRecorder()
+
+			s.updateOrderStatus(w, req)
+
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Expected status 422, got %d", w.Code)
+			}
+		})
+	}
 }
@@ -1,13 +1,23 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/ponty96/simple-web-app/internal/clients"
+	"github.com/ponty96/simple-web-app/internal/correlation"
 	"github.com/ponty96/simple-web-app/internal/orders"
+	"github.com/ponty96/simple-web-app/internal/privacy"
 	"github.com/ponty96/simple-web-app/internal/rabbitmq"
+	"github.com/ponty96/simple-web-app/internal/returns"
+	"github.com/ponty96/simple-web-app/internal/scheduler"
+	"github.com/ponty96/simple-web-app/internal/tenant"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,34 +26,164 @@ type Config struct {
 	Port      int
 	MQ        rabbitmq.MQ
 	Processor orders.Processor
+	Returns   returns.Processor
+	Clients   clients.Processor
+	Reviews   orders.Reviews
+	Jobs      scheduler.Scheduler
+	Privacy   privacy.Processor
+	// Messages consumers gave up on
+	DeadLetters rabbitmq.DeadLetters
+	Consumers   rabbitmq.Consumers
+	// Token operators call the /admin endpoints with; they are disabled when
+	// it is empty
+	AdminToken string
 }
 
+// Headers a client authenticates every request with
+const (
+	headerClientID     = "X-Client-Id"
+	headerClientSecret = "X-Client-Secret"
+	headerAdminToken   = "X-Admin-Token"
+)
+
 type Response struct {
 	Message string            `json:"message"`
 	Code    int               `json:"status_code"`
 	Errs    map[string]string `json:"errs"`
-	Data    []orders.Order    `json:"data"`
+	Data    interface{}       `json:"data"`
 }
 
 type server struct {
 	Config *Config
+	http   *http.Server
 }
 
 func NewHTTP(config *Config) *server {
-	return &server{Config: config}
+	s := &server{Config: config}
+	s.http = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", config.Host, config.Port),
+		Handler: s.routes(),
+	}
+	return s
 }
 
-func (s *server) Serve() {
-	r := mux.NewRouter()
+// Serve serves HTTP until Shutdown is called, which returns nil, or it fails.
+func (s *server) Serve() error {
 	fmt.Printf("Starting HTTP Server on port %d", s.Config.Port)
+	if err := s.http.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "http server")
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for the requests being
+// served to complete, or for ctx to be done.
+func (s *server) Shutdown(ctx context.Context) error {
+	return errors.Wrap(s.http.Shutdown(ctx), "http server shutdown")
+}
+
+func (s *server) routes() *mux.Router {
+	r := mux.NewRouter()
 	// add the handler for the Order webhook here
 	// r.HandleFunc("/show-profile/{profileId}", s.showProfile).Methods("GET")
 	// r.HandleFunc("/publish-event", s.publishEventHandler).Methods("POST")
-
-	r.HandleFunc("/webhooks/orders", s.orderWebhookHandler).Methods("POST")
-	r.HandleFunc("/orders/{user_id}", s.listUserOrders).Methods("GET")
 	r.HandleFunc("/health-check", s.healthCheckHandler).Methods("GET")
-	http.ListenAndServe(fmt.Sprintf("%s:%d", s.Config.Host, s.Config.Port), r)
+	r.Use(correlate)
+
+	// operator endpoints, not scoped to a client
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(s.authenticateAdmin)
+
+	admin.HandleFunc("/jobs", s.listJobs).Methods("GET")
+	admin.HandleFunc("/consumers", s.listConsumers).Methods("GET")
+	admin.HandleFunc("/dead-letters", s.listDeadLetters).Methods("GET")
+	admin.HandleFunc("/dead-letters/{queue}", s.peekDeadLetters).Methods("GET")
+	admin.HandleFunc("/dead-letters/{queue}/replay", s.replayDeadLetters).Methods("POST")
+	admin.HandleFunc("/dead-letters/{queue}", s.purgeDeadLetters).Methods("DELETE")
+
+	// everything else is scoped to the authenticated client
+	api := r.NewRoute().Subrouter()
+	api.Use(s.authenticate)
+
+	api.HandleFunc("/webhooks/orders", s.orderWebhookHandler).Methods("POST")
+	api.HandleFunc("/orders/{user_id}", s.listUserOrders).Methods("GET")
+	api.HandleFunc("/orders/{order_id}/events", s.listOrderEvents).Methods("GET")
+	api.HandleFunc("/orders/{order_id}/snapshot", s.getOrderAt).Methods("GET")
+	api.HandleFunc("/orders/{order_id}/status", s.updateOrderStatus).Methods("PATCH")
+	api.HandleFunc("/orders/{order_id}/addresses/{kind}", s.correctOrderAddress).Methods("PUT")
+	api.HandleFunc("/orders/{order_id}/returns", s.requestReturn).Methods("POST")
+	api.HandleFunc("/orders/{order_id}/returns", s.listOrderReturns).Methods("GET")
+	api.HandleFunc("/returns/{return_id}", s.getReturn).Methods("GET")
+	api.HandleFunc("/returns/{return_id}/approve", s.approveReturn).Methods("POST")
+	api.HandleFunc("/returns/{return_id}/reject", s.rejectReturn).Methods("POST")
+	api.HandleFunc("/returns/{return_id}/receive", s.receiveReturn).Methods("POST")
+	api.HandleFunc("/returns/{return_id}/inspect", s.inspectReturn).Methods("POST")
+	api.HandleFunc("/reviews", s.listReviews).Methods("GET")
+	api.HandleFunc("/reviews/{review_id}", s.getReview).Methods("GET")
+	api.HandleFunc("/reviews/{review_id}/assign", s.assignReview).Methods("POST")
+	api.HandleFunc("/reviews/{review_id}/approve", s.approveReview).Methods("POST")
+	api.HandleFunc("/reviews/{review_id}/reject", s.rejectReview).Methods("POST")
+	api.HandleFunc("/users/{user_id}/erasure", s.eraseUser).Methods("POST")
+	api.HandleFunc("/users/{user_id}/exports", s.requestExport).Methods("POST")
+	api.HandleFunc("/exports/{export_id}", s.getExport).Methods("GET")
+	api.HandleFunc("/exports/{export_id}/download", s.downloadExport).Methods("GET")
+
+	return r
+}
+
+// authenticate checks the client credentials of the request and scopes its
+// context to the client, so every order and return it touches is the client's.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.Config.Clients.Authenticate(r.Context(), r.Header.Get(headerClientID), r.Header.Get(headerClientSecret))
+		if err != nil {
+			if errors.Is(err, clients.ErrUnauthorized) {
+				httpWriteJSON(w, Response{
+					Message: "unauthorized",
+					Code:    http.StatusUnauthorized,
+				})
+				return
+			}
+
+			log.Errorf("Failed to authenticate client %v", err)
+			httpWriteJSON(w, Response{
+				Message: "could not perform action",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), id)))
+	})
+}
+
+func (s *server) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(headerAdminToken)
+		if s.Config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.AdminToken)) != 1 {
+			httpWriteJSON(w, Response{
+				Message: "unauthorized",
+				Code:    http.StatusUnauthorized,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// correlate scopes the request to its X-Request-Id, or a new one, which the
+// messages published on its behalf carry as their correlation id.
+func correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(correlation.Header)
+		if id == "" || len(id) > 128 {
+			id = correlation.NewID()
+		}
+
+		w.Header().Set(correlation.Header, id)
+		next.ServeHTTP(w, r.WithContext(correlation.NewContext(r.Context(), id)))
+	})
 }
 
 func (s *server) healthCheckHandler(w http.ResponseWriter, _ *http.Request) {
@@ -60,3 +200,28 @@ func httpWriteJSON(w http.ResponseWriter, r Response) {
 		log.Errorf("Failed to write response to client: %s", err)
 	}
 }
+
+// readJSON decodes the request body into v, writing the error response and
+// returning false when it can't.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to read request"))
+		httpWriteJSON(w, Response{
+			Message: "",
+			Code:    http.StatusInternalServerError,
+		})
+		return false
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		log.Error(errors.Wrap(err, "failed to parse json"))
+		httpWriteJSON(w, Response{
+			Message: "invalid json",
+			Code:    http.StatusUnprocessableEntity,
+		})
+		return false
+	}
+
+	return true
+}
@@ -1,11 +1,41 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/ponty96/simple-web-app/internal/clients"
+	"github.com/ponty96/simple-web-app/internal/correlation"
 )
 
+// ---- clients.Processor Mock for Testing --- //
+type ClientsMock struct {
+	ID     pgtype.UUID
+	Secret string
+}
+
+func (m *ClientsMock) Authenticate(ctx context.Context, clientID string, secret string) (pgtype.UUID, error) {
+	if clientID != m.ID.String() || secret != m.Secret {
+		return pgtype.UUID{}, clients.ErrUnauthorized
+	}
+	return m.ID, nil
+}
+
+func (m *ClientsMock) CreateClient(ctx context.Context, name string) (*clients.Client, error) {
+	return &clients.Client{ClientID: m.ID.String(), Name: name, Secret: m.Secret}, nil
+}
+
+func (m *ClientsMock) SetPendingOrderTTL(ctx context.Context, clientID string, ttl *time.Duration) error {
+	return nil
+}
+
+// --- End of clients.Processor Mock ---- //
+
 func Test_NewHttp(t *testing.T) {
 	cfg := &Config{
 		Host: "localhost",
@@ -38,3 +68,100 @@ func Test_HealthCheck(t *testing.T) {
 		t.Errorf("Expected Ok status code, got %d", w.Code)
 	}
 }
+
+func Test_Authenticate(t *testing.T) {
+	cm := &ClientsMock{ID: pgtype.UUID{Bytes: [16]byte{9}, Valid: true}, Secret: "s3cret"}
+	rm := &ReturnsMock{}
+	r := NewHTTP(&Config{Host: "localhost", Port: 4050, Clients: cm, Returns: rm}).routes()
+
+	// the health check doesn't need credentials
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health-check", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected Ok status code, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/returns/abc", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected Unauthorized without credentials, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/returns/abc", nil)
+	req.Header.Set(headerClientID, cm.ID.String())
+	req.Header.Set(headerClientSecret, "wrong")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected Unauthorized with a wrong secret, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/returns/abc", nil)
+	req.Header.Set(headerClientID, cm.ID.String())
+	req.Header.Set(headerClientSecret, cm.Secret)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected Ok status code, got %d", w.Code)
+	}
+
+	if rm.Tenant != cm.ID {
+		t.Errorf("Expected the request to be scoped to %v, got %v", cm.ID, rm.Tenant)
+	}
+}
+
+func Test_Correlate(t *testing.T) {
+	var got string
+	h := correlate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = correlation.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("POST", "/webhooks/orders", nil)
+	req.Header.Set(correlation.Header, "request-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got != "request-1" || w.Header().Get(correlation.Header) != "request-1" {
+		t.Errorf("Expected the request id to be kept, got %q and %q", got, w.Header().Get(correlation.Header))
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/orders", nil))
+	if got == "" || got == "request-1" || w.Header().Get(correlation.Header) != got {
+		t.Errorf("Expected a new request id, got %q and %q", got, w.Header().Get(correlation.Header))
+	}
+
+	// every route is correlated
+	w = httptest.NewRecorder()
+	NewHTTP(&Config{}).routes().ServeHTTP(w, httptest.NewRequest("GET", "/health-check", nil))
+	if w.Header().Get(correlation.Header) == "" {
+		t.Error("Expected the response to carry its request id")
+	}
+}
+
+func Test_ServeShutdown(t *testing.T) {
+	s := NewHTTP(&Config{Host: "127.0.0.1", Port: 0})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected a graceful shutdown, got %v", err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected Serve to return nil after Shutdown, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expected Serve to return after Shutdown")
+	}
+
+	if err := NewHTTP(&Config{Host: "127.0.0.1", Port: -1}).Serve(); err == nil {
+		t.Error("Expected Serve to report that it can't listen")
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	jobs, err := s.Config.Jobs.Jobs(ctx)
+	if err != nil {
+		log.Errorf("Failed to list jobs %v", err)
+		httpWriteJSON(w, Response{
+			Message: "could not perform action",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "List Jobs",
+		Code:    http.StatusOK,
+		Data:    jobs,
+	})
+}
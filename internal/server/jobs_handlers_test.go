@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ponty96/simple-web-app/internal/scheduler"
+)
+
+// ---- scheduler.Scheduler Mock for Testing --- //
+type JobsMock struct{}
+
+func (m *JobsMock) Jobs(ctx context.Context) ([]scheduler.JobStatus, error) {
+	return []scheduler.JobStatus{{Name: "review-escalation", Schedule: "@every 1m", Runs: []scheduler.Run{}}}, nil
+}
+
+// --- End of scheduler.Scheduler Mock ---- //
+
+func Test_ListJobs(t *testing.T) {
+	r := NewHTTP(&Config{Host: "localhost", Port: 4050, Jobs: &JobsMock{}, AdminToken: "t0ken"}).routes()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/admin/jobs", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected Unauthorized without a token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/jobs", nil)
+	req.Header.Set(headerAdminToken, "t0ken")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected Ok status code, got %d", w.Code)
+	}
+
+	var resp struct {
+		Data []scheduler.JobStatus `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response %v", err)
+	}
+
+	if len(resp.Data) != 1 || resp.Data[0].Name != "review-escalation" {
+		t.Errorf("Expected the registered jobs, got %+v", resp.Data)
+	}
+}
+
+func Test_AdminDisabledWithoutToken(t *testing.T) {
+	r := NewHTTP(&Config{Host: "localhost", Port: 4050, Jobs: &JobsMock{}}).routes()
+
+	req := httptest.NewRequest("GET", "/admin/jobs", nil)
+	req.Header.Set(headerAdminToken, "")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected Unauthorized when no admin token is configured, got %d", w.Code)
+	}
+}
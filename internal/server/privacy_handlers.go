@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/privacy"
+)
+
+func (s *server) eraseUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req privacy.ErasureRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.RequestedBy == "" {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"requested_by": "is required"},
+		})
+		return
+	}
+
+	erasure, err := s.Config.Privacy.EraseUser(ctx, mux.Vars(r)["user_id"], req)
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "User Erased",
+		Code:    http.StatusOK,
+		Data:    erasure,
+	})
+}
+
+func (s *server) requestExport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req privacy.ExportRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.RequestedBy == "" {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"requested_by": "is required"},
+		})
+		return
+	}
+
+	e, err := s.Config.Privacy.RequestExport(ctx, mux.Vars(r)["user_id"], req)
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Export Requested",
+		Code:    http.StatusAccepted,
+		Data:    e,
+	})
+}
+
+func (s *server) getExport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	e, err := s.Config.Privacy.GetExport(ctx, mux.Vars(r)["export_id"])
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Get Export",
+		Code:    http.StatusOK,
+		Data:    e,
+	})
+}
+
+// downloadExport serves the archive itself rather than a Response
+func (s *server) downloadExport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id := mux.Vars(r)["export_id"]
+
+	archive, err := s.Config.Privacy.DownloadExport(ctx, id)
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"export-%s.json\"", id))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+func writePrivacyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, privacy.ErrNotFound), errors.Is(err, privacy.ErrExportNotFound):
+		httpWriteJSON(w, Response{
+			Message: "not found",
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, privacy.ErrExportNotReady):
+		httpWriteJSON(w, Response{
+			Message: err.Error(),
+			Code:    http.StatusConflict,
+		})
+	default:
+		log.Errorf("Failed to process privacy request %v", err)
+		httpWriteJSON(w, Response{
+			Message: "could not perform action",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
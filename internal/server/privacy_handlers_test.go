@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/privacy"
+)
+
+// ---- privacy.Processor Mock for Testing --- //
+type PrivacyMock struct {
+	Request *privacy.ErasureRequest
+	Archive []byte
+	Err     error
+}
+
+func (m *PrivacyMock) EraseUser(ctx context.Context, userID string, req privacy.ErasureRequest) (*privacy.Erasure, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	m.Request = &req
+	return &privacy.Erasure{UserID: userID, RequestedBy: req.RequestedBy}, nil
+}
+
+func (m *PrivacyMock) RequestExport(ctx context.Context, userID string, req privacy.ExportRequest) (*privacy.Export, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &privacy.Export{ExportID: "ex-1", UserID: userID, Status: "pending"}, nil
+}
+
+func (m *PrivacyMock) GetExport(ctx context.Context, id string) (*privacy.Export, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &privacy.Export{ExportID: id, Status: "completed"}, nil
+}
+
+func (m *PrivacyMock) DownloadExport(ctx context.Context, id string) ([]byte, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Archive, nil
+}
+
+// --- End of privacy.Processor Mock ---- //
+
+func Test_EraseUser(t *testing.T) {
+	pm := &PrivacyMock{}
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Privacy: pm})
+
+	req := httptest.NewRequest("POST", "/users/u-1/erasure", strings.NewReader(`{"reason": "ticket-42"}`))
+	w := httptest.NewRecorder()
+	s.eraseUser(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 without requested_by, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/users/u-1/erasure", strings.NewReader(`{"requested_by": "dpo", "reason": "ticket-42"}`))
+	req = mux.SetURLVars(req, map[string]string{"user_id": "u-1"})
+	w = httptest.NewRecorder()
+	s.eraseUser(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if pm.Request == nil || pm.Request.Reason != "ticket-42" {
+		t.Errorf("Expected the request to be passed on, got %+v", pm.Request)
+	}
+}
+
+func Test_EraseUserNotFound(t *testing.T) {
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Privacy: &PrivacyMock{Err: privacy.ErrNotFound}})
+
+	req := httptest.NewRequest("POST", "/users/u-1/erasure", strings.NewReader(`{"requested_by": "dpo"}`))
+	w := httptest.NewRecorder()
+	s.eraseUser(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func Test_RequestExport(t *testing.T) {
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Privacy: &PrivacyMock{}})
+
+	req := httptest.NewRequest("POST", "/users/u-1/exports", strings.NewReader(`{"requested_by": "support"}`))
+	req = mux.SetURLVars(req, map[string]string{"user_id": "u-1"})
+	w := httptest.NewRecorder()
+	s.requestExport(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected %d, got %d", http.StatusAccepted, w.Code)
+	}
+}
+
+func Test_DownloadExport(t *testing.T) {
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Privacy: &PrivacyMock{Archive: []byte(`{"user_id":"u-1"}`)}})
+
+	req := httptest.NewRequest("GET", "/exports/ex-1/download", nil)
+	req = mux.SetURLVars(req, map[string]string{"export_id": "ex-1"})
+	w := httptest.NewRecorder()
+	s.downloadExport(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if w.Header().Get("Content-Disposition") != `attachment; filename="export-ex-1.json"` {
+		t.Errorf("Expected the archive to be an attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+
+	if w.Body.String() != `{"user_id":"u-1"}` {
+		t.Errorf("Expected the archive to be served as is, got %s", w.Body.String())
+	}
+
+	s = NewHTTP(&Config{Host: "localhost", Port: 4050, Privacy: &PrivacyMock{Err: errors.Wrap(privacy.ErrExportNotReady, "export is pending")}})
+	w = httptest.NewRecorder()
+	s.downloadExport(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected %d for a pending export, got %d", http.StatusConflict, w.Code)
+	}
+}
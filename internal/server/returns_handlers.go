@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/returns"
+)
+
+func (s *server) requestReturn(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req returns.Request
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	v := make(map[string]string)
+	if req.Reason == "" {
+		v["reason"] = "is required"
+	}
+	if len(req.Items) == 0 {
+		v["items"] = "is required"
+	}
+	for _, item := range req.Items {
+		if item.OrderItemID == "" {
+			v["items.order_item_id"] = "is required"
+		}
+		if item.Quantity <= 0 {
+			v["items.quantity"] = "must be greater than 0"
+		}
+	}
+
+	if len(v) > 0 {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    v,
+		})
+		return
+	}
+
+	ret, err := s.Config.Returns.RequestReturn(ctx, mux.Vars(r)["order_id"], req)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Return Requested",
+		Code:    http.StatusCreated,
+		Data:    ret,
+	})
+}
+
+func (s *server) listOrderReturns(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	rets, err := s.Config.Returns.ListOrderReturns(ctx, mux.Vars(r)["order_id"])
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "List Order Returns",
+		Code:    http.StatusOK,
+		Data:    rets,
+	})
+}
+
+func (s *server) getReturn(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	ret, err := s.Config.Returns.GetReturn(ctx, mux.Vars(r)["return_id"])
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Get Return",
+		Code:    http.StatusOK,
+		Data:    ret,
+	})
+}
+
+func (s *server) approveReturn(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	ret, err := s.Config.Returns.Approve(ctx, mux.Vars(r)["return_id"])
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Return Approved",
+		Code:    http.StatusOK,
+		Data:    ret,
+	})
+}
+
+func (s *server) rejectReturn(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Reason == "" {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"reason": "is required"},
+		})
+		return
+	}
+
+	ret, err := s.Config.Returns.Reject(ctx, mux.Vars(r)["return_id"], req.Reason)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Return Rejected",
+		Code:    http.StatusOK,
+		Data:    ret,
+	})
+}
+
+func (s *server) receiveReturn(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	ret, err := s.Config.Returns.Receive(ctx, mux.Vars(r)["return_id"])
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Return Received",
+		Code:    http.StatusOK,
+		Data:    ret,
+	})
+}
+
+func (s *server) inspectReturn(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// the body is optional: with no items every returned unit is accepted
+	var req struct {
+		Items []returns.InspectedItem `json:"items"`
+	}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+
+	ret, err := s.Config.Returns.Inspect(ctx, mux.Vars(r)["return_id"], req.Items)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Return Inspected",
+		Code:    http.StatusOK,
+		Data:    ret,
+	})
+}
+
+func writeReturnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, returns.ErrNotFound):
+		httpWriteJSON(w, Response{
+			Message: "not found",
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, returns.ErrInvalidItems):
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"items": err.Error()},
+		})
+	case errors.Is(err, returns.ErrNotReturnable), errors.Is(err, returns.ErrInvalidTransition):
+		httpWriteJSON(w, Response{
+			Message: err.Error(),
+			Code:    http.StatusConflict,
+		})
+	default:
+		log.Errorf("Failed to process return %v", err)
+		httpWriteJSON(w, Response{
+			Message: "could not perform action",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
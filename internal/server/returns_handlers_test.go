@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/returns"
+	"github.com/ponty96/simple-web-app/internal/tenant"
+)
+
+// ---- returns.Processor Mock for Testing --- //
+type ReturnsMock struct {
+	Requested *returns.Request
+	Inspected []returns.InspectedItem
+	Tenant    pgtype.UUID
+	Err       error
+}
+
+func (m *ReturnsMock) RequestReturn(ctx context.Context, orderID string, req returns.Request) (*returns.Return, error) {
+	m.Requested = &req
+	return m.result(orderID, "requested")
+}
+
+func (m *ReturnsMock) GetReturn(ctx context.Context, id string) (*returns.Return, error) {
+	m.Tenant, _ = tenant.FromContext(ctx)
+	return m.result("", "requested")
+}
+
+func (m *ReturnsMock) ListOrderReturns(ctx context.Context, orderID string) ([]returns.Return, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return []returns.Return{}, nil
+}
+
+func (m *ReturnsMock) Approve(ctx context.Context, id string) (*returns.Return, error) {
+	return m.result("", "approved")
+}
+
+func (m *ReturnsMock) Reject(ctx context.Context, id string, reason string) (*returns.Return, error) {
+	return m.result("", "rejected")
+}
+
+func (m *ReturnsMock) Receive(ctx context.Context, id string) (*returns.Return, error) {
+	return m.result("", "received")
+}
+
+func (m *ReturnsMock) Inspect(ctx context.Context, id string, items []returns.InspectedItem) (*returns.Return, error) {
+	m.Inspected = items
+	return m.result("", "inspected")
+}
+
+func (m *ReturnsMock) result(orderID, status string) (*returns.Return, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &returns.Return{OrderID: orderID, Status: status}, nil
+}
+
+// --- End of returns.Processor Mock ---- //
+
+func Test_RequestReturnSuccess(t *testing.T) {
+	rm := &ReturnsMock{}
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Returns: rm})
+
+	payload := `{
+		"reason": "wrong size",
+		"items": [{"order_item_id": "item-1", "quantity": 1}]
+	}`
+
+	req := httptest.NewRequest("POST", "/orders/order-1/returns", strings.NewReader(payload))
+	req = mux.SetURLVars(req, map[string]string{"order_id": "order-1"})
+	w := httptest.NewRecorder()
+
+	s.requestReturn(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	if rm.Requested == nil || len(rm.Requested.Items) != 1 {
+		t.Fatal("Expected the return request to be passed to the processor")
+	}
+
+	if rm.Requested.Items[0].OrderItemID != "item-1" {
+		t.Errorf("Expected order item item-1, got %s", rm.Requested.Items[0].OrderItemID)
+	}
+}
+
+func Test_RequestReturnRequiredFields(t *testing.T) {
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Returns: &ReturnsMock{}})
+
+	req := httptest.NewRequest("POST", "/orders/order-1/returns", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+
+	s.requestReturn(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", w.Code)
+	}
+
+	var r Response
+	if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+		t.Fatalf("Failed to decode response %v", err)
+	}
+
+	for _, field := range []string{"reason", "items"} {
+		if r.Errs[field] != "is required" {
+			t.Errorf("Expected validation error for %s", field)
+		}
+	}
+}
+
+func Test_ReturnErrorStatusCodes(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		code int
+	}{
+		"not found":          {returns.ErrNotFound, http.StatusNotFound},
+		"invalid items":      {errors.Wrap(returns.ErrInvalidItems, "too many"), http.StatusUnprocessableEntity},
+		"not returnable":     {errors.Wrap(returns.ErrNotReturnable, "order is pending"), http.StatusConflict},
+		"invalid transition": {errors.Wrap(returns.ErrInvalidTransition, "cannot approve"), http.StatusConflict},
+		"unexpected":         {errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := NewHTTP(&Config{Host: "localhost", Port: 4050, Returns: &ReturnsMock{Err: c.err}})
+
+			req := httptest.NewRequest("POST", "/returns/ret-1/approve", nil)
+			w := httptest.NewRecorder()
+
+			s.approveReturn(w, req)
+
+			if w.Code != c.code {
+				t.Errorf("Expected %d, got %d", c.code, w.Code)
+			}
+		})
+	}
+}
+
+func Test_InspectReturnWithoutBody(t *testing.T) {
+	rm := &ReturnsMock{}
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Returns: rm})
+
+	req := httptest.NewRequest("POST", "/returns/ret-1/inspect", nil)
+	w := httptest.NewRecorder()
+
+	s.inspectReturn(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if len(rm.Inspected) != 0 {
+		t.Errorf("Expected no inspected items, got %d", len(rm.Inspected))
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ponty96/simple-web-app/internal/orders"
+)
+
+func (s *server) listReviews(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	rvs, err := s.Config.Reviews.ListReviews(ctx)
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "List Reviews",
+		Code:    http.StatusOK,
+		Data:    rvs,
+	})
+}
+
+func (s *server) getReview(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	rv, err := s.Config.Reviews.GetReview(ctx, mux.Vars(r)["review_id"])
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Get Review",
+		Code:    http.StatusOK,
+		Data:    rv,
+	})
+}
+
+func (s *server) assignReview(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req struct {
+		Assignee string `json:"assignee"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Assignee == "" {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    map[string]string{"assignee": "is required"},
+		})
+		return
+	}
+
+	rv, err := s.Config.Reviews.AssignReview(ctx, mux.Vars(r)["review_id"], req.Assignee)
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, Response{
+		Message: "Review Assigned",
+		Code:    http.StatusOK,
+		Data:    rv,
+	})
+}
+
+func (s *server) approveReview(w http.ResponseWriter, r *http.Request) {
+	s.decideReview(w, r, false)
+}
+
+func (s *server) rejectReview(w http.ResponseWriter, r *http.Request) {
+	s.decideReview(w, r, true)
+}
+
+// decideReview approves or rejects a review. Rejections must give a reason as
+// it is recorded as the reason the order was cancelled.
+func (s *server) decideReview(w http.ResponseWriter, r *http.Request, reject bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var d orders.Decision
+	if !readJSON(w, r, &d) {
+		return
+	}
+
+	v := make(map[string]string)
+	if d.Reviewer == "" {
+		v["reviewer"] = "is required"
+	}
+	if reject && d.Reason == "" {
+		v["reason"] = "is required"
+	}
+
+	if len(v) > 0 {
+		httpWriteJSON(w, Response{
+			Message: "validation failed",
+			Code:    http.StatusUnprocessableEntity,
+			Errs:    v,
+		})
+		return
+	}
+
+	id := mux.Vars(r)["review_id"]
+
+	var (
+		rv  *orders.Review
+		err error
+	)
+	if reject {
+		rv, err = s.Config.Reviews.RejectReview(ctx, id, d)
+	} else {
+		rv, err = s.Config.Reviews.ApproveReview(ctx, id, d)
+	}
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	message := "Review Approved"
+	if reject {
+		message = "Review Rejected"
+	}
+
+	httpWriteJSON(w, Response{
+		Message: message,
+		Code:    http.StatusOK,
+		Data:    rv,
+	})
+}
+
+func writeReviewError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, orders.ErrReviewNotFound):
+		httpWriteJSON(w, Response{
+			Message: "not found",
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, orders.ErrReviewDecided), errors.Is(err, orders.ErrInvalidStatus):
+		httpWriteJSON(w, Response{
+			Message: err.Error(),
+			Code:    http.StatusConflict,
+		})
+	default:
+		log.Errorf("Failed to process review %v", err)
+		httpWriteJSON(w, Response{
+			Message: "could not perform action",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/ponty96/simple-web-app/internal/orders"
+)
+
+// ---- orders.Reviews Mock for Testing --- //
+type ReviewsMock struct {
+	Decision *orders.Decision
+	Assignee string
+	Err      error
+}
+
+func (m *ReviewsMock) ListReviews(ctx context.Context) ([]orders.Review, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return []orders.Review{}, nil
+}
+
+func (m *ReviewsMock) GetReview(ctx context.Context, id string) (*orders.Review, error) {
+	return m.result(id, "open")
+}
+
+func (m *ReviewsMock) AssignReview(ctx context.Context, id string, assignee string) (*orders.Review, error) {
+	m.Assignee = assignee
+	return m.result(id, "open")
+}
+
+func (m *ReviewsMock) ApproveReview(ctx context.Context, id string, d orders.Decision) (*orders.Review, error) {
+	m.Decision = &d
+	return m.result(id, "approved")
+}
+
+func (m *ReviewsMock) RejectReview(ctx context.Context, id string, d orders.Decision) (*orders.Review, error) {
+	m.Decision = &d
+	return m.result(id, "rejected")
+}
+
+func (m *ReviewsMock) result(id, status string) (*orders.Review, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &orders.Review{ReviewID: id, Status: status}, nil
+}
+
+// --- End of orders.Reviews Mock ---- //
+
+func Test_RejectReviewRequiresReason(t *testing.T) {
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Reviews: &ReviewsMock{}})
+
+	req := httptest.NewRequest("POST", "/reviews/rv-1/reject", strings.NewReader(`{"reviewer": "ada"}`))
+	w := httptest.NewRecorder()
+
+	s.rejectReview(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", w.Code)
+	}
+
+	var r Response
+	if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+		t.Fatalf("Failed to decode response %v", err)
+	}
+
+	if r.Errs["reason"] != "is required" {
+		t.Errorf("Expected validation error for reason, got %v", r.Errs)
+	}
+}
+
+func Test_ApproveReview(t *testing.T) {
+	rm := &ReviewsMock{}
+	s := NewHTTP(&Config{Host: "localhost", Port: 4050, Reviews: rm})
+
+	req := httptest.NewRequest("POST", "/reviews/rv-1/approve", strings.NewReader(`{"reviewer": "ada"}`))
+	req = mux.SetURLVars(req, map[string]string{"review_id": "rv-1"})
+	w := httptest.NewRecorder()
+
+	s.approveReview(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if rm.Decision == nil || rm.Decision.Reviewer != "ada" {
+		t.Errorf("Expected the decision to be passed to the queue, got %+v", rm.Decision)
+	}
+}
+
+func Test_ReviewErrorStatusCodes(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		code int
+	}{
+		"not found":      {orders.ErrReviewNotFound, http.StatusNotFound},
+		"decided":        {errors.Wrap(orders.ErrReviewDecided, "review is approved"), http.StatusConflict},
+		"order not held": {errors.Wrap(orders.ErrInvalidStatus, "order is shipped"), http.StatusConflict},
+		"unexpected":     {errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := NewHTTP(&Config{Host: "localhost", Port: 4050, Reviews: &ReviewsMock{Err: c.err}})
+
+			req := httptest.NewRequest("POST", "/reviews/rv-1/assign", strings.NewReader(`{"assignee": "ada"}`))
+			w := httptest.NewRecorder()
+
+			s.assignReview(w, req)
+
+			if w.Code != c.code {
+				t.Errorf("Expected %d, got %d", c.code, w.Code)
+			}
+		})
+	}
+}
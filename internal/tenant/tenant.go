@@ -0,0 +1,43 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+)
+
+// Header is the RabbitMQ message header the tenant travels in
+const Header = "tenant_id"
+
+var ErrMissing = errors.New("missing tenant")
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the tenant id. It is set by the
+// HTTP authentication middleware and by the consumer from the message header.
+func NewContext(ctx context.Context, id pgtype.UUID) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func FromContext(ctx context.Context) (pgtype.UUID, bool) {
+	id, ok := ctx.Value(ctxKey{}).(pgtype.UUID)
+	return id, ok && id.Valid
+}
+
+// ID returns the tenant of ctx, or ErrMissing if there is none.
+func ID(ctx context.Context) (pgtype.UUID, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return id, ErrMissing
+	}
+	return id, nil
+}
+
+func Parse(s string) (pgtype.UUID, error) {
+	var id pgtype.UUID
+	if err := id.Scan(s); err != nil {
+		return id, errors.Wrapf(err, "invalid tenant %q", s)
+	}
+	return id, nil
+}
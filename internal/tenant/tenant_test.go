@@ -0,0 +1,32 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func Test_TenantContext(t *testing.T) {
+	if _, err := ID(context.Background()); !errors.Is(err, ErrMissing) {
+		t.Errorf("Expected ErrMissing, got %v", err)
+	}
+
+	id, err := Parse("8f14e45f-ceea-467f-a0e6-1b8c6f5c2d3a")
+	if err != nil {
+		t.Fatalf("Expected tenant to parse %s", err)
+	}
+
+	got, err := ID(NewContext(context.Background(), id))
+	if err != nil {
+		t.Fatalf("Expected a tenant %s", err)
+	}
+
+	if got != id {
+		t.Errorf("Expected %v, got %v", id, got)
+	}
+
+	if _, err := Parse("not-a-uuid"); err == nil {
+		t.Error("Expected an invalid tenant to fail")
+	}
+}